@@ -1,12 +1,38 @@
+-- countries collected by ncollector and available in the visualizer search
+CREATE TABLE Countries (
+		id SERIAL PRIMARY KEY,
+		code TEXT NOT NULL UNIQUE, -- ISO 3166-1 alpha-2 as used by NEWS API (e.g. 'it')
+		name TEXT NOT NULL UNIQUE, -- stored with the articles (e.g. 'Italy')
+		language TEXT NOT NULL, -- stored with the articles (e.g. 'Italian')
+		flag TEXT NOT NULL DEFAULT '',
+		-- crontab spec of the top headlines job of the country, NULL to collect it with the jobs of the config file only
+		schedule TEXT,
+		enabled BOOLEAN NOT NULL DEFAULT true
+);
+
+INSERT INTO Countries (code, name, language, flag, schedule) VALUES
+		('it', 'Italy', 'Italian', '🇮🇹', '0 1,4,7,10,13,16,19,22 * * *'),
+		('au', 'Australia', 'English', '🇦🇺', '5 1,4,7,10,13,16,19,22 * * *');
+
 CREATE TABLE Sources (
 		id SERIAL PRIMARY KEY,
-		name TEXT
+		name TEXT,
+		-- catalogue of the provider (e.g. NEWS API /top-headlines/sources), NULL for the sources known by name only
+		newsapi_id TEXT UNIQUE,
+		description TEXT,
+		url TEXT,
+		category TEXT,
+		language TEXT,
+		country TEXT
 );
 
 CREATE TABLE Domains (
 		id SERIAL PRIMARY KEY,
 		name TEXT,
-		favourite BOOLEAN NOT NULL DEFAULT false 
+		-- no longer used: the favourites are per user in user_favourites, these ones go to the first user created at the visualizer startup
+		favourite BOOLEAN NOT NULL DEFAULT false,
+		provider TEXT NOT NULL DEFAULT 'newsapi',
+		feed_url TEXT
 );
 
 CREATE TABLE Articles (
@@ -18,10 +44,105 @@ CREATE TABLE Articles (
 	description TEXT,
 	url         TEXT,
 	url_to_image  TEXT,
-	published_at TIMESTAMP with time zone,
+	-- NOT NULL, the key of the pages of the visualizer with the id
+	published_at TIMESTAMP with time zone NOT NULL,
 	content     TEXT,
 	country TEXT,
 	language TEXT,
-	category TEXT 
+	category TEXT,
+	-- url without utm_* params, fragment and trailing slash, see data.NormalizeURL
+	url_normalized TEXT UNIQUE,
+	-- SimHash of title and description, see the cluster package
+	fingerprint BIGINT,
+	cluster_id INT
+);
+
+-- near-duplicate articles (e.g. the same wire story republished by several outlets)
+CREATE TABLE Story_Clusters (
+	id SERIAL PRIMARY KEY,
+	fingerprint BIGINT NOT NULL,
+	representative_id INT references Articles(id),
+	created_at TIMESTAMP with time zone NOT NULL DEFAULT NOW()
 );
 
+ALTER TABLE Articles ADD CONSTRAINT articles_cluster_id_fkey FOREIGN KEY (cluster_id) REFERENCES Story_Clusters(id);
+CREATE INDEX articles_cluster_id_idx ON Articles (cluster_id);
+
+-- full-text search of the visualizer: Italian stemming for the Italian articles, English for the others.
+-- Title weighs more than description, description more than content
+ALTER TABLE Articles ADD COLUMN search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector(CASE lower(language) WHEN 'italian' THEN 'italian'::regconfig ELSE 'english'::regconfig END, coalesce(title, '')), 'A') ||
+	setweight(to_tsvector(CASE lower(language) WHEN 'italian' THEN 'italian'::regconfig ELSE 'english'::regconfig END, coalesce(description, '')), 'B') ||
+	setweight(to_tsvector(CASE lower(language) WHEN 'italian' THEN 'italian'::regconfig ELSE 'english'::regconfig END, coalesce(content, '')), 'C')
+) STORED;
+CREATE INDEX articles_search_vector_idx ON Articles USING GIN (search_vector);
+
+-- the keyset pagination of the visualizer, by date
+CREATE INDEX articles_published_at_id_idx ON Articles (published_at DESC, id DESC);
+
+
+-- token bucket of the API calls per provider, see ncollector/quota
+CREATE TABLE Api_Quotas (
+	provider TEXT PRIMARY KEY,
+	capacity INT NOT NULL,
+	period_seconds INT NOT NULL,
+	tokens DOUBLE PRECISION NOT NULL,
+	updated_at TIMESTAMP with time zone NOT NULL DEFAULT NOW()
+);
+
+-- high-water mark of each feed of a job: the newest published_at stored by the last complete run,
+-- the next run stops paginating once it gets older articles. See ncollector fetchPages
+CREATE TABLE Feed_Cursors (
+	job TEXT NOT NULL,
+	feed TEXT NOT NULL,
+	published_at TIMESTAMP with time zone NOT NULL,
+	updated_at TIMESTAMP with time zone NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (job, feed)
+);
+
+-- jobs of the collector: the schedule of its config (or of the countries table), written at every (re)load,
+-- and what the admins of the visualizer set. A disabled job isn't scheduled, schedule_override replaces the schedule.
+-- The collector reloads its jobs once updated_at changes
+CREATE TABLE Collector_Jobs (
+	name TEXT PRIMARY KEY,
+	schedule TEXT NOT NULL,
+	enabled BOOLEAN NOT NULL DEFAULT true,
+	schedule_override TEXT,
+	updated_at TIMESTAMP with time zone NOT NULL DEFAULT NOW()
+);
+
+-- users of the visualizer, managed with 'visualizer user ...'
+CREATE TABLE Users (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL DEFAULT '', -- bcrypt, empty for the single sign-on users
+	-- email of the single sign-on users, from the identity provider
+	email TEXT UNIQUE,
+	role TEXT NOT NULL DEFAULT 'viewer' CHECK (role IN ('viewer', 'editor', 'admin')),
+	disabled BOOLEAN NOT NULL DEFAULT false,
+	-- failed logins in a row, the account is locked until locked_until once they reach the max
+	failed_logins INT NOT NULL DEFAULT 0,
+	locked_until TIMESTAMP with time zone,
+	-- the password must be changed at the next login, e.g. the one of USER_AUTH too short
+	must_change_password BOOLEAN NOT NULL DEFAULT false,
+	-- generation of the tokens of the user, incremented to revoke all of them (disabled user, new role)
+	token_generation INT NOT NULL DEFAULT 0,
+	created_at TIMESTAMP with time zone NOT NULL DEFAULT NOW(),
+	updated_at TIMESTAMP with time zone NOT NULL DEFAULT NOW()
+);
+
+-- favourite feeds of each user, the collector searches the favourites of all the users
+CREATE TABLE user_favourites (
+	user_id INT NOT NULL REFERENCES Users(id) ON DELETE CASCADE,
+	domain_id INT NOT NULL REFERENCES Domains(id) ON DELETE CASCADE,
+	created_at TIMESTAMP with time zone NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, domain_id)
+);
+
+CREATE INDEX user_favourites_domain_id_idx ON user_favourites (domain_id);
+
+-- ids (jti) of the tokens revoked at the logout, until they expire
+CREATE TABLE revoked_tokens (
+	jti TEXT PRIMARY KEY,
+	expires_at TIMESTAMP with time zone NOT NULL
+);
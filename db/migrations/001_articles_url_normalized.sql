@@ -0,0 +1,47 @@
+-- articles.url_normalized, UNIQUE: the collector stores the articles with INSERT ... ON CONFLICT (url_normalized),
+-- failing on a DB without the column or its constraint.
+-- The column is filled as ncollector data.NormalizeURL does: lowercase scheme and host, no credentials, no fragment,
+-- no utm_* params, the others sorted by key, no trailing slash (the escaping of the URLs is left as it is).
+-- Of the articles with the same normalized URL the first one stored keeps it, the others are left without.
+BEGIN;
+
+CREATE FUNCTION pg_temp.normalize_url(raw TEXT) RETURNS TEXT AS $$
+DECLARE
+	s TEXT := split_part(btrim(raw), '#', 1);
+	origin TEXT;
+	path TEXT;
+	params TEXT;
+BEGIN
+	-- not an absolute URL
+	IF s !~ '^[a-zA-Z][a-zA-Z0-9+.-]*://[^/?]' THEN
+		RETURN rtrim(s, '/');
+	END IF;
+
+	origin := substring(s FROM '^[^:]+://[^/?]*');
+	path := split_part(substr(s, length(origin) + 1), '?', 1);
+
+	SELECT string_agg(p, '&' ORDER BY split_part(p, '=', 1), n) INTO params
+	FROM regexp_split_to_table(substr(s, length(origin) + length(path) + 2), '&') WITH ORDINALITY AS t(p, n)
+	WHERE p <> '' AND lower(p) NOT LIKE 'utm\_%';
+
+	RETURN lower(regexp_replace(origin, '://[^@/]*@', '://')) || rtrim(path, '/') || COALESCE('?' || params, '');
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
+
+ALTER TABLE Articles ADD COLUMN IF NOT EXISTS url_normalized TEXT;
+
+UPDATE Articles SET url_normalized = pg_temp.normalize_url(url)
+WHERE url_normalized IS NULL AND url IS NOT NULL;
+
+UPDATE Articles a SET url_normalized = NULL
+WHERE EXISTS (SELECT 1 FROM Articles b WHERE b.url_normalized = a.url_normalized AND b.id < a.id);
+
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'articles_url_normalized_key') THEN
+		ALTER TABLE Articles ADD CONSTRAINT articles_url_normalized_key UNIQUE (url_normalized);
+	END IF;
+END;
+$$;
+
+COMMIT;
@@ -0,0 +1,9 @@
+-- articles.published_at becomes NOT NULL: the pages of the visualizer are keyed on (published_at, id)
+-- and a row without a date was left out by the keyset of the next pages and failed the scan of the first one.
+-- The articles without a date get the day of their story cluster, the epoch (the last page) if none.
+UPDATE Articles a SET published_at = COALESCE(
+	(SELECT c.created_at FROM Story_Clusters c WHERE c.id = a.cluster_id),
+	'1970-01-01T00:00:00Z'
+) WHERE published_at IS NULL;
+
+ALTER TABLE Articles ALTER COLUMN published_at SET NOT NULL;
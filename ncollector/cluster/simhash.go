@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// MaxDistance is the max number of different bits between two fingerprints
+// for the articles to be considered the same story
+const MaxDistance = 8
+
+// words too common to tell two stories apart, in the languages we collect (English and Italian)
+var stopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "that": true, "this": true, "from": true,
+	"are": true, "was": true, "has": true, "have": true, "will": true, "its": true, "his": true, "her": true,
+	"del": true, "della": true, "delle": true, "dei": true, "degli": true, "che": true, "per": true,
+	"con": true, "una": true, "uno": true, "nel": true, "nella": true, "sul": true, "sulla": true,
+	"alla": true, "alle": true, "non": true, "sono": true,
+}
+
+// Fingerprint computes the 64 bits SimHash of the text (usually title + description).
+// Similar texts have fingerprints differing only in a few bits, see Distance.
+// ok is false when the text has no words to tell the story apart (empty, or short and stop words only):
+// all those texts would have the same fingerprint and be taken for the same story.
+func Fingerprint(text string) (fingerprint uint64, ok bool) {
+
+	var weights [64]int
+
+	textFeatures := features(text)
+	if len(textFeatures) == 0 {
+		return 0, false
+	}
+
+	for _, feature := range textFeatures {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+
+		for i := 0; i < 64; i++ {
+			if sum&(1<<uint(i)) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	for i := 0; i < 64; i++ {
+		if weights[i] > 0 {
+			fingerprint |= 1 << uint(i)
+		}
+	}
+
+	return fingerprint, true
+}
+
+// Distance is the Hamming distance between two fingerprints
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// features returns the words of the text and the pairs of consecutive words,
+// the latter to keep some of the word order in the fingerprint
+func features(text string) []string {
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	kept := make([]string, 0, len(words))
+	for _, w := range words {
+		if len([]rune(w)) < 3 || stopWords[w] {
+			continue
+		}
+		kept = append(kept, w)
+	}
+
+	features := make([]string, 0, 2*len(kept))
+	for i, w := range kept {
+		features = append(features, w)
+		if i > 0 {
+			features = append(features, kept[i-1]+" "+w)
+		}
+	}
+
+	return features
+}
@@ -0,0 +1,29 @@
+package cluster
+
+import "testing"
+
+func TestFingerprintNoWords(t *testing.T) {
+
+	for _, text := range []string{"", "   ", "a b c", "Il re è lì", "the and with", "— · —"} {
+		if f, ok := Fingerprint(text); ok {
+			t.Errorf("Fingerprint(%q) = %x, true, want no fingerprint", text, f)
+		}
+	}
+}
+
+func TestFingerprintNearDuplicates(t *testing.T) {
+
+	a, okA := Fingerprint("Covid: in Italia 10.172 nuovi casi e 72 vittime. Il tasso di positività sale al 2,4%")
+	b, okB := Fingerprint("Covid, in Italia 10.172 nuovi casi e 72 vittime. Tasso di positività al 2,4%")
+	c, okC := Fingerprint("Manovra, il governo approva il decreto fiscale collegato alla legge di Bilancio")
+	if !okA || !okB || !okC {
+		t.Fatal("no fingerprint for texts with words")
+	}
+
+	if d := Distance(a, b); d > MaxDistance {
+		t.Errorf("distance of the same story = %d, want at most %d", d, MaxDistance)
+	}
+	if d := Distance(a, c); d <= MaxDistance {
+		t.Errorf("distance of different stories = %d, want more than %d", d, MaxDistance)
+	}
+}
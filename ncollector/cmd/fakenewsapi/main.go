@@ -0,0 +1,22 @@
+// fakenewsapi serves the canned NEWS API fixtures of the newsapitest package,
+// to run the collector offline: NEWS_API_URL=http://localhost:8081 ./ncollector
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/mesmerai/news-aggregator/ncollector/news/newsapitest"
+)
+
+func main() {
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8081"
+	}
+
+	log.Println("Fake NEWS API listening on port", port)
+	log.Fatal(http.ListenAndServe(":"+port, newsapitest.Handler()))
+}
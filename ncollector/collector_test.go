@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/ncollector/config"
+	"github.com/mesmerai/news-aggregator/ncollector/data"
+	"github.com/mesmerai/news-aggregator/ncollector/news"
+	"github.com/mesmerai/news-aggregator/ncollector/news/newsapitest"
+)
+
+// ** In-memory store **
+// The collection runs against the fake NEWS API of newsapitest and memDB, a store on in-memory tables
+// following the semantics of the data package (e.g. an article with a normalized URL already stored is unchanged).
+
+type memArticle struct {
+	id, sourceID, domainID int
+	title, description     string
+	url                    string
+	publishedAt            time.Time
+	country, category      string
+	clusterID              int
+}
+
+type memSource struct {
+	id              int
+	newsapiID, name string
+}
+
+type memDB struct {
+	mu       sync.Mutex
+	domains  map[string]int
+	sources  []memSource
+	articles []*memArticle
+	byURL    map[string]*memArticle
+	cursors  map[string]time.Time
+	clusters map[int]uint64
+}
+
+// newMemDB returns a new in-memory store
+func newMemDB() *memDB {
+	return &memDB{domains: map[string]int{}, byURL: map[string]*memArticle{}, cursors: map[string]time.Time{}, clusters: map[int]uint64{}}
+}
+
+func (db *memDB) GetFavourites() ([]data.Feed, error) {
+	return nil, nil
+}
+
+func (db *memDB) GetDomainsByName(name string) ([]data.Domain, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if domainID, ok := db.domains[name]; ok {
+		return []data.Domain{{ID: domainID, Name: name}}, nil
+	}
+	return nil, nil
+}
+
+func (db *memDB) InsertDomain(domainName string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.domains[domainName] = len(db.domains) + 1
+	return len(db.domains), nil
+}
+
+func (db *memDB) GetCursor(job, feed string) (time.Time, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.cursors[job+"|"+feed], nil
+}
+
+func (db *memDB) SetCursor(job, feed string, publishedAt time.Time) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// the cursor never goes back
+	if key := job + "|" + feed; publishedAt.After(db.cursors[key]) {
+		db.cursors[key] = publishedAt
+	}
+	return nil
+}
+
+func (db *memDB) GetSourcesByName(name string) ([]data.Source, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var sources []data.Source
+	for _, s := range db.sources {
+		if s.name == name {
+			sources = append(sources, data.Source{ID: s.id, Name: s.name})
+		}
+	}
+	return sources, nil
+}
+
+func (db *memDB) GetSourceByNewsAPIID(newsapiID string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, s := range db.sources {
+		if s.newsapiID == newsapiID {
+			return s.id, nil
+		}
+	}
+	return 0, nil
+}
+
+func (db *memDB) InsertSource(newsapiID, sourceName string) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// a source known by name only gets the id
+	if newsapiID != "" {
+		for i, s := range db.sources {
+			if s.name == sourceName && s.newsapiID == "" {
+				db.sources[i].newsapiID = newsapiID
+				return s.id, nil
+			}
+		}
+	}
+
+	db.sources = append(db.sources, memSource{id: len(db.sources) + 1, newsapiID: newsapiID, name: sourceName})
+	return len(db.sources), nil
+}
+
+func (db *memDB) UpsertSource(source data.CatalogueSource) (data.UpsertResult, error) {
+	return data.ArticleUnchanged, fmt.Errorf("memdb: UpsertSource not supported")
+}
+
+func (db *memDB) UpsertArticle(sourceID, domainID int, author string, title string, description string, url string, urlToImage string, publishedAt time.Time, content, country, language, category string) (data.UpsertResult, int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// unchanged if the normalized URL is already stored
+	normalized := data.NormalizeURL(url)
+	if _, ok := db.byURL[normalized]; ok {
+		return data.ArticleUnchanged, 0, nil
+	}
+
+	a := &memArticle{id: len(db.articles) + 1, sourceID: sourceID, domainID: domainID, title: title, description: description, url: url,
+		publishedAt: publishedAt, country: country, category: category}
+	db.articles = append(db.articles, a)
+	db.byURL[normalized] = a
+	return data.ArticleInserted, a.id, nil
+}
+
+func (db *memDB) GetArticlesToCluster(ids []int) ([]data.ArticleToCluster, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var articles []data.ArticleToCluster
+	for _, id := range ids {
+		if id > 0 && id <= len(db.articles) && db.articles[id-1].clusterID == 0 {
+			a := db.articles[id-1]
+			articles = append(articles, data.ArticleToCluster{ID: a.id, Title: a.title, Description: a.description})
+		}
+	}
+	return articles, nil
+}
+
+func (db *memDB) GetRecentClusters(days int) ([]data.StoryCluster, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var clusters []data.StoryCluster
+	for clusterID, fingerprint := range db.clusters {
+		clusters = append(clusters, data.StoryCluster{ID: clusterID, Fingerprint: fingerprint})
+	}
+	return clusters, nil
+}
+
+func (db *memDB) InsertCluster(articleID int, fingerprint uint64) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	clusterID := len(db.clusters) + 1
+	db.clusters[clusterID] = fingerprint
+	return clusterID, nil
+}
+
+func (db *memDB) SetArticleCluster(articleID, clusterID int, fingerprint uint64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.articles[articleID-1].clusterID = clusterID
+	return nil
+}
+
+// ** Tests **
+
+func newsAPIClient(t *testing.T) *news.Client {
+
+	server := newsapitest.NewServer()
+	t.Cleanup(server.Close)
+
+	return news.NewClient(server.Client(), "key", 100, news.WithBaseURL(server.URL))
+}
+
+func checkRun(t *testing.T, stats *RunStats, inserted, unchanged int) {
+	t.Helper()
+	for _, err := range stats.Errors {
+		t.Errorf("error of the run: %v", err)
+	}
+	if stats.Inserted != inserted || stats.Unchanged != unchanged {
+		t.Errorf("inserted %d, unchanged %d, want %d and %d", stats.Inserted, stats.Unchanged, inserted, unchanged)
+	}
+}
+
+func TestCollectCountry(t *testing.T) {
+
+	db := newMemDB()
+	provider := newsAPIClient(t)
+
+	country := data.Country{Code: "it", Name: "Italy", Language: "Italian"}
+	// 2 pages of the 4 headlines
+	job := config.Job{Name: "italy", Country: "it", PageSize: 2, MaxPages: 5, Priority: "high"}
+
+	stats := CountryFetchAndStore(db, provider, job, country)
+	ClusterAndStore(db, stats)
+	checkRun(t, stats, 4, 0)
+
+	for _, domain := range []string{"ansa.it", "corriere.it", "ilsole24ore.com", "repubblica.it"} {
+		if _, ok := db.domains[domain]; !ok {
+			t.Errorf("domain '%s' not stored, domains: %v", domain, db.domains)
+		}
+	}
+	for _, a := range db.articles {
+		if a.country != "Italy" || a.clusterID == 0 {
+			t.Errorf("article %s: country '%s', cluster %d", a.url, a.country, a.clusterID)
+		}
+	}
+
+	// the source with the NEWS API id is linked by id
+	if s := db.sources[0]; s.newsapiID != "ansa" || s.name != "ANSA.it" {
+		t.Errorf("first source = %+v", s)
+	}
+
+	if mark, want := db.cursors["italy|it"], time.Date(2021, 11, 20, 9, 30, 0, 0, time.UTC); !mark.Equal(want) {
+		t.Errorf("cursor = %v, want the newest article %v", mark, want)
+	}
+
+	// the next run finds the same headlines, already stored
+	stats = CountryFetchAndStore(db, provider, job, country)
+	ClusterAndStore(db, stats)
+	checkRun(t, stats, 0, 4)
+	if len(db.articles) != 4 || len(db.sources) != 4 {
+		t.Errorf("articles %d, sources %d after the second run, want 4 of each", len(db.articles), len(db.sources))
+	}
+}
+
+func TestCollectCountryCategory(t *testing.T) {
+
+	db := newMemDB()
+	provider := newsAPIClient(t)
+
+	country := data.Country{Code: "au", Name: "Australia", Language: "English"}
+	job := config.Job{Name: "australia", Country: "au", Categories: []string{"sports"}, PageSize: 100, MaxPages: 1}
+
+	stats := CountryFetchAndStore(db, provider, job, country)
+	checkRun(t, stats, 3, 0)
+
+	for _, a := range db.articles {
+		if a.category != "sports" {
+			t.Errorf("article %s: category '%s'", a.url, a.category)
+		}
+	}
+	if _, ok := db.cursors["australia|au/sports"]; !ok {
+		t.Errorf("no cursor of the category, cursors: %v", db.cursors)
+	}
+}
+
+func TestCollectDomains(t *testing.T) {
+
+	db := newMemDB()
+	providers := news.NewRegistry(newsAPIClient(t))
+
+	job := config.Job{Name: "world", Provider: "newsapi", Domains: []string{"reuters.com", "bbc.co.uk", "techcrunch.com"}, PageSize: 100, MaxPages: 3}
+
+	stats := GlobalFetchAndStore(db, providers, job)
+	ClusterAndStore(db, stats)
+	checkRun(t, stats, 5, 0)
+
+	// the articles are stored with the domain of the job they belong to
+	byDomain := map[int]int{}
+	for _, a := range db.articles {
+		byDomain[a.domainID]++
+	}
+	for domain, want := range map[string]int{"reuters.com": 2, "bbc.co.uk": 1, "techcrunch.com": 2} {
+		if got := byDomain[db.domains[domain]]; got != want {
+			t.Errorf("articles of '%s' = %d, want %d", domain, got, want)
+		}
+	}
+
+	// the articles of the run are clustered
+	for _, a := range db.articles {
+		if a.clusterID == 0 {
+			t.Errorf("article %s not clustered", a.url)
+		}
+	}
+
+	// each domain resumes from its newest article
+	if mark, want := db.cursors["world|techcrunch.com"], time.Date(2021, 11, 20, 16, 0, 0, 0, time.UTC); !mark.Equal(want) {
+		t.Errorf("cursor of techcrunch.com = %v, want %v", mark, want)
+	}
+
+	// the next run asks from the oldest mark less the overlap: the articles of the last hours come again, already stored
+	stats = GlobalFetchAndStore(db, providers, job)
+	ClusterAndStore(db, stats)
+	for _, err := range stats.Errors {
+		t.Errorf("error of the second run: %v", err)
+	}
+	if stats.Inserted != 0 || len(db.articles) != 5 {
+		t.Errorf("second run inserted %d, articles %d, want 0 and 5", stats.Inserted, len(db.articles))
+	}
+}
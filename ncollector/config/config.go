@@ -0,0 +1,193 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/mesmerai/news-aggregator/ncollector/news"
+)
+
+// defaults applied to the jobs not setting them
+const (
+	DefaultProvider = "newsapi"
+	DefaultPageSize = 100
+	DefaultMaxPages = 1
+	DefaultPriority = "normal"
+	MaxPageSize     = 100
+)
+
+// Job is a collection job as described in the config file
+type Job struct {
+	Name       string   `yaml:"name"`       // identifies the job in the logs, must be unique
+	Provider   string   `yaml:"provider"`   // news.Provider to fetch with (default 'newsapi')
+	Query      string   `yaml:"query"`      // free text search, empty for everything
+	Country    string   `yaml:"country"`    // ISO code of a country in the 'countries' table, for top headlines
+	Categories []string `yaml:"categories"` // top headlines categories of the country, one call each (default all the headlines)
+	Domains    []string `yaml:"domains"`    // domains to search
+	Favourites bool     `yaml:"favourites"` // search the favourite domains, each one with its own provider
+	Sources    bool     `yaml:"sources"`    // sync the source catalogue of the provider instead of fetching articles
+	Language   string   `yaml:"language"`   // ISO 639-1 code of the articles
+	Schedule   string   `yaml:"schedule"`   // crontab spec of the job
+	PageSize   int      `yaml:"page_size"`  // articles per API call (max 100)
+	MaxPages   int      `yaml:"max_pages"`  // max number of pages fetched per run
+	Priority   string   `yaml:"priority"`   // high, normal or low: low priority jobs are skipped first when the API quota runs out
+}
+
+// Config is the content of the collector config file
+type Config struct {
+	Jobs []Job `yaml:"jobs"`
+}
+
+var countryCode = regexp.MustCompile(`^[a-z]{2}$`)
+
+// Load reads, parses and validates the config file
+func Load(path string) (*Config, error) {
+
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file => %v", err)
+	}
+
+	return Parse(body)
+}
+
+// Parse decodes the YAML config, sets the defaults and validates it.
+// Unknown keys are reported as errors, to catch typos like 'pagesize'.
+func Parse(body []byte) (*Config, error) {
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(body, config); err != nil {
+		return nil, fmt.Errorf("error parsing config file => %v", err)
+	}
+
+	config.setDefaults()
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) setDefaults() {
+	for i := range c.Jobs {
+		job := &c.Jobs[i]
+		if job.Provider == "" && !job.Favourites {
+			job.Provider = DefaultProvider
+		}
+		if job.PageSize == 0 {
+			job.PageSize = DefaultPageSize
+		}
+		if job.MaxPages == 0 {
+			job.MaxPages = DefaultMaxPages
+		}
+		if job.Priority == "" {
+			job.Priority = DefaultPriority
+		}
+	}
+}
+
+// Validate checks all the jobs, returning one error listing every problem found
+func (c *Config) Validate() error {
+
+	var problems []string
+	names := map[string]bool{}
+
+	if len(c.Jobs) == 0 {
+		problems = append(problems, "no jobs defined")
+	}
+
+	for i, job := range c.Jobs {
+
+		// jobs are referred by position until they have a name
+		ref := fmt.Sprintf("job #%d", i+1)
+		if job.Name != "" {
+			ref = fmt.Sprintf("job #%d '%s'", i+1, job.Name)
+		}
+
+		report := func(format string, args ...interface{}) {
+			problems = append(problems, ref+": "+fmt.Sprintf(format, args...))
+		}
+
+		if job.Name == "" {
+			report("name is required")
+		} else if names[job.Name] {
+			report("name is already used by another job")
+		}
+		names[job.Name] = true
+
+		if job.Schedule == "" {
+			report("schedule is required")
+		} else if len(strings.Fields(job.Schedule)) != 5 {
+			report("schedule '%s' must have 5 fields (minute hour day month weekday)", job.Schedule)
+		}
+
+		targets := 0
+		if job.Country != "" {
+			targets++
+			if !countryCode.MatchString(job.Country) {
+				report("country '%s' must be a lowercase ISO 3166-1 alpha-2 code (e.g. 'it')", job.Country)
+			}
+		}
+		if len(job.Domains) > 0 {
+			targets++
+		}
+		if job.Favourites {
+			targets++
+			if job.Provider != "" {
+				report("provider can't be set for favourites, it's set per domain in the 'domains' table")
+			}
+		}
+		if job.Sources {
+			targets++
+		}
+		if len(job.Categories) > 0 && job.Country == "" {
+			report("categories can only be set with 'country'")
+		}
+		seen := map[string]bool{}
+		for _, category := range job.Categories {
+			if seen[category] {
+				report("category '%s' is repeated", category)
+			} else if !isCategory(category) {
+				report("category '%s' not valid. Allowed values: '%s'", category, strings.Join(news.Categories, "', '"))
+			}
+			seen[category] = true
+		}
+		if targets != 1 {
+			report("exactly one of 'country', 'domains', 'favourites' or 'sources' must be set")
+		}
+
+		if job.PageSize < 1 || job.PageSize > MaxPageSize {
+			report("page_size %d must be between 1 and %d", job.PageSize, MaxPageSize)
+		}
+
+		if job.MaxPages < 1 {
+			report("max_pages %d must be at least 1", job.MaxPages)
+		}
+
+		switch job.Priority {
+		case "high", "normal", "low":
+		default:
+			report("priority '%s' not valid. Allowed values: 'high', 'normal', 'low'", job.Priority)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid config:\n  - %s", strings.Join(problems, "\n  - "))
+	}
+
+	return nil
+}
+
+func isCategory(category string) bool {
+	for _, c := range news.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+
+	config, err := Load(filepath.Join("testdata", "valid.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Job{
+		{Name: "italy", Provider: DefaultProvider, Country: "it", Schedule: "15 1,4,7 * * *", PageSize: DefaultPageSize, MaxPages: DefaultMaxPages, Priority: DefaultPriority},
+		{Name: "australia-sports", Provider: DefaultProvider, Country: "au", Categories: []string{"sports", "technology"}, Schedule: "0 */6 * * *", PageSize: 50, MaxPages: 3, Priority: "high"},
+		{Name: "world", Provider: "rss", Domains: []string{"ansa.it", "corriere.it"}, Schedule: "30 * * * *", PageSize: DefaultPageSize, MaxPages: DefaultMaxPages, Priority: DefaultPriority},
+		// no provider, the one of each domain
+		{Name: "favourites", Favourites: true, Language: "en", Schedule: "10 1,13 * * *", PageSize: DefaultPageSize, MaxPages: DefaultMaxPages, Priority: "low"},
+	}
+
+	if !reflect.DeepEqual(config.Jobs, want) {
+		t.Errorf("jobs =\n%+v\nwant\n%+v", config.Jobs, want)
+	}
+
+	// the config of the repo
+	if _, err := Load(filepath.Join("..", "config.yaml")); err != nil {
+		t.Errorf("config.yaml: %v", err)
+	}
+
+	if _, err := Load(filepath.Join("testdata", "missing.yaml")); err == nil || !strings.Contains(err.Error(), "error reading config file") {
+		t.Errorf("missing file: err = %v", err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+
+	tests := []struct {
+		file string
+		// every problem is in the error
+		problems []string
+	}{
+		{"syntax.yaml", []string{"error parsing config file", "yaml: line 3"}},
+		{"unknown_key.yaml", []string{"error parsing config file", "field pagesize not found"}},
+		{"wrong_type.yaml", []string{"error parsing config file", "`many` into int"}},
+		{"not_a_list.yaml", []string{"error parsing config file", "into []config.Job"}},
+		{"no_jobs.yaml", []string{"invalid config", "no jobs defined"}},
+		{"invalid_jobs.yaml", []string{
+			"job #1: name is required",
+			"job #1: schedule '15 1 * *' must have 5 fields",
+			"job #1: country 'IT' must be a lowercase ISO 3166-1 alpha-2 code",
+			"job #2 'italy': schedule is required",
+			"job #3 'italy': name is already used by another job",
+			"job #3 'italy': exactly one of 'country', 'domains', 'favourites' or 'sources' must be set",
+			"job #4 'categories': categories can only be set with 'country'",
+			"job #4 'categories': category 'politics' not valid",
+			"job #4 'categories': category 'sports' is repeated",
+			"job #5 'limits': exactly one of 'country', 'domains', 'favourites' or 'sources' must be set",
+			"job #5 'limits': page_size 200 must be between 1 and 100",
+			"job #5 'limits': max_pages -1 must be at least 1",
+			"job #5 'limits': priority 'urgent' not valid",
+			"job #6 'favourites': provider can't be set for favourites",
+		}},
+	}
+
+	for _, tt := range tests {
+
+		_, err := Load(filepath.Join("testdata", tt.file))
+		if err == nil {
+			t.Errorf("%s: no error", tt.file)
+			continue
+		}
+		for _, problem := range tt.problems {
+			if !strings.Contains(err.Error(), problem) {
+				t.Errorf("%s: error without '%s':\n%v", tt.file, problem, err)
+			}
+		}
+	}
+}
+
+func TestValidateReportsOnlyTheProblems(t *testing.T) {
+
+	config, err := Load(filepath.Join("testdata", "invalid_jobs.yaml"))
+	if err == nil {
+		t.Fatalf("no error, config %+v", config)
+	}
+
+	// one line per problem, none for the valid settings
+	lines := strings.Split(err.Error(), "\n  - ")
+	if len(lines) != 15 {
+		t.Errorf("%d problems, want 14:\n%v", len(lines)-1, err)
+	}
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "job #2 'italy': country") || strings.Contains(line, "'sports' not valid") {
+			t.Errorf("problem reported for a valid setting: %s", line)
+		}
+	}
+}
+
+func TestSetDefaults(t *testing.T) {
+
+	config := &Config{Jobs: []Job{
+		{Name: "empty"},
+		{Name: "favourites", Favourites: true},
+		{Name: "set", Provider: "rss", PageSize: 10, MaxPages: 2, Priority: "low"},
+		// left as they are, for Validate to report them
+		{Name: "invalid", PageSize: -1, MaxPages: -1, Priority: "urgent"},
+	}}
+
+	config.setDefaults()
+
+	want := []Job{
+		{Name: "empty", Provider: DefaultProvider, PageSize: DefaultPageSize, MaxPages: DefaultMaxPages, Priority: DefaultPriority},
+		{Name: "favourites", Favourites: true, PageSize: DefaultPageSize, MaxPages: DefaultMaxPages, Priority: DefaultPriority},
+		{Name: "set", Provider: "rss", PageSize: 10, MaxPages: 2, Priority: "low"},
+		{Name: "invalid", Provider: DefaultProvider, PageSize: -1, MaxPages: -1, Priority: "urgent"},
+	}
+
+	if !reflect.DeepEqual(config.Jobs, want) {
+		t.Errorf("jobs =\n%+v\nwant\n%+v", config.Jobs, want)
+	}
+}
@@ -5,13 +5,15 @@ import (
 	"fmt"
 	"log"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type DBClient struct {
 	Database *sql.DB
 }
 
-func NewDBClient(db_host string, db_port int, db_name, db_user, db_password string, maxRetries int) (db *DBClient) {
+func NewDBClient(db_host string, db_port int, db_name, db_user, db_password string, maxRetries int) (*DBClient, error) {
 
 	log.Println("Initiate Connection to DB.")
 
@@ -21,7 +23,7 @@ func NewDBClient(db_host string, db_port int, db_name, db_user, db_password stri
 	// sql.Open() simply validates the arguments provided, doesn't connect yet!
 	db_conn, err := sql.Open("postgres", connection_string)
 	if err != nil {
-		log.Fatal("Error validating DB connection parameters => ", err)
+		return nil, fmt.Errorf("error validating DB connection parameters => %w", err)
 	}
 
 	// the method Ping() is actually attempting a connection to the database
@@ -35,34 +37,58 @@ func NewDBClient(db_host string, db_port int, db_name, db_user, db_password stri
 	}
 
 	if err != nil {
-		log.Fatal("Error connecting to DB => ", err)
+		db_conn.Close()
+		return nil, fmt.Errorf("error connecting to DB => %w", err)
 	}
 
 	log.Println("Connection to DB successful.")
-	return &DBClient{db_conn}
+	return &DBClient{db_conn}, nil
+
+}
 
+// Feed is a domain to collect news for and the provider it's searched with
+type Feed struct {
+	ID       int
+	Name     string
+	Provider string
+	FeedURL  string // RSS feed of the domain, empty for the other providers
 }
 
-func (db *DBClient) GetFavourites() *sql.Rows {
+// GetFavourites returns the favourite domains of all the users, each one once
+func (db *DBClient) GetFavourites() ([]Feed, error) {
 
 	log.Printf("Initiate GetFavourites")
 
+	var feeds []Feed
 	var selectRows *sql.Rows
 	var selectErr error
 	sqlSelect := ""
 
-	sqlSelect = "SELECT * FROM domains WHERE favourite = TRUE"
+	sqlSelect = `SELECT DISTINCT d.id, d.name, d.provider, COALESCE(d.feed_url, '') 
+	FROM domains d JOIN user_favourites uf ON uf.domain_id = d.id 
+	ORDER BY d.name`
 
 	selectRows, selectErr = db.Database.Query(sqlSelect)
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return nil, dbError("error on SQL SELECT", selectErr)
 	}
 
-	return selectRows
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var f Feed
+		err := selectRows.Scan(&f.ID, &f.Name, &f.Provider, &f.FeedURL)
+		if err != nil {
+			return nil, dbError("error on reading SQL SELECT results", err)
+		}
+		feeds = append(feeds, f)
+	}
+
+	return feeds, selectRows.Err()
 
 }
 
-func (db *DBClient) GetDomainID(name string) int {
+func (db *DBClient) GetDomainID(name string) (int, error) {
 
 	log.Printf("Initiate GetDomainID")
 
@@ -76,12 +102,12 @@ func (db *DBClient) GetDomainID(name string) int {
 	selectRow = db.Database.QueryRow(sqlSelect, name)
 	selectErr = selectRow.Scan(&id)
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return 0, dbError("error on SQL SELECT", selectErr)
 	}
 
 	log.Printf("Domain ID for '%s' is: ", name)
 
-	return id
+	return id, nil
 
 }
 
@@ -117,45 +143,106 @@ func (db *DBClient) GetDomains(filter []string) *sql.Rows {
 }
 */
 
-func (db *DBClient) GetDomainsByName(name string) *sql.Rows {
+// Domain is a row of the 'domains' table
+type Domain struct {
+	ID        int
+	Name      string
+	Favourite bool
+}
+
+// GetDomainsByName returns the domains with the name, none if it's not in the DB yet
+func (db *DBClient) GetDomainsByName(name string) ([]Domain, error) {
 
 	log.Printf("Initiate GetDomainsByName")
 
+	var domains []Domain
 	var selectRows *sql.Rows
 	var selectErr error
 	sqlSelect := ""
 
-	sqlSelect = "SELECT * FROM domains WHERE name = $1"
+	sqlSelect = "SELECT id, name, favourite FROM domains WHERE name = $1"
 
 	selectRows, selectErr = db.Database.Query(sqlSelect, name)
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return nil, dbError("error on SQL SELECT", selectErr)
 	}
 
-	return selectRows
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var d Domain
+		err := selectRows.Scan(&d.ID, &d.Name, &d.Favourite)
+		if err != nil {
+			return nil, dbError("error on reading SQL SELECT results", err)
+		}
+		domains = append(domains, d)
+	}
+
+	return domains, selectRows.Err()
 
 }
 
-func (db *DBClient) GetSourcesByName(name string) *sql.Rows {
+// Source is a row of the 'sources' table
+type Source struct {
+	ID   int
+	Name string
+}
+
+// GetSourcesByName returns the sources with the name, none if it's not in the DB yet
+func (db *DBClient) GetSourcesByName(name string) ([]Source, error) {
 
 	log.Printf("Initiate GetSourcsByName")
 
+	var sources []Source
 	var selectRows *sql.Rows
 	var sqlSelect = ""
 	var selectErr error
 
-	sqlSelect = "SELECT * FROM sources WHERE name = $1"
+	sqlSelect = "SELECT id, name FROM sources WHERE name = $1"
 
 	selectRows, selectErr = db.Database.Query(sqlSelect, name)
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return nil, dbError("error on SQL SELECT", selectErr)
 	}
 
-	return selectRows
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var s Source
+		err := selectRows.Scan(&s.ID, &s.Name)
+		if err != nil {
+			return nil, dbError("error on reading SQL SELECT results", err)
+		}
+		sources = append(sources, s)
+	}
+
+	return sources, selectRows.Err()
 
 }
 
-func (db *DBClient) InsertSource(sourceName string) (sourceID int) {
+// GetSourceByNewsAPIID returns the id of the source with the NEWS API id, 0 if it's not in the DB
+func (db *DBClient) GetSourceByNewsAPIID(newsapiID string) (int, error) {
+
+	log.Printf("Initiate GetSourceByNewsAPIID for %s", newsapiID)
+
+	var id int
+
+	sqlSelect := "SELECT id FROM sources WHERE newsapi_id = $1"
+
+	selectErr := db.Database.QueryRow(sqlSelect, newsapiID).Scan(&id)
+	if selectErr == sql.ErrNoRows {
+		return 0, nil
+	}
+	if selectErr != nil {
+		return 0, dbError("error on SQL SELECT", selectErr)
+	}
+
+	return id, nil
+}
+
+// InsertSource stores a source, newsapiID can be empty for the sources not in the NEWS API catalogue.
+// A source with the same name known by name only gets the newsapiID instead of being duplicated.
+func (db *DBClient) InsertSource(newsapiID, sourceName string) (sourceID int, err error) {
 
 	log.Printf("Initiate InsertSource for %s", sourceName)
 
@@ -163,22 +250,36 @@ func (db *DBClient) InsertSource(sourceName string) (sourceID int) {
 	var insertRow *sql.Row
 	var insertErr error
 
-	var sqlInsert = `INSERT INTO sources (name) VALUES ($1) RETURNING id`
+	if newsapiID != "" {
+		sqlAdopt := `UPDATE sources SET newsapi_id = $1 
+		WHERE id = (SELECT MIN(id) FROM sources WHERE name = $2 AND newsapi_id IS NULL) RETURNING id`
+
+		adoptErr := db.Database.QueryRow(sqlAdopt, newsapiID, sourceName).Scan(&id)
+		if adoptErr == nil {
+			log.Printf("Source '%s' linked to id '%s'.", sourceName, newsapiID)
+			return id, nil
+		}
+		if adoptErr != sql.ErrNoRows {
+			return 0, dbError("error on SQL UPDATE", adoptErr)
+		}
+	}
+
+	var sqlInsert = `INSERT INTO sources (newsapi_id, name) VALUES (NULLIF($1, ''), $2) RETURNING id`
 
 	// QueryRow returns a *Row
-	insertRow = db.Database.QueryRow(sqlInsert, sourceName)
+	insertRow = db.Database.QueryRow(sqlInsert, newsapiID, sourceName)
 	insertErr = insertRow.Scan(&id)
 	if insertErr != nil {
-		log.Fatal("Error on SQL INSERT => ", insertErr)
+		return 0, dbError("error on SQL INSERT", insertErr)
 	}
 
 	log.Printf("Source '%s' stored in the DB.", sourceName)
 
 	// return the id of the source just INSERTed
-	return id
+	return id, nil
 }
 
-func (db *DBClient) InsertDomain(domainName string) (domainID int) {
+func (db *DBClient) InsertDomain(domainName string) (domainID int, err error) {
 
 	log.Printf("Initiate InsertDomain for %s", domainName)
 
@@ -193,32 +294,460 @@ func (db *DBClient) InsertDomain(domainName string) (domainID int) {
 	insertRow = db.Database.QueryRow(sqlInsert, domainName)
 	insertErr = insertRow.Scan(&id)
 	if insertErr != nil {
-		log.Fatal("Error on SQL INSERT => ", insertErr)
+		return 0, dbError("error on SQL INSERT", insertErr)
 	}
 
 	log.Printf("Domain '%s' stored in the DB.", domainName)
 
 	// return the id of the source just INSERTed
-	return id
+	return id, nil
+
+}
+
+// UpsertResult tells what UpsertArticle (or UpsertSource) did with the record
+type UpsertResult int
+
+const (
+	ArticleUnchanged UpsertResult = iota
+	ArticleInserted
+	ArticleUpdated
+)
+
+// UpsertArticle stores the article, unless another one with the same normalized URL is already in the DB.
+// In that case description, content and image are updated if they changed (empty values don't overwrite),
+// the category is set if the article had none. The id of the article is returned, 0 when it's unchanged.
+func (db *DBClient) UpsertArticle(sourceID, domainID int, author string, title string, description string, url string, urlToImage string, publishedAt time.Time, content, country, language, category string) (UpsertResult, int, error) {
+
+	var upsertRow *sql.Row
+	var upsertErr error
+	var id int
+	var inserted bool
+	sqlUpsert := ""
+
+	log.Println("Initate UpsertArticle.")
+
+	// xmax is 0 only for a freshly INSERTed row, no row is returned when nothing changed
+	sqlUpsert = `INSERT INTO articles (source_id, domain_id, author, title, description, url, url_to_image,
+		published_at, content, country, language, category, url_normalized) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (url_normalized) DO UPDATE SET
+			description = COALESCE(NULLIF(EXCLUDED.description, ''), articles.description),
+			content = COALESCE(NULLIF(EXCLUDED.content, ''), articles.content),
+			url_to_image = COALESCE(NULLIF(EXCLUDED.url_to_image, ''), articles.url_to_image),
+			category = COALESCE(NULLIF(articles.category, ''), EXCLUDED.category)
+		WHERE (articles.description, articles.content, articles.url_to_image, articles.category) IS DISTINCT FROM 
+			(COALESCE(NULLIF(EXCLUDED.description, ''), articles.description), 
+			COALESCE(NULLIF(EXCLUDED.content, ''), articles.content), 
+			COALESCE(NULLIF(EXCLUDED.url_to_image, ''), articles.url_to_image),
+			COALESCE(NULLIF(articles.category, ''), EXCLUDED.category))
+		RETURNING id, (xmax = 0) AS inserted`
+
+	upsertRow = db.Database.QueryRow(sqlUpsert, sourceID, domainID, author, title, description, url, urlToImage, publishedAt, content, country, language, category, NormalizeURL(url))
+	upsertErr = upsertRow.Scan(&id, &inserted)
+
+	switch {
+	case upsertErr == sql.ErrNoRows:
+		log.Printf("Article already in the DB and unchanged.")
+		return ArticleUnchanged, 0, nil
+	case upsertErr != nil:
+		return ArticleUnchanged, 0, dbError("error on SQL UPSERT", upsertErr)
+	case inserted:
+		log.Printf("Article stored in the DB.")
+		return ArticleInserted, id, nil
+	}
+
+	log.Printf("Article updated in the DB.")
+	return ArticleUpdated, id, nil
+}
 
+// ArticleToCluster is an article not assigned to a story cluster yet
+type ArticleToCluster struct {
+	ID          int
+	Title       string
+	Description string
 }
 
-func (db *DBClient) InsertArticle(sourceID, domainID int, author string, title string, description string, url string, urlToImage string, publishedAt time.Time, content, country, language, category string) {
+// StoryCluster is a group of near-duplicate articles, see the cluster package
+type StoryCluster struct {
+	ID          int
+	Fingerprint uint64
+}
+
+// GetArticlesToCluster returns the articles of the ids without a story cluster, oldest first
+func (db *DBClient) GetArticlesToCluster(ids []int) ([]ArticleToCluster, error) {
+
+	log.Printf("Initiate GetArticlesToCluster")
+
+	var articles []ArticleToCluster
+	var selectRows *sql.Rows
+	var selectErr error
+	sqlSelect := ""
+
+	sqlSelect = `SELECT id, COALESCE(title, ''), COALESCE(description, '') 
+	FROM articles 
+	WHERE id = ANY($1) AND cluster_id IS NULL
+	ORDER BY published_at ASC`
+
+	selectRows, selectErr = db.Database.Query(sqlSelect, pq.Array(ids))
+	if selectErr != nil {
+		return nil, dbError("error on SQL SELECT", selectErr)
+	}
+
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var a ArticleToCluster
+		err := selectRows.Scan(&a.ID, &a.Title, &a.Description)
+		if err != nil {
+			return nil, dbError("error on reading SQL SELECT results", err)
+		}
+		articles = append(articles, a)
+	}
+
+	return articles, selectRows.Err()
+}
+
+// GetRecentClusters returns the story clusters created in the last 'days'
+func (db *DBClient) GetRecentClusters(days int) ([]StoryCluster, error) {
+
+	log.Printf("Initiate GetRecentClusters")
+
+	var clusters []StoryCluster
+	var selectRows *sql.Rows
+	var selectErr error
+	sqlSelect := ""
+
+	sqlSelect = `SELECT id, fingerprint FROM story_clusters 
+	WHERE created_at > NOW() - make_interval(days => $1)`
+
+	selectRows, selectErr = db.Database.Query(sqlSelect, days)
+	if selectErr != nil {
+		return nil, dbError("error on SQL SELECT", selectErr)
+	}
 
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var c StoryCluster
+		var fingerprint int64
+		err := selectRows.Scan(&c.ID, &fingerprint)
+		if err != nil {
+			return nil, dbError("error on reading SQL SELECT results", err)
+		}
+		// BIGINT is signed, the bits are the same
+		c.Fingerprint = uint64(fingerprint)
+		clusters = append(clusters, c)
+	}
+
+	return clusters, selectRows.Err()
+}
+
+// InsertCluster creates a story cluster having the article as representative and returns its id
+func (db *DBClient) InsertCluster(articleID int, fingerprint uint64) (clusterID int, err error) {
+
+	log.Printf("Initiate InsertCluster for article %d", articleID)
+
+	id := 0
+	var insertRow *sql.Row
 	var insertErr error
 	sqlInsert := ""
 
-	log.Println("Initate InsertArticle.")
+	sqlInsert = `INSERT INTO story_clusters (fingerprint, representative_id) VALUES ($1, $2) RETURNING id`
+
+	insertRow = db.Database.QueryRow(sqlInsert, int64(fingerprint), articleID)
+	insertErr = insertRow.Scan(&id)
+	if insertErr != nil {
+		return 0, dbError("error on SQL INSERT", insertErr)
+	}
+
+	return id, nil
+}
 
-	sqlInsert = `INSERT INTO articles (source_id, domain_id, author, title, description, url, url_to_image,
-		published_at, content, country, language, category) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+// SetArticleCluster stores the fingerprint of the article and the story cluster it belongs to
+func (db *DBClient) SetArticleCluster(articleID, clusterID int, fingerprint uint64) error {
 
-	// Here we use db.Exec as we don't want any record back
-	_, insertErr = db.Database.Exec(sqlInsert, sourceID, domainID, author, title, description, url, urlToImage, publishedAt, content, country, language, category)
+	log.Printf("Initiate SetArticleCluster for article %d", articleID)
 
-	if insertErr != nil {
-		log.Fatal("Error on SQL INSERT => ", insertErr)
+	var updateErr error
+	sqlUpdate := ""
+
+	sqlUpdate = `UPDATE articles SET fingerprint = $1, cluster_id = $2 WHERE id = $3`
+
+	_, updateErr = db.Database.Exec(sqlUpdate, int64(fingerprint), clusterID, articleID)
+	if updateErr != nil {
+		return dbError("error on SQL UPDATE", updateErr)
+	}
+
+	return nil
+}
+
+// Country is a country to collect news for, see the 'countries' table
+type Country struct {
+	ID       int
+	Code     string
+	Name     string
+	Language string
+	Schedule string // crontab spec of the top headlines job of the country, empty for none
+}
+
+// GetCountries returns the enabled countries
+func (db *DBClient) GetCountries() ([]Country, error) {
+
+	log.Printf("Initiate GetCountries")
+
+	var countries []Country
+	var selectRows *sql.Rows
+	var selectErr error
+	sqlSelect := ""
+
+	sqlSelect = `SELECT id, code, name, language, COALESCE(schedule, '') FROM countries WHERE enabled IS TRUE ORDER BY name ASC`
+
+	selectRows, selectErr = db.Database.Query(sqlSelect)
+	if selectErr != nil {
+		return nil, dbError("error on SQL SELECT", selectErr)
+	}
+
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var c Country
+		err := selectRows.Scan(&c.ID, &c.Code, &c.Name, &c.Language, &c.Schedule)
+		if err != nil {
+			return nil, dbError("error on reading SQL SELECT results", err)
+		}
+		countries = append(countries, c)
+	}
+
+	return countries, selectRows.Err()
+}
+
+// UpdateQuota runs 'take' on the token bucket of the provider, locking its row until the new tokens are stored.
+// The bucket is created full the first time. 'take' returns the tokens left, or an error to leave them untouched.
+func (db *DBClient) UpdateQuota(provider string, capacity int, period time.Duration, take func(tokens float64, updatedAt time.Time) (float64, error)) error {
+
+	log.Printf("Initiate UpdateQuota for %s", provider)
+
+	var tokens float64
+	var updatedAt time.Time
+
+	tx, err := db.Database.Begin()
+	if err != nil {
+		return dbError("error on SQL BEGIN", err)
+	}
+
+	// Rollback is a no-op after Commit
+	defer tx.Rollback()
+
+	sqlInsert := `INSERT INTO api_quotas (provider, capacity, period_seconds, tokens) VALUES ($1, $2, $3, $2) 
+	ON CONFLICT (provider) DO UPDATE SET capacity = $2, period_seconds = $3`
+
+	_, err = tx.Exec(sqlInsert, provider, capacity, int(period.Seconds()))
+	if err != nil {
+		return dbError("error on SQL UPSERT", err)
+	}
+
+	sqlSelect := `SELECT tokens, updated_at FROM api_quotas WHERE provider = $1 FOR UPDATE`
+
+	err = tx.QueryRow(sqlSelect, provider).Scan(&tokens, &updatedAt)
+	if err != nil {
+		return dbError("error on SQL SELECT", err)
+	}
+
+	tokens, err = take(tokens, updatedAt)
+	if err != nil {
+		return err
+	}
+
+	sqlUpdate := `UPDATE api_quotas SET tokens = $1, updated_at = NOW() WHERE provider = $2`
+
+	_, err = tx.Exec(sqlUpdate, tokens, provider)
+	if err != nil {
+		return dbError("error on SQL UPDATE", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetCursor returns the high-water mark of the feed of a job, zero time if the feed was never collected
+func (db *DBClient) GetCursor(job, feed string) (time.Time, error) {
+
+	log.Printf("Initiate GetCursor for %s/%s", job, feed)
+
+	var publishedAt time.Time
+
+	sqlSelect := `SELECT published_at FROM feed_cursors WHERE job = $1 AND feed = $2`
+
+	selectErr := db.Database.QueryRow(sqlSelect, job, feed).Scan(&publishedAt)
+	if selectErr == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if selectErr != nil {
+		return time.Time{}, dbError("error on SQL SELECT", selectErr)
+	}
+
+	return publishedAt, nil
+}
+
+// SetCursor moves the high-water mark of the feed of a job forward, it never goes back
+func (db *DBClient) SetCursor(job, feed string, publishedAt time.Time) error {
+
+	log.Printf("Initiate SetCursor for %s/%s at %v", job, feed, publishedAt)
+
+	sqlUpsert := `INSERT INTO feed_cursors (job, feed, published_at) VALUES ($1, $2, $3) 
+	ON CONFLICT (job, feed) DO UPDATE SET published_at = GREATEST(feed_cursors.published_at, EXCLUDED.published_at), updated_at = NOW()`
+
+	_, upsertErr := db.Database.Exec(sqlUpsert, job, feed, publishedAt)
+	if upsertErr != nil {
+		return dbError("error on SQL UPSERT", upsertErr)
+	}
+
+	return nil
+}
+
+// JobSettings is what the admins set on a job from the visualizer, see the collector_jobs table
+type JobSettings struct {
+	Enabled bool
+	// replaces the schedule of the config, empty if none
+	Schedule string
+}
+
+// SyncJobs stores the schedule of the jobs of the config (name => schedule), keeping the settings of the admins,
+// and deletes the jobs no longer in the config
+func (db *DBClient) SyncJobs(schedules map[string]string) error {
+
+	log.Printf("Initiate SyncJobs")
+
+	names := []string{}
+	for name, schedule := range schedules {
+
+		sqlUpsert := `INSERT INTO collector_jobs (name, schedule) VALUES ($1, $2) 
+		ON CONFLICT (name) DO UPDATE SET schedule = EXCLUDED.schedule`
+
+		_, upsertErr := db.Database.Exec(sqlUpsert, name, schedule)
+		if upsertErr != nil {
+			return dbError("error on SQL UPSERT", upsertErr)
+		}
+		names = append(names, name)
+	}
+
+	_, deleteErr := db.Database.Exec(`DELETE FROM collector_jobs WHERE NOT (name = ANY($1))`, pq.Array(names))
+	if deleteErr != nil {
+		return dbError("error on SQL DELETE", deleteErr)
+	}
+
+	return nil
+}
+
+// GetJobSettings returns the settings of the jobs by name and JobsUpdatedAt
+func (db *DBClient) GetJobSettings() (map[string]JobSettings, time.Time, error) {
+
+	log.Printf("Initiate GetJobSettings")
+
+	sqlSelect := `SELECT name, enabled, COALESCE(schedule_override, ''), updated_at FROM collector_jobs`
+
+	selectRows, selectErr := db.Database.Query(sqlSelect)
+	if selectErr != nil {
+		return nil, time.Time{}, dbError("error on SQL SELECT", selectErr)
+	}
+
+	defer selectRows.Close()
+
+	settings := map[string]JobSettings{}
+	var updatedAt time.Time
+	for selectRows.Next() {
+		var name string
+		var s JobSettings
+		var jobUpdatedAt time.Time
+		err := selectRows.Scan(&name, &s.Enabled, &s.Schedule, &jobUpdatedAt)
+		if err != nil {
+			return nil, time.Time{}, dbError("error on reading SQL SELECT results", err)
+		}
+		settings[name] = s
+		if jobUpdatedAt.After(updatedAt) {
+			updatedAt = jobUpdatedAt
+		}
+	}
+
+	return settings, updatedAt, selectRows.Err()
+}
+
+// JobsUpdatedAt returns the last time the admins changed a job, zero time if none
+func (db *DBClient) JobsUpdatedAt() (time.Time, error) {
+
+	log.Printf("Initiate JobsUpdatedAt")
+
+	var updatedAt sql.NullTime
+
+	selectErr := db.Database.QueryRow(`SELECT MAX(updated_at) FROM collector_jobs`).Scan(&updatedAt)
+	if selectErr != nil {
+		return time.Time{}, dbError("error on SQL SELECT", selectErr)
+	}
+
+	return updatedAt.Time, nil
+}
+
+// CatalogueSource is a source of the provider catalogue, see UpsertSource
+type CatalogueSource struct {
+	NewsAPIID   string
+	Name        string
+	Description string
+	URL         string
+	Category    string
+	Language    string
+	Country     string
+}
+
+// UpsertSource stores a source of the catalogue by its NEWS API id.
+// A source already known by name only (stored with the articles before the first sync) gets the id,
+// so its articles are kept linked to it.
+func (db *DBClient) UpsertSource(source CatalogueSource) (UpsertResult, error) {
+
+	log.Printf("Initiate UpsertSource for %s", source.NewsAPIID)
+
+	var inserted bool
+
+	tx, err := db.Database.Begin()
+	if err != nil {
+		return ArticleUnchanged, dbError("error on SQL BEGIN", err)
+	}
+
+	// Rollback is a no-op after Commit
+	defer tx.Rollback()
+
+	sqlAdopt := `UPDATE sources SET newsapi_id = $1 
+	WHERE id = (SELECT MIN(id) FROM sources WHERE name = $2 AND newsapi_id IS NULL) 
+	AND NOT EXISTS (SELECT 1 FROM sources WHERE newsapi_id = $1)`
+
+	_, err = tx.Exec(sqlAdopt, source.NewsAPIID, source.Name)
+	if err != nil {
+		return ArticleUnchanged, dbError("error on SQL UPDATE", err)
+	}
+
+	// xmax is 0 only for a freshly INSERTed row, no row is returned when nothing changed
+	sqlUpsert := `INSERT INTO sources (newsapi_id, name, description, url, category, language, country) 
+	VALUES ($1, $2, $3, $4, $5, $6, $7)
+	ON CONFLICT (newsapi_id) DO UPDATE SET
+		name = EXCLUDED.name, description = EXCLUDED.description, url = EXCLUDED.url,
+		category = EXCLUDED.category, language = EXCLUDED.language, country = EXCLUDED.country
+	WHERE (sources.name, sources.description, sources.url, sources.category, sources.language, sources.country) IS DISTINCT FROM
+		(EXCLUDED.name, EXCLUDED.description, EXCLUDED.url, EXCLUDED.category, EXCLUDED.language, EXCLUDED.country)
+	RETURNING (xmax = 0) AS inserted`
+
+	err = tx.QueryRow(sqlUpsert, source.NewsAPIID, source.Name, source.Description, source.URL, source.Category, source.Language, source.Country).Scan(&inserted)
+
+	result := ArticleUpdated
+	switch {
+	case err == sql.ErrNoRows:
+		result = ArticleUnchanged
+	case err != nil:
+		return ArticleUnchanged, dbError("error on SQL UPSERT", err)
+	case inserted:
+		result = ArticleInserted
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ArticleUnchanged, dbError("error on SQL COMMIT", err)
 	}
 
-	log.Printf("Article stored in the DB.")
+	return result, nil
 }
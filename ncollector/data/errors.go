@@ -0,0 +1,41 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ErrConstraint is matched (errors.Is) by the errors of statements violating a DB constraint,
+// e.g. a NOT NULL column or a broken foreign key: retrying the same data won't help
+var ErrConstraint = errors.New("DB constraint violated")
+
+// ConstraintError tells which constraint the statement violated
+type ConstraintError struct {
+	Constraint string
+	Err        *pq.Error
+}
+
+func (e *ConstraintError) Error() string {
+	return fmt.Sprintf("%v: %s (%s)", ErrConstraint, e.Err.Message, e.Constraint)
+}
+
+func (e *ConstraintError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConstraintError) Is(target error) bool {
+	return target == ErrConstraint
+}
+
+// dbError wraps the error of the operation, turning integrity violations (SQLSTATE class 23) into a ConstraintError
+func dbError(op string, err error) error {
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code.Class() == "23" {
+		err = &ConstraintError{Constraint: pqErr.Constraint, Err: pqErr}
+	}
+
+	return fmt.Errorf("%s => %w", op, err)
+}
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"net/url"
+	"strings"
+)
+
+// NormalizeURL returns the article URL used to detect duplicates:
+// lowercase scheme and host, no fragment, no utm_* tracking params and no trailing slash.
+// The same article shared with different campaigns ends up with the same normalized URL.
+func NormalizeURL(rawURL string) string {
+
+	rawURL = strings.TrimSpace(rawURL)
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		// not an absolute URL, best we can do is to cut the fragment and the trailing slash
+		rawURL = strings.SplitN(rawURL, "#", 2)[0]
+		return strings.TrimRight(rawURL, "/")
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.User = nil
+
+	// drop the tracking params, Encode() sorts the remaining ones by key
+	params := u.Query()
+	for key := range params {
+		if strings.HasPrefix(strings.ToLower(key), "utm_") {
+			params.Del(key)
+		}
+	}
+	u.RawQuery = params.Encode()
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+
+	return u.String()
+}
@@ -5,4 +5,5 @@ go 1.17
 require (
 	github.com/lib/pq v1.10.3
 	github.com/mileusna/crontab v1.2.0
+	gopkg.in/yaml.v2 v2.4.0
 )
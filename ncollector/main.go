@@ -1,251 +1,967 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
 	"regexp"
 	"strings"
+	"syscall"
 	"time"
 
 	_ "github.com/lib/pq"
 
 	"github.com/mileusna/crontab"
 
+	"github.com/mesmerai/news-aggregator/ncollector/cluster"
+	"github.com/mesmerai/news-aggregator/ncollector/config"
 	"github.com/mesmerai/news-aggregator/ncollector/data"
 	"github.com/mesmerai/news-aggregator/ncollector/news"
+	"github.com/mesmerai/news-aggregator/ncollector/quota"
 )
 
-var environment = getEnv()
-
 var db_port int = 5432
 var db_name string = "news"
 var db_user string = "news_db_user"
 var dbconn_max_retries = 10
 
-// from Env
-var news_api_key string = environment["news_api_key"]
-var db_host = environment["db_host"]
-var db_password = environment["db_password"]
+// NEWS API Dev plan: 50 calls every 12 hours, shared by all the jobs
+var newsapi_quota_calls = 50
+var newsapi_quota_period = 12 * time.Hour
 
-/* ** vars ** */
-var sourceID int
-var domainID int
+// days of articles and story clusters compared by the clustering stage
+var cluster_window_days = 3
 
-// Source struct to deal with INSERT later. Declare and initialize.
-type Source struct {
-	id   int
-	name string
-}
+// articles published before the high-water mark of a feed, but indexed by the provider after the previous run,
+// are fetched again within this time (see resumeFrom)
+var cursor_overlap = 6 * time.Hour
 
-var thisSource = Source{}
+// how often the collector checks if the admins changed the jobs from the visualizer
+var jobs_check_interval = time.Minute
 
-// Domain struct to deal with INSERT later. Declare and initialize.
-type Domain struct {
-	id        int
-	name      string
-	favourite bool
-}
+// from Env, read by main
+var news_api_key string
+var db_host string
+var db_password string
 
-var thisDomain = Domain{}
+// collection jobs config, reloaded on SIGHUP
+var config_file string
+
+// NEWS API server, e.g. a caching proxy or the fake one of cmd/fakenewsapi
+var news_api_url string
 
 type FavouriteFeed struct {
 	id        int
 	name      string
 	favourite bool
+	provider  string
+	feedURL   string
+}
+
+// RunStats counts what happened to the fetched articles during a collection run
+type RunStats struct {
+	Inserted    int
+	Updated     int
+	Unchanged   int
+	InsertedIDs []int   // articles inserted by the run, to be clustered
+	Deferred    int     // feeds skipped and left to the next run (API quota, rate limit, provider errors)
+	Errors      []error // failed feeds and articles, skipped to carry on with the rest of the run
+}
+
+// Add counts the result of an UpsertArticle
+func (s *RunStats) Add(result data.UpsertResult) {
+	switch result {
+	case data.ArticleInserted:
+		s.Inserted++
+	case data.ArticleUpdated:
+		s.Updated++
+	default:
+		s.Unchanged++
+	}
+}
+
+// AddArticle counts the result of an UpsertArticle, keeping the id of an inserted article
+func (s *RunStats) AddArticle(result data.UpsertResult, articleID int) {
+	s.Add(result)
+	if result == data.ArticleInserted {
+		s.InsertedIDs = append(s.InsertedIDs, articleID)
+	}
+}
+
+// Fail records the error of what failed (e.g. the article URL) and logs it
+func (s *RunStats) Fail(prefix, what string, err error) {
+	log.Printf("%s | Error on %s => %v. Skipped.", prefix, what, err)
+	s.Errors = append(s.Errors, fmt.Errorf("%s: %w", what, err))
+}
+
+// Log prints the summary of the run, prefix is the type of collection (e.g. 'Global')
+func (s *RunStats) Log(prefix string) {
+	log.Printf("%s | Articles inserted: %d, updated: %d, unchanged: %d. Feeds deferred: %d. Errors: %d", prefix, s.Inserted, s.Updated, s.Unchanged, s.Deferred, len(s.Errors))
+	s.LogErrors(prefix)
+}
+
+// LogErrors prints the summary of the errors of the run, by kind
+func (s *RunStats) LogErrors(prefix string) {
+
+	if len(s.Errors) == 0 {
+		return
+	}
+
+	kinds := map[string]int{}
+	for _, err := range s.Errors {
+		kinds[errorKind(err)]++
+	}
+	for kind, count := range kinds {
+		log.Printf("%s | Errors | %s: %d", prefix, kind, count)
+	}
+	for i, err := range s.Errors {
+		log.Printf("%s | Errors | #%d %v", prefix, i+1, err)
+	}
 }
 
-var thisFeed = FavouriteFeed{}
+// errorKind groups the errors in the summary of the run
+func errorKind(err error) string {
+	switch {
+	case errors.Is(err, news.ErrAPIKeyInvalid):
+		return "API key not valid"
+	case errors.Is(err, news.ErrRateLimited):
+		return "rate limited"
+	case errors.Is(err, news.ErrUpstreamUnavailable):
+		return "provider unavailable"
+	case errors.Is(err, news.ErrBadRequest):
+		return "request not valid"
+	case errors.Is(err, data.ErrConstraint):
+		return "DB constraint violated"
+	}
+	return "other"
+}
+
+// stopsProvider tells if the error makes every next call of the provider in the run fail: the quota is over,
+// or the API key shared by the feeds is refused. A feed of IndependentFeeds fails alone.
+func stopsProvider(provider news.Provider, err error) bool {
+
+	if errors.Is(err, quota.ErrExhausted) {
+		return true
+	}
+	if p, ok := provider.(news.IndependentFeeds); ok && p.IndependentFeeds() {
+		return false
+	}
+
+	return errors.Is(err, news.ErrRateLimited) || errors.Is(err, news.ErrAPIKeyInvalid)
+}
 
 func main() {
 
-	// ** Entire Block Schedule to run every 3 hours **
+	environment := getEnv()
+	news_api_key = environment["news_api_key"]
+	db_host = environment["db_host"]
+	db_password = environment["db_password"]
+	config_file = environment["config_file"]
+	news_api_url = environment["news_api_url"]
+
+	// jitter of the retries
+	rand.Seed(time.Now().UnixNano())
+
+	// ** Jobs and Schedules are in the config file **
 	//
 	// MAX 25 API Calls in 6 hours - 23 Max Feeds
 	// MAX 12 API Calls in 3 hours - 10 Max Feeds
-	log.Println("Initiating Cron Jobs")
-	ctab := crontab.New() // create cron table
+	log.Println("Initiating Cron Jobs from", config_file)
 
-	// run at 9:10, 12:10, 15:10 etc..
-	ctab.MustAddJob("0 1,4,7,10,13,16,19,22 * * *", FetchItaly)
-	ctab.MustAddJob("5 1,4,7,10,13,16,19,22 * * *", FetchAustralia)
-	ctab.MustAddJob("10 1,4,7,10,13,16,19,22 * * *", FetchGlobal)
+	ctab, jobsUpdatedAt, err := scheduleJobs(config_file)
+	if err != nil {
+		log.Fatal("Error scheduling the jobs => ", err)
+	}
 
-	// troubleshooting: run every 30 minutes
-	//ctab.MustAddJob("*/30 * * * *", FetchItaly)
-	//ctab.MustAddJob("*/30 * * * *", FetchAustralia)
-	//ctab.MustAddJob("*/30 * * * *", FetchGlobal)
+	reloadJobs := func() {
+		newCtab, updatedAt, err := scheduleJobs(config_file)
+		if err != nil {
+			// keep going with the jobs we have
+			log.Println("Error reloading the jobs, keeping the current ones => ", err)
+			return
+		}
+
+		ctab.Shutdown()
+		ctab = newCtab
+		jobsUpdatedAt = updatedAt
+		log.Println("Cron Jobs reloaded.")
+	}
+
+	// SIGHUP reloads the config file, so schedules can change without a redeploy
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+
+	// the admins enable, disable and reschedule the jobs from the visualizer
+	check := time.NewTicker(jobs_check_interval)
+	defer check.Stop()
 
 	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	for {
+		select {
+		case <-reload:
+			log.Println("SIGHUP received. Reloading Cron Jobs from", config_file)
+			reloadJobs()
 
-	<-stop
+		case <-check.C:
+			updatedAt, err := getJobsUpdatedAt()
+			if err != nil {
+				log.Println("Error checking the jobs changed from the visualizer => ", err)
+				continue
+			}
+			if updatedAt.Equal(jobsUpdatedAt) {
+				continue
+			}
 
-	ctab.Clear()
-	log.Println("Clear Cron Resources")
+			log.Println("Jobs changed from the visualizer. Reloading Cron Jobs from", config_file)
+			reloadJobs()
+
+		case <-stop:
+			ctab.Shutdown()
+			log.Println("Clear Cron Resources")
+			return
+		}
+	}
 }
 
-func FetchGlobal() {
+// scheduleJobs loads the config file and returns a crontab running its jobs, as the admins set them from the visualizer
+// (see the collector_jobs table), and the last time they changed them.
+// Nothing is scheduled if the config is not valid.
+func scheduleJobs(path string) (*crontab.Crontab, time.Time, error) {
+
+	conf, err := config.Load(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// country jobs refer to the 'countries' table
+	myDB, err := data.NewDBClient(db_host, db_port, db_name, db_user, db_password, dbconn_max_retries)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer myDB.Database.Close()
+
+	enabled, err := myDB.GetCountries()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	countries := map[string]data.Country{}
+	for _, c := range enabled {
+		countries[c.Code] = c
+	}
+	providers := newProviders(myDB)
+
+	jobs := append(countryJobs(conf, enabled), conf.Jobs...)
+
+	// the visualizer lists the jobs of the config, the admins change them there
+	schedules := map[string]string{}
+	for _, job := range jobs {
+		schedules[job.Name] = job.Schedule
+	}
+	if err := myDB.SyncJobs(schedules); err != nil {
+		return nil, time.Time{}, err
+	}
+	settings, updatedAt, err := myDB.GetJobSettings()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	ctab := crontab.New() // create cron table
+
+	for _, job := range jobs {
+
+		if job.Provider != "" {
+			if _, err := providers.Get(job.Provider); err != nil {
+				ctab.Shutdown()
+				return nil, time.Time{}, fmt.Errorf("job '%s': %v", job.Name, err)
+			}
+		}
+
+		var country data.Country
+		if job.Country != "" && !job.Sources {
+			var ok bool
+			if country, ok = countries[job.Country]; !ok {
+				ctab.Shutdown()
+				return nil, time.Time{}, fmt.Errorf("job '%s': country '%s' is not enabled in the 'countries' table", job.Name, job.Country)
+			}
+		}
+
+		setting, ok := settings[job.Name]
+		if ok && !setting.Enabled {
+			log.Printf("Cron Job '%s' disabled from the visualizer", job.Name)
+			continue
+		}
+
+		schedule := job.Schedule
+		if ok && setting.Schedule != "" {
+			job.Schedule = setting.Schedule
+		}
+
+		addErr := addJob(ctab, job, country)
+		if addErr != nil && job.Schedule != schedule {
+			// a wrong schedule of the admins doesn't stop the collector
+			log.Printf("Cron Job '%s': invalid schedule '%s' set from the visualizer, back to '%s' => %v", job.Name, job.Schedule, schedule, addErr)
+			job.Schedule = schedule
+			addErr = addJob(ctab, job, country)
+		}
+
+		if addErr != nil {
+			ctab.Shutdown()
+			return nil, time.Time{}, fmt.Errorf("job '%s': invalid schedule '%s' => %v", job.Name, job.Schedule, addErr)
+		}
+
+		log.Printf("Cron Job '%s' scheduled: '%s'", job.Name, job.Schedule)
+	}
+
+	// troubleshooting: run all the jobs now
+	//ctab.RunAll()
+
+	return ctab, updatedAt, nil
+}
+
+// addJob schedules the job in the crontab, the country is the one of the top headlines jobs
+func addJob(ctab *crontab.Crontab, job config.Job, country data.Country) error {
+
+	switch {
+	case job.Sources:
+		return ctab.AddJob(job.Schedule, FetchSources, job)
+	case job.Country != "":
+		return ctab.AddJob(job.Schedule, FetchCountry, job, country)
+	default:
+		return ctab.AddJob(job.Schedule, FetchGlobal, job)
+	}
+}
+
+// getJobsUpdatedAt returns the last time the admins changed the jobs from the visualizer
+func getJobsUpdatedAt() (time.Time, error) {
+
+	myDB, err := data.NewDBClient(db_host, db_port, db_name, db_user, db_password, 1)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer myDB.Database.Close()
+
+	return myDB.JobsUpdatedAt()
+}
+
+// countryJobs returns the top headlines jobs of the countries having a schedule in the 'countries' table,
+// named after the country (e.g. 'italy'). A job of the config file with the same name replaces it.
+func countryJobs(conf *config.Config, countries []data.Country) []config.Job {
+
+	inConfig := map[string]bool{}
+	for _, job := range conf.Jobs {
+		inConfig[job.Name] = true
+	}
+
+	var jobs []config.Job
+	for _, c := range countries {
+
+		name := strings.ToLower(c.Name)
+		if c.Schedule == "" || inConfig[name] {
+			continue
+		}
+
+		// the top headlines are the first page of the visualizer, collected before the other jobs
+		jobs = append(jobs, config.Job{
+			Name:     name,
+			Provider: config.DefaultProvider,
+			Country:  c.Code,
+			Schedule: c.Schedule,
+			PageSize: config.DefaultPageSize,
+			MaxPages: config.DefaultMaxPages,
+			Priority: "high",
+		})
+	}
+
+	return jobs
+}
+
+// FetchGlobal collects the news of a job searching domains, listed in the config or the favourites
+func FetchGlobal(job config.Job) {
 
 	log.Println("==========================================================")
-	log.Println("Global | News Collection Start")
+	log.Printf("Global | %s | News Collection Start", job.Name)
 	log.Println("==========================================================")
 
-	/* ** News Client ** */
-	myClient := &http.Client{Timeout: 30 * time.Second}
-	newsapi := news.NewClient(myClient, news_api_key, 100)
-
 	/* ** DB Conn ** */
-	myDB := data.NewDBClient(db_host, db_port, db_name, db_user, db_password, dbconn_max_retries)
+	myDB, err := data.NewDBClient(db_host, db_port, db_name, db_user, db_password, dbconn_max_retries)
+	if err != nil {
+		log.Printf("Global | %s | %v. Run skipped.", job.Name, err)
+		return
+	}
 
 	// myDB = *DBClient(db_conn)
 	defer myDB.Database.Close()
 
+	/* ** News Providers ** */
+	providers := newProviders(myDB)
+
 	log.Println("Global | Closing DB resources.")
 
-	// restricted list of domains REQUIRED to not reach the API call daily LIMIT of 50 API calls in 12 hours
-	//dList := []string{"corriere.it", "ansa.it", "rainews.it"}
+	stats := GlobalFetchAndStore(myDB, providers, job)
 
-	GlobalFetchAndStore(myDB, newsapi)
+	ClusterAndStore(myDB, stats)
+	stats.Log("Global")
 
-	log.Println("Global | News Collection End")
+	log.Printf("Global | %s | News Collection End", job.Name)
 
 }
 
-func FetchItaly() {
+// FetchCountry collects the top headlines of the country of the job
+func FetchCountry(job config.Job, country data.Country) {
 
 	log.Println("==========================================================")
-	log.Println("ByCountry | News Collection Start")
+	log.Printf("ByCountry | %s | News Collection Start", job.Name)
 	log.Println("==========================================================")
 
-	/* ** News Client ** */
-	myClient := &http.Client{Timeout: 30 * time.Second}
-	newsapi := news.NewClient(myClient, news_api_key, 100)
-
 	/* ** DB Conn ** */
-	myDB := data.NewDBClient(db_host, db_port, db_name, db_user, db_password, dbconn_max_retries)
+	myDB, err := data.NewDBClient(db_host, db_port, db_name, db_user, db_password, dbconn_max_retries)
+	if err != nil {
+		log.Printf("ByCountry | %s | %v. Run skipped.", job.Name, err)
+		return
+	}
 
 	// myDB = *DBClient(db_conn)
 	defer myDB.Database.Close()
 
+	/* ** News Providers ** */
+	providers := newProviders(myDB)
+
 	log.Println("ByCountry | Closing DB resources.")
 
-	CountryFetchAndStore(myDB, newsapi, "Italy", "Italian")
+	// checked when the job is scheduled
+	provider, err := providers.Get(job.Provider)
+	if err != nil {
+		log.Printf("ByCountry | %s | Error selecting the news provider => %v. Run skipped.", job.Name, err)
+		return
+	}
+
+	stats := CountryFetchAndStore(myDB, provider, job, country)
+
+	ClusterAndStore(myDB, stats)
+	stats.Log("ByCountry")
 
-	log.Println("ByCountry | News Collection End")
+	log.Printf("ByCountry | %s | News Collection End", job.Name)
 
 }
 
-func FetchAustralia() {
+// FetchSources syncs the source catalogue of the job provider into the 'sources' table
+func FetchSources(job config.Job) {
 
 	log.Println("==========================================================")
-	log.Println("ByCountry | News Collection Start")
+	log.Printf("Sources | %s | Sync Start", job.Name)
 	log.Println("==========================================================")
 
-	/* ** News Client ** */
-	myClient := &http.Client{Timeout: 30 * time.Second}
-	newsapi := news.NewClient(myClient, news_api_key, 100)
-
 	/* ** DB Conn ** */
-	myDB := data.NewDBClient(db_host, db_port, db_name, db_user, db_password, dbconn_max_retries)
+	myDB, err := data.NewDBClient(db_host, db_port, db_name, db_user, db_password, dbconn_max_retries)
+	if err != nil {
+		log.Printf("Sources | %s | %v. Run skipped.", job.Name, err)
+		return
+	}
 
-	// myDB = *DBClient(db_conn)
 	defer myDB.Database.Close()
 
-	log.Println("ByCountry | Closing DB resources.")
+	/* ** News Providers ** */
+	providers := newProviders(myDB)
+
+	// checked when the job is scheduled
+	provider, err := providers.Get(job.Provider)
+	if err != nil {
+		log.Printf("Sources | %s | Error selecting the news provider => %v. Run skipped.", job.Name, err)
+		return
+	}
+
+	stats := SyncSources(myDB, provider, job)
+
+	log.Printf("Sources | Sources inserted: %d, updated: %d, unchanged: %d. Errors: %d", stats.Inserted, stats.Updated, stats.Unchanged, len(stats.Errors))
+	stats.LogErrors("Sources")
+
+	log.Printf("Sources | %s | Sync End", job.Name)
+}
+
+// SyncSources stores the catalogue of the provider, the articles are then linked to the sources by their id
+func SyncSources(myDB store, provider news.Provider, job config.Job) *RunStats {
+
+	stats := &RunStats{}
+
+	catalogue, err := provider.FetchSources()
+	if errors.Is(err, quota.ErrExhausted) {
+		log.Printf("Sources | %v. Sync deferred to the next run.", err)
+		stats.Deferred++
+		return stats
+	}
+	if err != nil {
+		stats.Fail("Sources", "catalogue of '"+provider.Name()+"'", err)
+		return stats
+	}
+
+	log.Printf("Sources | Total sources retrieved from '%s': %d", provider.Name(), len(catalogue.Sources))
+
+	for _, s := range catalogue.Sources {
+
+		// sources without an id can't be linked to the articles
+		if s.ID == "" {
+			continue
+		}
+
+		result, err := myDB.UpsertSource(data.CatalogueSource{
+			NewsAPIID:   s.ID,
+			Name:        s.Name,
+			Description: s.Description,
+			URL:         s.URL,
+			Category:    s.Category,
+			Language:    s.Language,
+			Country:     s.Country,
+		})
+		if err != nil {
+			stats.Fail("Sources", "source '"+s.ID+"'", err)
+			continue
+		}
+		stats.Add(result)
+	}
+
+	return stats
+}
+
+// fetchPages fetches the query page by page, up to maxPages or until all the results are retrieved.
+// Results sorted by date (see news.Results) stop at the first page reaching articles older than q.Since,
+// the next pages having only older ones. Other results (e.g. top headlines) are fetched to the last page.
+// The articles are returned once each, by normalized URL: the older ones too, as they may have been
+// indexed after the previous run (the upsert tells the ones already stored).
+func fetchPages(provider news.Provider, q news.Query, maxPages int) ([]news.Article, error) {
+
+	var articles []news.Article
+	seen := map[string]bool{}
+	retrieved := 0
+
+	for page := 1; page <= maxPages; page++ {
+
+		q.Page = page
+
+		results, err := provider.FetchArticles(q)
+		if err != nil {
+			return articles, err
+		}
+
+		log.Printf("Page %d | Articles: %d, Total results: %d", page, len(results.Articles), results.TotalResults)
+
+		retrieved += len(results.Articles)
+		reachedMark := false
+
+		for _, a := range results.Articles {
+			if a.PublishedAt.Before(q.Since) {
+				reachedMark = true
+			}
+			// pages shift while new articles are published, repeating the last ones of the previous page
+			url := data.NormalizeURL(a.URL)
+			if seen[url] {
+				continue
+			}
+			seen[url] = true
+			articles = append(articles, a)
+		}
+
+		if reachedMark && results.NewestFirst {
+			log.Printf("Page %d | Reached articles older than %v. Stop.", page, q.Since)
+			break
+		}
+
+		if len(results.Articles) == 0 || retrieved >= results.TotalResults {
+			break
+		}
+
+		if page == maxPages {
+			log.Printf("Page %d | Max pages reached, %d results not retrieved.", page, results.TotalResults-retrieved)
+		}
+	}
+
+	return articles, nil
+}
+
+// resumeFrom returns the time to fetch a feed from: its high-water mark less cursor_overlap, for the articles
+// indexed by the provider after the previous run. Zero (everything) if the feed was never collected.
+func resumeFrom(mark time.Time) time.Time {
+
+	if mark.IsZero() {
+		return mark
+	}
+
+	return mark.Add(-cursor_overlap)
+}
 
-	CountryFetchAndStore(myDB, newsapi, "Australia", "English")
+// newest returns the latest publishing time of the articles, zero time if there are none
+func newest(articles []news.Article) time.Time {
 
-	log.Println("ByCountry | News Collection End")
+	var latest time.Time
 
+	for _, a := range articles {
+		if a.PublishedAt.After(latest) {
+			latest = a.PublishedAt
+		}
+	}
+
+	return latest
 }
 
-// API call for each domain - LIMIT per Dev plan reached at 50 calls in 12 hours
-func GlobalFetchAndStore(myDB *data.DBClient, newsapi *news.Client) {
+// newProviders returns the Registry with all the news providers the feeds can be configured with.
+// NEWS API calls are limited by the quota stored in the DB.
+func newProviders(myDB *data.DBClient) news.Registry {
+
+	myClient := &http.Client{Timeout: 30 * time.Second}
+
+	newsapi := news.NewClient(myClient, news_api_key, 100, news.WithBaseURL(news_api_url))
+	newsapi.Budget = quota.NewManager(myDB, newsapi.Name(), newsapi_quota_calls, newsapi_quota_period)
+
+	return news.NewRegistry(
+		newsapi,
+		news.NewRSSClient(myClient),
+	)
+}
+
+// jobFeeds returns the domains to search for the job: the favourites or the ones in the config
+func jobFeeds(myDB store, job config.Job) ([]FavouriteFeed, error) {
+
+	var feeds []FavouriteFeed
+
+	if job.Favourites {
 
-	feedRows := myDB.GetFavourites()
+		favourites, err := myDB.GetFavourites()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, f := range favourites {
+			feeds = append(feeds, FavouriteFeed{id: f.ID, name: f.Name, favourite: true, provider: f.Provider, feedURL: f.FeedURL})
+		}
+
+		return feeds, nil
+	}
+
+	for _, domain := range job.Domains {
+
+		id, err := storeDomain(myDB, "Global", domain)
+		if err != nil {
+			return nil, err
+		}
+
+		feeds = append(feeds, FavouriteFeed{id: id, name: domain, provider: job.Provider})
+	}
+
+	return feeds, nil
+}
 
-	for feedRows.Next() {
+// storeSource returns the id of the source of the article, INSERTing it if it's not in the DB yet.
+// Sources are matched by NEWS API id (see SyncSources), by name only when the article has no source id.
+func storeSource(myDB store, prefix, newsapiID, name string) (int, error) {
 
-		// Scan copies the columns in the current row into the values pointed at by dest.
-		// The number of values in dest must be the same as the number of columns in Rows.
-		err := feedRows.Scan(&thisFeed.id, &thisFeed.name, &thisFeed.favourite)
+	if newsapiID != "" {
+
+		id, err := myDB.GetSourceByNewsAPIID(newsapiID)
 		if err != nil {
-			log.Fatal("Global | Error on reading SQL SELECT results => ", err)
+			return 0, err
+		}
+		if id != 0 {
+			log.Printf("%s | Source '%s' found by id: %d", prefix, newsapiID, id)
+			return id, nil
+		}
+
+		log.Printf("%s | No sources found for id '%s'. Proceed with INSERT.", prefix, newsapiID)
+		return myDB.InsertSource(newsapiID, name)
+	}
+
+	/* ** Check Sources ** */
+	sources, err := myDB.GetSourcesByName(name)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, thisSource := range sources {
+		log.Printf("%s | Records found for source: '%s'", prefix, name)
+		log.Printf("%s |  - rows.id: %d\n", prefix, thisSource.ID)
+		log.Printf("%s |  - rows.name: %s\n", prefix, thisSource.Name)
+	}
+
+	// the 'sources' slice is empty if no rows are returned by the SELECT
+	if len(sources) == 0 {
+		log.Printf("%s | No sources found for '%s'. Proceed with INSERT.", prefix, name)
+
+		/* ** insertSource ** */
+		return myDB.InsertSource("", name)
+	}
+
+	log.Println(prefix, "| The source already exists. No INSERT required.")
+	return sources[len(sources)-1].ID, nil
+}
+
+// storeDomain returns the id of the domain, INSERTing it if it's not in the DB yet
+func storeDomain(myDB store, prefix, name string) (int, error) {
+
+	/* ** Check Domains ** */
+	domains, err := myDB.GetDomainsByName(name)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, thisDomain := range domains {
+		log.Printf("%s | Records found for domain: '%s'", prefix, name)
+		log.Printf("%s |  - rows.id: %d\n", prefix, thisDomain.ID)
+		log.Printf("%s |  - rows.name: %s\n", prefix, thisDomain.Name)
+	}
+
+	// the 'domains' slice is empty if no rows are returned by the SELECT
+	if len(domains) == 0 {
+		log.Printf("%s | No domains found for '%s'. Proceed with INSERT.", prefix, name)
+
+		/* ** insertDomain ** */
+		return myDB.InsertDomain(name)
+	}
+
+	log.Println(prefix, "| The domain already exists. No INSERT required.")
+	return domains[len(domains)-1].ID, nil
+}
+
+// feedBatches groups the feeds searched with the same call: up to news.DomainBatcher limits
+// for the providers supporting it, one feed per call for the others
+func feedBatches(providers news.Registry, feeds []FavouriteFeed) [][]FavouriteFeed {
+
+	var batches [][]FavouriteFeed
+
+	// keep the order of the providers as they come
+	var order []string
+	byProvider := map[string][]FavouriteFeed{}
+	for _, f := range feeds {
+		if _, ok := byProvider[f.provider]; !ok {
+			order = append(order, f.provider)
+		}
+		byProvider[f.provider] = append(byProvider[f.provider], f)
+	}
+
+	for _, name := range order {
+
+		provider, err := providers.Get(name)
+		batcher, ok := provider.(news.DomainBatcher)
+		if err != nil || !ok {
+			for _, f := range byProvider[name] {
+				batches = append(batches, []FavouriteFeed{f})
+			}
+			continue
+		}
+
+		byName := map[string]FavouriteFeed{}
+		names := make([]string, 0, len(byProvider[name]))
+		for _, f := range byProvider[name] {
+			byName[f.name] = f
+			names = append(names, f.name)
+		}
+
+		for _, batchNames := range news.BatchDomains(names, batcher.MaxDomains(), batcher.MaxDomainsLength()) {
+			batch := make([]FavouriteFeed, 0, len(batchNames))
+			for _, n := range batchNames {
+				batch = append(batch, byName[n])
+			}
+			batches = append(batches, batch)
+		}
+	}
+
+	return batches
+}
+
+// matchFeed returns the feed of the batch the article URL belongs to
+func matchFeed(articleURL string, batch []FavouriteFeed) (FavouriteFeed, bool) {
+
+	names := make([]string, 0, len(batch))
+	for _, f := range batch {
+		names = append(names, f.name)
+	}
+
+	domain, ok := news.MatchDomain(articleURL, names)
+	if !ok {
+		return FavouriteFeed{}, false
+	}
+
+	for _, f := range batch {
+		if strings.EqualFold(f.name, domain) {
+			return f, true
+		}
+	}
+
+	return FavouriteFeed{}, false
+}
+
+// One API call for up to 20 domains - LIMIT per Dev plan reached at 50 calls in 12 hours
+func GlobalFetchAndStore(myDB store, providers news.Registry, job config.Job) *RunStats {
+
+	stats := &RunStats{}
+
+	// providers not to call anymore in this run (out of quota, rate limited, wrong API key)
+	stopped := map[string]error{}
+
+	feeds, err := jobFeeds(myDB, job)
+	if err != nil {
+		stats.Fail("Global", "feeds of job '"+job.Name+"'", err)
+		return stats
+	}
+
+	for _, batch := range feedBatches(providers, feeds) {
+
+		thisProvider := batch[0].provider
+
+		names := make([]string, 0, len(batch))
+		for _, f := range batch {
+			names = append(names, f.name)
+		}
+
+		if reason, ok := stopped[thisProvider]; ok {
+			log.Printf("Global | %v. %v deferred to the next run.", reason, names)
+			stats.Deferred += len(batch)
+			continue
 		}
 
 		log.Println("**********************************************************")
-		log.Println("Global | Search ByDomain: ", thisFeed.name)
+		log.Printf("Global | Search ByDomain: %v (provider: %s)", names, thisProvider)
 		log.Println("**********************************************************")
 
-		results, err := newsapi.FetchNews("Global", "", "1", thisFeed.name)
+		provider, err := providers.Get(thisProvider)
 		if err != nil {
-			log.Fatal("Global | Error retrieving news => ", err)
+			stats.Fail("Global", fmt.Sprintf("domains %v", names), err)
+			continue
 		}
 
-		log.Printf("Global | Total results retrieved for '%s': %v", thisFeed.name, results.TotalResults)
+		// resume from the oldest high-water mark of the batch, from scratch if a domain was never collected
+		var since time.Time
+		for i, f := range batch {
+			mark, err := myDB.GetCursor(job.Name, f.name)
+			if err != nil {
+				stats.Fail("Global", "cursor of '"+f.name+"'", err)
+			}
+			if i == 0 || mark.Before(since) {
+				since = mark
+			}
+		}
+		since = resumeFrom(since)
+
+		// feed URLs are per domain, batches of feeds are single domain
+		q := news.Query{Keywords: job.Query, Domains: names, FeedURL: batch[0].feedURL, Language: job.Language, PageSize: job.PageSize, Priority: job.Priority, Since: since}
+		articles, err := fetchPages(provider, q, job.MaxPages)
+		switch {
+		case errors.Is(err, quota.ErrExhausted):
+			// store what we got so far, the next feeds of the provider are skipped
+			log.Printf("Global | %v. %v deferred to the next run.", err, names)
+			stopped[thisProvider] = err
+			stats.Deferred += len(batch)
+		case stopsProvider(provider, err):
+			stats.Fail("Global", fmt.Sprintf("domains %v", names), err)
+			stopped[thisProvider] = err
+		case err != nil:
+			// store what we got so far and go on with the next feeds
+			stats.Fail("Global", fmt.Sprintf("domains %v", names), err)
+		}
+
+		log.Printf("Global | Total articles retrieved for %v: %v", names, len(articles))
 
 		log.Println("--------------------------------------------------------")
 		log.Println("Global | Iterating on Articles.")
 		log.Println("--------------------------------------------------------")
 
-		for i, newsArticle := range results.Articles {
+		// articles stored per domain, to move the high-water marks
+		stored := map[string][]news.Article{}
+		// domains with articles not stored, their high-water marks stay where they are
+		failed := map[string]bool{}
+
+		for i, newsArticle := range articles {
 			log.Printf("Global | Article #%d | Title: '%s'", i+1, newsArticle.Title)
 
-			sources := make([]string, 0)
+			// attribute the article to its domain by URL host when the call was for more domains
+			thisFeed, ok := batch[0], true
+			if len(batch) > 1 {
+				thisFeed, ok = matchFeed(newsArticle.URL, batch)
+			}
+			if !ok {
+				log.Printf("Global | URL '%s' doesn't belong to any of %v. Skipped.", newsArticle.URL, names)
+				continue
+			}
 
 			// exists for sure
-			domainID := myDB.GetDomainID(thisFeed.name)
+			domainID := thisFeed.id
 
-			/* ** Check Sources ** */
-			sourceRows := myDB.GetSourcesByName(newsArticle.Source.Name)
+			sourceID, err := storeSource(myDB, "Global", newsArticle.Source.ID, newsArticle.Source.Name)
+			if err != nil {
+				stats.Fail("Global", "source of '"+newsArticle.URL+"'", err)
+				failed[thisFeed.name] = true
+				continue
+			}
 
-			for sourceRows.Next() {
+			/* ** UpsertArticle ** */
+			result, articleID, err := myDB.UpsertArticle(sourceID, domainID, newsArticle.Author, newsArticle.Title, newsArticle.Description, newsArticle.URL, newsArticle.URLToImage, newsArticle.PublishedAt, newsArticle.Content, "", "", "")
+			if err != nil {
+				stats.Fail("Global", "article '"+newsArticle.URL+"'", err)
+				failed[thisFeed.name] = true
+				continue
+			}
+			stats.AddArticle(result, articleID)
+			stored[thisFeed.name] = append(stored[thisFeed.name], newsArticle)
 
-				// Scan copies the columns in the current row into the values pointed at by dest.
-				// The number of values in dest must be the same as the number of columns in Rows.
-				err := sourceRows.Scan(&thisSource.id, &thisSource.name)
-				if err != nil {
-					log.Fatal("Global | Error on reading SQL SELECT results => ", err)
-				}
-				// fill the slice to check if there are rows later
-				sources = append(sources, thisSource.name)
-				log.Printf("Global | Records found for source: '%s'", newsArticle.Source.Name)
-				log.Printf("Global |  - rows.id: %d\n", thisSource.id)
-				log.Printf("Global |  - rows.name: %s\n", thisSource.name)
+			log.Println("--------------------------------------------------------")
 
+		}
+
+		// pages missed because of errors are fetched again next run
+		if err == nil {
+			for name, domainArticles := range stored {
+				if failed[name] {
+					continue
+				}
+				if err := myDB.SetCursor(job.Name, name, newest(domainArticles)); err != nil {
+					stats.Fail("Global", "cursor of '"+name+"'", err)
+				}
 			}
-			log.Println("Global | Closing rows resources.")
-			defer sourceRows.Close()
-
-			// the 'source' slide is empty if no rows are returned by the SELECT
-			if len(sources) == 0 {
-				log.Printf("Global | No sources found for '%s'. Proceed with INSERT.", newsArticle.Source.Name)
-
-				/* ** insertSource ** */
-				sourceID = myDB.InsertSource(newsArticle.Source.Name)
-			} else {
-				log.Println("Global | The source already exists. No INSERT required.")
-				sourceID = thisSource.id
-			}
+		}
 
-			/* ** InsertArticle ** */
-			myDB.InsertArticle(sourceID, domainID, newsArticle.Author, newsArticle.Title, newsArticle.Description, newsArticle.URL, newsArticle.URLToImage, newsArticle.PublishedAt, newsArticle.Content, "", "", "")
+	}
 
-			log.Println("--------------------------------------------------------")
+	return stats
 
-		}
+}
 
+// CountryFetchAndStore collects the top headlines of the country, one category at a time if the job has categories
+func CountryFetchAndStore(myDB store, provider news.Provider, job config.Job, country data.Country) *RunStats {
+
+	stats := &RunStats{}
+
+	// no category for all the headlines
+	categories := job.Categories
+	if len(categories) == 0 {
+		categories = []string{""}
+	}
+
+	for i, category := range categories {
+		if err := countryCategoryFetchAndStore(myDB, provider, job, country, category, stats); stopsProvider(provider, err) {
+			log.Printf("ByCountry | %v. Categories %v deferred to the next run.", err, categories[i+1:])
+			stats.Deferred += len(categories) - i - 1
+			break
+		}
 	}
 
+	return stats
 }
 
-func CountryFetchAndStore(myDB *data.DBClient, newsapi *news.Client, country, language string) {
+// countryCategoryFetchAndStore collects the top headlines of the country in the category (all if empty),
+// returning the error of the fetch, if any
+func countryCategoryFetchAndStore(myDB store, provider news.Provider, job config.Job, country data.Country, category string, stats *RunStats) error {
+
+	// the high-water mark is per category
+	feed := country.Code
+	if category != "" {
+		feed = country.Code + "/" + category
+	}
 
-	/* ********** Start with Italy ***************************************** */
 	log.Println("**********************************************************")
-	log.Println("ByCountry | Search :", country)
+	log.Println("ByCountry | Search :", country.Name, category)
 	log.Println("**********************************************************")
 
 	// -- potentially can call a function
@@ -253,24 +969,34 @@ func CountryFetchAndStore(myDB *data.DBClient, newsapi *news.Client, country, la
 	// CheckAdStore (DB, country, source, domain) ?!
 	// CheckAndStore (myDB, "Italy", newsArticle.Source.Name, domain)
 
-	results, err := newsapi.FetchNews("ByCountry", "", "1", country)
+	// resume from the high-water mark of the country
+	since, err := myDB.GetCursor(job.Name, feed)
 	if err != nil {
-		log.Fatal("ByCountry | Error retrieving news => ", err)
+		stats.Fail("ByCountry", "cursor of '"+feed+"'", err)
+	}
+	since = resumeFrom(since)
+
+	q := news.Query{Keywords: job.Query, Country: country.Code, Category: category, Language: job.Language, PageSize: job.PageSize, Priority: job.Priority, Since: since}
+	articles, err := fetchPages(provider, q, job.MaxPages)
+	if errors.Is(err, quota.ErrExhausted) {
+		// store what we got so far
+		log.Printf("ByCountry | %v. '%s' deferred to the next run.", err, feed)
+		stats.Deferred++
+	} else if err != nil {
+		stats.Fail("ByCountry", "country '"+feed+"'", err)
 	}
 
-	log.Printf("ByCountry | Total results retrieved for '%s': %v", country, results.TotalResults)
+	log.Printf("ByCountry | Total articles retrieved for '%s': %v", country.Name, len(articles))
 
 	log.Println("--------------------------------------------------------")
 	log.Println("ByCountry | Iterating on Articles.")
 	log.Println("--------------------------------------------------------")
 
-	for i, newsArticle := range results.Articles {
-		log.Printf("ByCountry |  Article #%d | Title: '%s'", i+1, newsArticle.Title)
+	// articles not stored keep the high-water mark where it is
+	failed := false
 
-		// there's nothing provided in the Sql package to check if Rows has no records inside
-		// so I define an empty slice and fill it in the iteration below
-		sources := make([]string, 0)
-		domains := make([]string, 0)
+	for i, newsArticle := range articles {
+		log.Printf("ByCountry |  Article #%d | Title: '%s'", i+1, newsArticle.Title)
 
 		/* ** Extract Domain ** */
 		// extract domain name from article URL. From https//www.techcrunch.com/zyx TO techcrunch.com
@@ -282,77 +1008,106 @@ func CountryFetchAndStore(myDB *data.DBClient, newsapi *news.Client, country, la
 		domain := components[0]
 		log.Println("ByCountry | Domain extracted from URL: ", domain)
 
-		/* ** Check Domains ** */
-		domainRows := myDB.GetDomainsByName(domain)
-
-		for domainRows.Next() {
+		domainID, err := storeDomain(myDB, "ByCountry", domain)
+		if err != nil {
+			stats.Fail("ByCountry", "domain of '"+newsArticle.URL+"'", err)
+			failed = true
+			continue
+		}
 
-			// Scan copies the columns in the current row into the values pointed at by dest.
-			// The number of values in dest must be the same as the number of columns in Rows.
-			err := domainRows.Scan(&thisDomain.id, &thisDomain.name, &thisDomain.favourite)
-			if err != nil {
-				log.Fatal("ByCountry | Error on reading SQL SELECT results => ", err)
-			}
-			// fill the slice to check if there are rows later
-			domains = append(domains, thisDomain.name)
-			log.Printf("ByCountry | Records found for domain: '%s'", domain)
-			log.Printf("ByCountry |  - rows.id: %d\n", thisDomain.id)
-			log.Printf("ByCountry |  - rows.name: %s\n", thisDomain.name)
+		sourceID, err := storeSource(myDB, "ByCountry", newsArticle.Source.ID, newsArticle.Source.Name)
+		if err != nil {
+			stats.Fail("ByCountry", "source of '"+newsArticle.URL+"'", err)
+			failed = true
+			continue
+		}
 
+		/* ** UpsertArticle ** */
+		result, articleID, err := myDB.UpsertArticle(sourceID, domainID, newsArticle.Author, newsArticle.Title, newsArticle.Description, newsArticle.URL, newsArticle.URLToImage, newsArticle.PublishedAt, newsArticle.Content, country.Name, country.Language, category)
+		if err != nil {
+			stats.Fail("ByCountry", "article '"+newsArticle.URL+"'", err)
+			failed = true
+			continue
 		}
-		log.Println("ByCountry | Closing rows resources.")
-		defer domainRows.Close()
+		stats.AddArticle(result, articleID)
+
+		log.Println("--------------------------------------------------------")
 
-		// the 'source' slide is empty if no rows are returned by the SELECT
-		if len(domains) == 0 {
-			log.Printf("ByCountry | No domains found for '%s'. Proceed with INSERT.", domain)
+	}
 
-			/* ** insertDomain ** */
-			domainID = myDB.InsertDomain(domain)
-		} else {
-			log.Println("ByCountry | The domain already exists. No INSERT required.")
-			domainID = thisDomain.id
+	// pages missed because of errors are fetched again next run
+	if err == nil && !failed && len(articles) > 0 {
+		if err := myDB.SetCursor(job.Name, feed, newest(articles)); err != nil {
+			stats.Fail("ByCountry", "cursor of '"+feed+"'", err)
 		}
+	}
 
-		/* ** Check Sources ** */
-		sourceRows := myDB.GetSourcesByName(newsArticle.Source.Name)
+	return err
 
-		for sourceRows.Next() {
+}
 
-			// Scan copies the columns in the current row into the values pointed at by dest.
-			// The number of values in dest must be the same as the number of columns in Rows.
-			err := sourceRows.Scan(&thisSource.id, &thisSource.name)
-			if err != nil {
-				log.Fatal("ByCountry | Error on reading SQL SELECT results => ", err)
-			}
-			// fill the slice to check if there are rows later
-			sources = append(sources, thisSource.name)
-			log.Printf("ByCountry | Records found for source: '%s'", newsArticle.Source.Name)
-			log.Printf("ByCountry |  - rows.id: %d\n", thisSource.id)
-			log.Printf("ByCountry |  - rows.name: %s\n", thisSource.name)
+// ClusterAndStore groups the articles inserted by the run with their near-duplicates into story clusters.
+// Each article joins the closest recent cluster, or starts a new one being its representative.
+// Articles without words to fingerprint, or failing, are left unclustered: they are shown on their own.
+func ClusterAndStore(myDB store, stats *RunStats) {
 
-		}
-		log.Println("ByCountry | Closing rows resources.")
-		defer sourceRows.Close()
+	log.Println("Clustering | Start")
 
-		// the 'source' slide is empty if no rows are returned by the SELECT
-		if len(sources) == 0 {
-			log.Printf("ByCountry | No sources found for '%s'. Proceed with INSERT.", newsArticle.Source.Name)
+	if len(stats.InsertedIDs) == 0 {
+		log.Println("Clustering | No new articles. End")
+		return
+	}
 
-			/* ** insertSource ** */
-			sourceID = myDB.InsertSource(newsArticle.Source.Name)
-		} else {
-			log.Println("ByCountry | The source already exists. No INSERT required.")
-			sourceID = thisSource.id
+	clusters, err := myDB.GetRecentClusters(cluster_window_days)
+	if err != nil {
+		stats.Fail("Clustering", "story clusters", err)
+		return
+	}
+	articles, err := myDB.GetArticlesToCluster(stats.InsertedIDs)
+	if err != nil {
+		stats.Fail("Clustering", "articles to cluster", err)
+		return
+	}
+
+	joined, skipped := 0, 0
+
+	for _, article := range articles {
+
+		fingerprint, ok := cluster.Fingerprint(article.Title + " " + article.Description)
+		if !ok {
+			log.Printf("Clustering | Article %d has no words to fingerprint. Not clustered.", article.ID)
+			skipped++
+			continue
 		}
 
-		/* ** InsertArticle ** */
-		myDB.InsertArticle(sourceID, domainID, newsArticle.Author, newsArticle.Title, newsArticle.Description, newsArticle.URL, newsArticle.URLToImage, newsArticle.PublishedAt, newsArticle.Content, country, language, "")
+		clusterID := 0
+		bestDistance := cluster.MaxDistance + 1
+		for _, c := range clusters {
+			if d := cluster.Distance(fingerprint, c.Fingerprint); d < bestDistance {
+				clusterID = c.ID
+				bestDistance = d
+			}
+		}
 
-		log.Println("--------------------------------------------------------")
+		if clusterID == 0 {
+			clusterID, err = myDB.InsertCluster(article.ID, fingerprint)
+			if err != nil {
+				stats.Fail("Clustering", fmt.Sprintf("cluster of article %d", article.ID), err)
+				continue
+			}
+			clusters = append(clusters, data.StoryCluster{ID: clusterID, Fingerprint: fingerprint})
+		} else {
+			log.Printf("Clustering | Article %d is a near-duplicate (distance %d) of cluster %d", article.ID, bestDistance, clusterID)
+			joined++
+		}
 
+		if err := myDB.SetArticleCluster(article.ID, clusterID, fingerprint); err != nil {
+			stats.Fail("Clustering", fmt.Sprintf("cluster of article %d", article.ID), err)
+		}
 	}
 
+	log.Printf("Clustering | Articles clustered: %d, near-duplicates: %d, not clustered: %d", len(articles)-skipped, joined, skipped)
+	log.Println("Clustering | End")
 }
 
 func getEnv() map[string]string {
@@ -376,5 +1131,19 @@ func getEnv() map[string]string {
 	envMap["db_host"] = db_host
 	envMap["db_password"] = db_password
 
+	// optional, defaults to the config in the working dir
+	config_file := os.Getenv("CONFIG_FILE")
+	if config_file == "" {
+		config_file = "./config.yaml"
+	}
+	envMap["config_file"] = config_file
+
+	// optional, defaults to the real NEWS API
+	news_api_url := os.Getenv("NEWS_API_URL")
+	if news_api_url == "" {
+		news_api_url = news.DefaultBaseURL
+	}
+	envMap["news_api_url"] = news_api_url
+
 	return envMap
 }
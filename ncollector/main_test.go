@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/ncollector/news"
+	"github.com/mesmerai/news-aggregator/ncollector/quota"
+)
+
+// pagesProvider returns its pages in order, counting the calls
+type pagesProvider struct {
+	pages       [][]news.Article
+	newestFirst bool
+	calls       int
+}
+
+func (p *pagesProvider) Name() string { return "pages" }
+
+func (p *pagesProvider) FetchSources() (*news.Sources, error) { return &news.Sources{}, nil }
+
+func (p *pagesProvider) FetchArticles(q news.Query) (*news.Results, error) {
+
+	p.calls++
+
+	total := 0
+	for _, page := range p.pages {
+		total += len(page)
+	}
+
+	res := &news.Results{Status: "ok", TotalResults: total, NewestFirst: p.newestFirst}
+	if q.Page <= len(p.pages) {
+		res.Articles = p.pages[q.Page-1]
+	}
+
+	return res, nil
+}
+
+func article(url string, publishedAt time.Time) news.Article {
+	return news.Article{URL: url, Title: url, PublishedAt: publishedAt}
+}
+
+func urls(articles []news.Article) string {
+	var list []string
+	for _, a := range articles {
+		list = append(list, a.URL)
+	}
+	return fmt.Sprint(list)
+}
+
+func TestFetchPagesNewestFirst(t *testing.T) {
+
+	mark := time.Date(2021, 11, 15, 12, 0, 0, 0, time.UTC)
+
+	p := &pagesProvider{newestFirst: true, pages: [][]news.Article{
+		{article("https://a.it/1", mark.Add(3*time.Hour)), article("https://a.it/2", mark.Add(2*time.Hour))},
+		{article("https://a.it/3", mark.Add(time.Hour)), article("https://a.it/4", mark.Add(-time.Hour))},
+		{article("https://a.it/5", mark.Add(-2*time.Hour))},
+	}}
+
+	articles, err := fetchPages(p, news.Query{Since: mark}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// stops at the page reaching the mark, keeping its older article
+	if p.calls != 2 {
+		t.Errorf("calls = %d, want 2", p.calls)
+	}
+	if got, want := urls(articles), "[https://a.it/1 https://a.it/2 https://a.it/3 https://a.it/4]"; got != want {
+		t.Errorf("articles = %s, want %s", got, want)
+	}
+}
+
+func TestFetchPagesNotSorted(t *testing.T) {
+
+	mark := time.Date(2021, 11, 15, 12, 0, 0, 0, time.UTC)
+
+	// top headlines: an old story ranked first doesn't hide the new ones of the next pages
+	p := &pagesProvider{pages: [][]news.Article{
+		{article("https://a.it/old", mark.Add(-24*time.Hour)), article("https://a.it/1", mark.Add(time.Hour))},
+		{article("https://a.it/2", mark.Add(2*time.Hour))},
+	}}
+
+	articles, err := fetchPages(p, news.Query{Country: "it", Since: mark}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.calls != 2 {
+		t.Errorf("calls = %d, want 2", p.calls)
+	}
+	if got, want := urls(articles), "[https://a.it/old https://a.it/1 https://a.it/2]"; got != want {
+		t.Errorf("articles = %s, want %s", got, want)
+	}
+}
+
+func TestFetchPagesDedupe(t *testing.T) {
+
+	now := time.Now()
+
+	// the pages shifted between the calls, the same article is on both
+	p := &pagesProvider{newestFirst: true, pages: [][]news.Article{
+		{article("https://a.it/1", now), article("https://a.it/2", now)},
+		{article("https://A.it/2/?utm_source=rss", now), article("https://a.it/3", now)},
+	}}
+
+	articles, err := fetchPages(p, news.Query{}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := urls(articles), "[https://a.it/1 https://a.it/2 https://a.it/3]"; got != want {
+		t.Errorf("articles = %s, want %s", got, want)
+	}
+}
+
+func TestFetchPagesMaxPages(t *testing.T) {
+
+	now := time.Now()
+
+	p := &pagesProvider{pages: [][]news.Article{
+		{article("https://a.it/1", now)},
+		{article("https://a.it/2", now)},
+		{article("https://a.it/3", now)},
+	}}
+
+	articles, err := fetchPages(p, news.Query{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.calls != 2 || len(articles) != 2 {
+		t.Errorf("calls = %d, articles = %s, want 2 of each", p.calls, urls(articles))
+	}
+}
+
+func TestResumeFrom(t *testing.T) {
+
+	if got := resumeFrom(time.Time{}); !got.IsZero() {
+		t.Errorf("resumeFrom(zero) = %v, want zero", got)
+	}
+
+	mark := time.Date(2021, 11, 15, 12, 0, 0, 0, time.UTC)
+	if got := resumeFrom(mark); !got.Equal(mark.Add(-cursor_overlap)) {
+		t.Errorf("resumeFrom(%v) = %v", mark, got)
+	}
+}
+
+func TestStopsProvider(t *testing.T) {
+
+	newsapi := news.NewClient(nil, "key", 100)
+	rss := news.NewRSSClient(nil)
+
+	tests := []struct {
+		provider news.Provider
+		err      error
+		want     bool
+	}{
+		{newsapi, fmt.Errorf("domains [ansa.it]: %w", quota.ErrExhausted), true},
+		{newsapi, fmt.Errorf("domains [ansa.it]: %w", &news.APIError{StatusCode: 429, Code: "rateLimited"}), true},
+		{newsapi, fmt.Errorf("domains [ansa.it]: %w", &news.APIError{StatusCode: 401, Code: "apiKeyInvalid"}), true},
+		{newsapi, fmt.Errorf("domains [ansa.it]: %w", &news.APIError{StatusCode: 503}), false},
+		{newsapi, nil, false},
+		// a site refusing its feed says nothing about the other sites
+		{rss, fmt.Errorf("feed 'https://www.ansa.it/rss.xml': %w", &news.APIError{StatusCode: 429}), false},
+		{rss, fmt.Errorf("feed 'https://www.ansa.it/rss.xml': %w", &news.APIError{StatusCode: 401}), false},
+	}
+
+	for _, tt := range tests {
+		if got := stopsProvider(tt.provider, tt.err); got != tt.want {
+			t.Errorf("stopsProvider(%s, %v) = %v, want %v", tt.provider.Name(), tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("domains [ansa.it]: %w", &news.APIError{StatusCode: 401, Code: "apiKeyInvalid"}), "API key not valid"},
+		{fmt.Errorf("domains [ansa.it]: %w", &news.APIError{StatusCode: 429, Code: "rateLimited"}), "rate limited"},
+		{fmt.Errorf("domains [ansa.it]: %w", &news.APIError{StatusCode: 503}), "provider unavailable"},
+		{fmt.Errorf("domains [ansa.it]: %w", &news.APIError{StatusCode: 400, Code: "sourcesTooMany"}), "request not valid"},
+		{fmt.Errorf("domains [ansa.it]: %w", &news.APIError{StatusCode: 426}), "other"},
+	}
+
+	for _, tt := range tests {
+		if got := errorKind(tt.err); got != tt.want {
+			t.Errorf("errorKind(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package news
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DomainBatcher is implemented by the providers able to search several domains with one call,
+// like NEWS API with the comma separated 'domains' param
+type DomainBatcher interface {
+	// MaxDomains is the max number of domains per call
+	MaxDomains() int
+	// MaxDomainsLength is the max length of the joined domains, to keep the URL within the limits
+	MaxDomainsLength() int
+}
+
+// BatchDomains splits the domains in batches of at most maxDomains, joined with ',' in at most maxLength chars.
+// A domain longer than maxLength ends up alone in its batch.
+func BatchDomains(domains []string, maxDomains, maxLength int) [][]string {
+
+	var batches [][]string
+	var batch []string
+	length := 0
+
+	for _, d := range domains {
+
+		// +1 for the comma
+		if len(batch) > 0 && (len(batch) >= maxDomains || length+1+len(d) > maxLength) {
+			batches = append(batches, batch)
+			batch = nil
+			length = 0
+		}
+
+		if len(batch) > 0 {
+			length++
+		}
+		length += len(d)
+		batch = append(batch, d)
+	}
+
+	if len(batch) > 0 {
+		batches = append(batches, batch)
+	}
+
+	return batches
+}
+
+// MatchDomain returns the domain the article URL belongs to: the host is the domain or one of its subdomains
+// (e.g. 'www.ansa.it' and 'ansa.it'). The longest match wins, so 'sport.corriere.it' is not 'corriere.it' if both are listed.
+func MatchDomain(articleURL string, domains []string) (string, bool) {
+
+	u, err := url.Parse(articleURL)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	match := ""
+
+	for _, d := range domains {
+		d = strings.ToLower(d)
+		if (host == d || strings.HasSuffix(host, "."+d)) && len(d) > len(match) {
+			match = d
+		}
+	}
+
+	return match, match != ""
+}
@@ -0,0 +1,112 @@
+package news
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// domains returns n domains named dN.<tld>
+func domains(n int, tld string) []string {
+	var ds []string
+	for i := 1; i <= n; i++ {
+		ds = append(ds, fmt.Sprintf("d%d.%s", i, tld))
+	}
+	return ds
+}
+
+func TestBatchDomains(t *testing.T) {
+
+	client := NewClient(nil, "key", 100)
+	maxDomains, maxLength := client.MaxDomains(), client.MaxDomainsLength()
+
+	long := strings.Repeat("a", 60) + ".com"
+
+	tests := []struct {
+		name    string
+		domains []string
+		// number of domains of each batch
+		want []int
+	}{
+		{"none", nil, nil},
+		{"one", []string{"ansa.it"}, []int{1}},
+		{"20 domains in one call", domains(20, "it"), []int{20}},
+		{"21 domains in two", domains(21, "it"), []int{20, 1}},
+		{"45 domains", domains(45, "it"), []int{20, 20, 5}},
+		// 16 domains of 64 chars and the commas are 1039 chars
+		{"1000 chars", []string{long, long, long, long, long, long, long, long, long, long, long, long, long, long, long, long}, []int{15, 1}},
+		{"longer than all", []string{"ansa.it", strings.Repeat("x", 1200) + ".com", "corriere.it"}, []int{1, 1, 1}},
+	}
+
+	for _, tt := range tests {
+
+		batches := BatchDomains(tt.domains, maxDomains, maxLength)
+
+		var got []int
+		var all []string
+		for _, b := range batches {
+			got = append(got, len(b))
+			all = append(all, b...)
+
+			if len(b) > maxDomains {
+				t.Errorf("%s: batch of %d domains", tt.name, len(b))
+			}
+			if joined := strings.Join(b, ","); len(joined) > maxLength && len(b) > 1 {
+				t.Errorf("%s: batch of %d chars", tt.name, len(joined))
+			}
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: batches of %v domains, want %v", tt.name, got, tt.want)
+		}
+		// all the domains, in order
+		if strings.Join(all, " ") != strings.Join(tt.domains, " ") {
+			t.Errorf("%s: domains %v, want %v", tt.name, all, tt.domains)
+		}
+	}
+
+	// exactly maxLength chars fit in one call
+	exact := []string{strings.Repeat("a", 495) + ".it", strings.Repeat("b", 498) + ".it"}
+	if batches := BatchDomains(exact, maxDomains, 1000); len(batches) != 1 {
+		t.Errorf("1000 chars: %d batches, want 1", len(batches))
+	}
+	if batches := BatchDomains(append(exact[:1:1], exact[1]+"x"), maxDomains, 1000); len(batches) != 2 {
+		t.Errorf("1001 chars: %d batches, want 2", len(batches))
+	}
+}
+
+func TestMatchDomain(t *testing.T) {
+
+	listed := []string{"ansa.it", "corriere.it", "sport.corriere.it", "BBC.co.uk"}
+
+	tests := []struct {
+		url   string
+		match string
+	}{
+		{"https://ansa.it/a.html", "ansa.it"},
+		{"https://www.ansa.it/a.html", "ansa.it"},
+		{"https://WWW.ANSA.IT/a.html", "ansa.it"},
+		{"https://www.ansa.it:443/a.html", "ansa.it"},
+		{"https://roma.corriere.it/a.html", "corriere.it"},
+		// the longest match wins
+		{"https://sport.corriere.it/a.html", "sport.corriere.it"},
+		{"https://live.sport.corriere.it/a.html", "sport.corriere.it"},
+		{"https://www.bbc.co.uk/news/1", "bbc.co.uk"},
+
+		// the host only, whole labels
+		{"https://notansa.it/a.html", ""},
+		{"https://ansa.it.example.com/a.html", ""},
+		{"https://example.com/ansa.it", ""},
+		{"https://example.com/?u=https://ansa.it", ""},
+		{"/a.html", ""},
+		{"", ""},
+		{"://ansa.it", ""},
+	}
+
+	for _, tt := range tests {
+		match, ok := MatchDomain(tt.url, listed)
+		if match != tt.match || ok != (tt.match != "") {
+			t.Errorf("MatchDomain(%q) = %q, %t, want %q", tt.url, match, ok, tt.match)
+		}
+	}
+}
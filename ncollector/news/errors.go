@@ -0,0 +1,124 @@
+package news
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Errors of the provider calls, matched with errors.Is so the fetch loops can decide
+// whether to skip the feed, stop calling the provider or give up the whole run
+var (
+	// ErrRateLimited is returned when the provider refuses the call for too many requests (HTTP 429)
+	ErrRateLimited = errors.New("rate limited by the provider")
+	// ErrAPIKeyInvalid is returned when the API key is missing, wrong or disabled (HTTP 401): no call will succeed
+	ErrAPIKeyInvalid = errors.New("API key not valid")
+	// ErrUpstreamUnavailable is returned when the provider can't be reached or fails (network errors, HTTP 5xx)
+	ErrUpstreamUnavailable = errors.New("provider unavailable")
+	// ErrBadRequest is returned when the provider rejects the params of the call (HTTP 400), e.g. too many sources
+	ErrBadRequest = errors.New("request not valid")
+)
+
+// APIError is the error body returned by NEWS API, e.g.
+// {"status": "error", "code": "apiKeyInvalid", "message": "Your API key is invalid or incorrect."}
+// See https://newsapi.org/docs/errors
+type APIError struct {
+	StatusCode int           `json:"-"`
+	Status     string        `json:"status"`
+	Code       string        `json:"code"`
+	Message    string        `json:"message"`
+	RetryAfter time.Duration `json:"-"` // from the Retry-After header, 0 if not set
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("status %d, %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Unwrap maps the NEWS API code (or the HTTP status for the codes we don't know) to the errors of the package
+func (e *APIError) Unwrap() error {
+
+	switch e.Code {
+	case "rateLimited", "apiKeyExhausted":
+		return ErrRateLimited
+	case "apiKeyDisabled", "apiKeyInvalid", "apiKeyMissing":
+		return ErrAPIKeyInvalid
+	case "parameterInvalid", "parametersMissing", "sourcesTooMany", "sourceDoesNotExist":
+		return ErrBadRequest
+	case "unexpectedError":
+		return ErrUpstreamUnavailable
+	}
+
+	return statusKind(e.StatusCode)
+}
+
+// parseAPIError reads the error of a NEWS API response with a status other than 200 OK.
+// Bodies not in the NEWS API format (e.g. from a proxy) are kept as the message.
+func parseAPIError(resp *http.Response, body []byte) *APIError {
+
+	apiErr := &APIError{}
+	if err := json.Unmarshal(body, apiErr); err != nil || apiErr.Message == "" {
+		apiErr = &APIError{Message: strings.TrimSpace(string(body))}
+	}
+
+	apiErr.StatusCode = resp.StatusCode
+	apiErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+
+	return apiErr
+}
+
+// parseRetryAfter reads the Retry-After header, in seconds or as an HTTP date
+func parseRetryAfter(value string, now time.Time) time.Duration {
+
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
+
+// statusKind returns the error of the package for the HTTP status, nil if there's none
+func statusKind(statusCode int) error {
+
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode == http.StatusUnauthorized:
+		return ErrAPIKeyInvalid
+	case statusCode == http.StatusBadRequest:
+		return ErrBadRequest
+	case statusCode >= http.StatusInternalServerError:
+		return ErrUpstreamUnavailable
+	}
+
+	return nil
+}
+
+// statusError returns the error for a response with a status other than 200 OK
+func statusError(statusCode int, body []byte) error {
+
+	detail := fmt.Sprintf("status %d", statusCode)
+	if len(body) > 0 {
+		detail += ": " + string(body)
+	}
+
+	if kind := statusKind(statusCode); kind != nil {
+		return fmt.Errorf("%w: %s", kind, detail)
+	}
+
+	return errors.New(detail)
+}
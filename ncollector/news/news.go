@@ -2,11 +2,14 @@ package news
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
@@ -29,9 +32,11 @@ type Results struct {
 	Status       string    `json:"status"`
 	TotalResults int       `json:"totalResults"`
 	Articles     []Article `json:"articles"`
+	// NewestFirst tells the articles are sorted by publishing date, the next pages having older ones
+	NewestFirst bool `json:"-"`
 }
 
-/* Source struct (not used yet) */
+/* Source struct */
 type Source struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
@@ -47,13 +52,10 @@ type Sources struct {
 	Sources []Source `json:"sources"`
 }
 
-// Countries Map
-var countries = map[string]string{
-	"Australia": "au",
-	"Italy":     "it",
-}
+// DefaultBaseURL is the NEWS API server used when NewClient gets no WithBaseURL option
+const DefaultBaseURL = "https://newsapi.org"
 
-/* Client is our struct for the News Client
+/* Client is our struct for the NewsAPI Client, implementing the Provider interface
 - http is a pointer to the httpClient itself that makes the web requests
 - key is the API key
 - PageSize is the number of results to return per page (max 100)
@@ -62,16 +64,44 @@ var countries = map[string]string{
 type Client struct {
 	http     *http.Client
 	key      string
+	baseURL  string
 	PageSize int
+	// Budget is consulted before every request, nil for no limits
+	Budget Budget
+	// Retries is the max number of retries of a call failing for transient errors (network, HTTP 5xx, Retry-After)
+	Retries int
+	// Backoff is the wait before the first retry, doubled at every retry and jittered
+	Backoff time.Duration
+	// MaxWait caps the wait between retries: a Retry-After longer than that is not waited for
+	MaxWait time.Duration
+	// sleep waits between retries, replaced in tests
+	sleep func(time.Duration)
+}
+
+// Option changes the defaults of the Client created by NewClient
+type Option func(*Client)
+
+// WithBaseURL points the Client to another NEWS API compatible server (scheme, host and optional path prefix),
+// e.g. a caching proxy or the fake server of the newsapitest package
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
 }
 
 // NewClient function creates our Client used for requests
-func NewClient(httpClient *http.Client, key string, pageSize int) *Client {
+func NewClient(httpClient *http.Client, key string, pageSize int, options ...Option) *Client {
 	if pageSize > 100 {
 		pageSize = 100
 	}
 
-	return &Client{httpClient, key, pageSize}
+	c := &Client{http: httpClient, key: key, baseURL: DefaultBaseURL, PageSize: pageSize, Retries: 3, Backoff: time.Second, MaxWait: time.Minute, sleep: time.Sleep}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
 }
 
 // format the 'PublishedAt' date
@@ -83,18 +113,72 @@ func (a *Article) FormatPublishedDate() string {
 
 }
 
-func (c *Client) FetchSources() {
+// Name returns the provider name used in the configuration
+func (c *Client) Name() string {
+	return "newsapi"
+}
 
-	var endpoint = ""
+// MaxDomains implements DomainBatcher: NEWS API accepts up to 20 domains per call
+func (c *Client) MaxDomains() int {
+	return 20
+}
+
+// MaxDomainsLength implements DomainBatcher, keeping the request URL far from the 2K chars limit of proxies
+func (c *Client) MaxDomainsLength() int {
+	return 1000
+}
+
+// take consumes an API call from the Budget, if any
+func (c *Client) take(priority string) error {
+	if c.Budget == nil {
+		return nil
+	}
+	return c.Budget.Take(priority)
+}
+
+// get calls the endpoint and returns the body of the response, retrying the transient errors.
+// Every attempt is an API call taken from the Budget.
+func (c *Client) get(endpoint, priority string) ([]byte, error) {
 
-	//https://newsapi.org/v2/top-headlines/sources?apiKey=API_KEY
-	endpoint = fmt.Sprintf("https://newsapi.org/v2/top-headlines/sources?apiKey=%s", c.key)
+	for attempt := 0; ; attempt++ {
 
-	resp, err := c.http.Get(endpoint)
+		if err := c.take(priority); err != nil {
+			return nil, err
+		}
+
+		body, err := c.getOnce(endpoint)
+		if err == nil {
+			return body, nil
+		}
+
+		wait, retry := c.retryWait(err, attempt)
+		if !retry {
+			return nil, err
+		}
+
+		log.Printf("NEWS API | %v. Retry #%d in %v", err, attempt+1, wait.Round(time.Millisecond))
+		if c.sleep != nil {
+			c.sleep(wait)
+		} else {
+			time.Sleep(wait)
+		}
+	}
+}
+
+// getOnce makes a single call. The API key goes in the header, so it's never part of the errors logged.
+func (c *Client) getOnce(endpoint string) ([]byte, error) {
+
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Api-Key", c.key)
+
+	resp, err := c.http.Do(req)
 
 	// Handle error from the response
 	if err != nil {
-		log.Fatal("Error getting a response => ", err)
+		return nil, fmt.Errorf("%w: error getting a response => %v", ErrUpstreamUnavailable, err)
 	}
 
 	defer resp.Body.Close()
@@ -103,64 +187,120 @@ func (c *Client) FetchSources() {
 	body, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		log.Fatal("Error reading the response => ", err)
+		return nil, fmt.Errorf("%w: error reading the response => %v", ErrUpstreamUnavailable, err)
 	}
 
 	// this is for Printing Response Body and Ret Code
-	fmt.Println(string(body))
-	fmt.Printf("Response Status: %s\n", resp.Status)
+	//fmt.Println(string(body))
+	//fmt.Printf("Response Status: %s\n", resp.Status)
 
 	// checking ret code, http.StatusOk is a const from http pkg
 	if resp.StatusCode != http.StatusOK {
-		log.Fatal("Error Fetching News. Status Code: ", resp.StatusCode)
+		return nil, parseAPIError(resp, body)
 	}
+
+	return body, nil
 }
 
-// FetchNews func with 2 parameters (query and page) and return the Result struct
-//Notice that the search query is URL encoded through the QueryEscape() method.
-func (c *Client) FetchNews(searchType, query, page, param string) (*Results, error) {
+// retryWait tells if the failed attempt is worth retrying and how long to wait before.
+// Auth and params errors are never retried, rate limits only when the server tells when (Retry-After).
+func (c *Client) retryWait(err error, attempt int) (time.Duration, bool) {
 
-	var endpoint = ""
+	if attempt >= c.Retries {
+		return 0, false
+	}
+
+	var apiErr *APIError
+	errors.As(err, &apiErr)
 
 	switch {
-	case searchType == "ByCountry":
-		// here param is the 'country'
-		endpoint = fmt.Sprintf("https://newsapi.org/v2/top-headlines?q=%s&country=%s&apiKey=%s&pageSize=%d&page=%s", url.QueryEscape(query), countries[param], c.key, c.PageSize, page)
-	case searchType == "Global":
-		// here param is the 'domains'
-		endpoint = fmt.Sprintf("https://newsapi.org/v2/everything?q=%s&domains=%s&pageSize=%d&page=%s&apiKey=%s&sortBy=publishedAt&language=en", url.QueryEscape(query), param, c.PageSize, page, c.key)
+	case errors.Is(err, ErrUpstreamUnavailable):
+	case errors.Is(err, ErrRateLimited) && apiErr != nil && apiErr.Code != "apiKeyExhausted" && apiErr.RetryAfter > 0:
+		if apiErr.RetryAfter > c.MaxWait {
+			return 0, false
+		}
+		return apiErr.RetryAfter, true
 	default:
-		log.Fatal("Search type Must be specified. Allowed values: 'Global', 'ByCountry'")
+		return 0, false
 	}
 
-	resp, err := c.http.Get(endpoint)
+	// exponential backoff with 'equal jitter': half fixed, half random
+	backoff := c.Backoff << uint(attempt)
+	if backoff > c.MaxWait || backoff <= 0 {
+		backoff = c.MaxWait
+	}
+	wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
 
-	// Handle error from the response
-	if err != nil {
-		log.Fatal("Error getting a response => ", err)
-		return nil, err
+	if apiErr != nil && apiErr.RetryAfter > wait {
+		if apiErr.RetryAfter > c.MaxWait {
+			return 0, false
+		}
+		wait = apiErr.RetryAfter
 	}
 
-	defer resp.Body.Close()
+	return wait, true
+}
 
-	// Response body is converted to a byte slice, if no errors
-	body, err := ioutil.ReadAll(resp.Body)
+// FetchSources retrieves the sources available on NewsAPI
+func (c *Client) FetchSources() (*Sources, error) {
+
+	var endpoint = ""
+
+	//https://newsapi.org/v2/top-headlines/sources
+	endpoint = c.baseURL + "/v2/top-headlines/sources"
 
+	// this is an API call as well
+	body, err := c.get(endpoint, "")
 	if err != nil {
-		log.Fatal("Error reading the response => ", err)
 		return nil, err
 	}
 
-	// this is for Printing Response Body and Ret Code
-	//fmt.Println(string(body))
-	//fmt.Printf("Response Status: %s\n", resp.Status)
+	res := &Sources{}
+	return res, json.Unmarshal(body, res)
+}
 
-	// checking ret code, http.StatusOk is a const from http pkg
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf(string(body))
+// FetchArticles implements the Provider interface.
+// When a Country is set the 'top-headlines' endpoint is used, otherwise 'everything' on the given Domains.
+// Notice that the search query is URL encoded through the QueryEscape() method.
+func (c *Client) FetchArticles(q Query) (*Results, error) {
+
+	var endpoint = ""
+
+	page := q.Page
+	if page < 1 {
+		page = 1
+	}
+
+	pageSize := c.PageSize
+	if q.PageSize > 0 && q.PageSize < pageSize {
+		pageSize = q.PageSize
+	}
+
+	switch {
+	case q.Country != "":
+		endpoint = fmt.Sprintf("%s/v2/top-headlines?q=%s&country=%s&pageSize=%d&page=%d", c.baseURL, url.QueryEscape(q.Keywords), q.Country, pageSize, page)
+		if q.Category != "" {
+			endpoint += "&category=" + url.QueryEscape(q.Category)
+		}
+	default:
+		language := q.Language
+		if language == "" {
+			language = "en"
+		}
+		endpoint = fmt.Sprintf("%s/v2/everything?q=%s&domains=%s&pageSize=%d&page=%d&sortBy=publishedAt&language=%s", c.baseURL, url.QueryEscape(q.Keywords), strings.Join(q.Domains, ","), pageSize, page, language)
+		// 'top-headlines' has no date filter
+		if !q.Since.IsZero() {
+			endpoint += "&from=" + url.QueryEscape(q.Since.UTC().Format(time.RFC3339))
+		}
+	}
+
+	body, err := c.get(endpoint, q.Priority)
+	if err != nil {
+		return nil, err
 	}
 
-	res := &Results{}
+	// 'everything' is sorted by publishedAt, 'top-headlines' by the ranking of NEWS API
+	res := &Results{NewestFirst: q.Country == ""}
 	// func Unmarshal(data []byte, v interface{}) error
 	// Unmarshal parses the JSON-encoded data and stores the result in the value pointed to by v.
 	// If v is nil or not a pointer, Unmarshal returns an InvalidUnmarshalError.
@@ -0,0 +1,301 @@
+package news
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// answer is a response of the test server
+type answer struct {
+	status     int
+	retryAfter string
+	body       string
+}
+
+const okBody = `{"status": "ok", "totalResults": 1, "articles": [{"title": "Covid: in Italia 10.172 nuovi casi", "url": "https://www.ansa.it/1.html"}]}`
+
+func errorBody(code string) string {
+	return fmt.Sprintf(`{"status": "error", "code": "%s", "message": "%s message"}`, code, code)
+}
+
+// newTestClient returns a Client of a server giving the answers in order (the last one again when done),
+// the number of calls and the waits between the retries
+func newTestClient(t *testing.T, answers ...answer) (*Client, *int, *[]time.Duration) {
+
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		if r.Header.Get("X-Api-Key") != "key" || strings.Contains(r.URL.RawQuery, "key") {
+			t.Errorf("API key not in the header only: %s %v", r.URL, r.Header)
+		}
+
+		a := answers[len(answers)-1]
+		if calls < len(answers) {
+			a = answers[calls]
+		}
+		calls++
+
+		if a.retryAfter != "" {
+			w.Header().Set("Retry-After", a.retryAfter)
+		}
+		w.WriteHeader(a.status)
+		fmt.Fprint(w, a.body)
+	}))
+	t.Cleanup(server.Close)
+
+	var waits []time.Duration
+	c := NewClient(server.Client(), "key", 100, WithBaseURL(server.URL))
+	c.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	return c, &calls, &waits
+}
+
+func TestRetryAfter(t *testing.T) {
+
+	c, calls, waits := newTestClient(t,
+		answer{http.StatusTooManyRequests, "7", errorBody("rateLimited")},
+		answer{http.StatusOK, "", okBody},
+	)
+
+	res, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Articles) != 1 {
+		t.Errorf("articles = %d, want 1", len(res.Articles))
+	}
+	if *calls != 2 || len(*waits) != 1 || (*waits)[0] != 7*time.Second {
+		t.Errorf("calls = %d, waits = %v, want 2 calls waiting 7s", *calls, *waits)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+
+	c, calls, waits := newTestClient(t, answer{http.StatusTooManyRequests, "3600", errorBody("rateLimited")})
+
+	_, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}})
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("err = %v, want ErrRateLimited", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter != time.Hour {
+		t.Errorf("err = %#v, want an APIError with RetryAfter 1h", err)
+	}
+	if *calls != 1 || len(*waits) != 0 {
+		t.Errorf("calls = %d, waits = %v, want no retry over MaxWait", *calls, *waits)
+	}
+}
+
+func TestNoRetryWithoutRetryAfter(t *testing.T) {
+
+	for _, a := range []answer{
+		// rate limited without telling when to retry
+		{http.StatusTooManyRequests, "", errorBody("rateLimited")},
+		// the calls of the plan are over, no point in waiting
+		{http.StatusTooManyRequests, "60", errorBody("apiKeyExhausted")},
+	} {
+		c, calls, _ := newTestClient(t, a)
+
+		_, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}})
+		if !errors.Is(err, ErrRateLimited) || *calls != 1 {
+			t.Errorf("%+v: err = %v, calls = %d, want ErrRateLimited and no retry", a, err, *calls)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+
+	c, calls, waits := newTestClient(t,
+		answer{http.StatusInternalServerError, "", errorBody("unexpectedError")},
+		answer{http.StatusBadGateway, "", "<html>bad gateway</html>"},
+		answer{http.StatusServiceUnavailable, "", ""},
+		answer{http.StatusOK, "", okBody},
+	)
+
+	if _, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if *calls != 4 || len(*waits) != 3 {
+		t.Fatalf("calls = %d, waits = %v, want 4 calls and 3 waits", *calls, *waits)
+	}
+
+	// equal jitter: between half and all of the backoff, doubled at every retry
+	for i, wait := range *waits {
+		backoff := c.Backoff << uint(i)
+		if wait < backoff/2 || wait > backoff {
+			t.Errorf("wait #%d = %v, want between %v and %v", i+1, wait, backoff/2, backoff)
+		}
+	}
+}
+
+func TestBackoffGivesUp(t *testing.T) {
+
+	c, calls, waits := newTestClient(t, answer{http.StatusServiceUnavailable, "", ""})
+	c.MaxWait = 2 * time.Second
+
+	_, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}})
+	if !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("err = %v, want ErrUpstreamUnavailable", err)
+	}
+	if *calls != c.Retries+1 {
+		t.Errorf("calls = %d, want %d", *calls, c.Retries+1)
+	}
+	for i, wait := range *waits {
+		if wait > c.MaxWait {
+			t.Errorf("wait #%d = %v, over MaxWait %v", i+1, wait, c.MaxWait)
+		}
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+
+	for _, a := range []answer{
+		{http.StatusUnauthorized, "", errorBody("apiKeyInvalid")},
+		{http.StatusUnauthorized, "", ""},
+		{http.StatusBadRequest, "", errorBody("sourcesTooMany")},
+		// NEWS API answers 426 Upgrade Required to the calls the plan doesn't allow
+		{http.StatusUpgradeRequired, "", `{"status": "error", "code": "parameterInvalid", "message": "upgrade required"}`},
+		{http.StatusUpgradeRequired, "", ""},
+	} {
+		c, calls, waits := newTestClient(t, a, answer{http.StatusOK, "", okBody})
+
+		if _, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}}); err == nil {
+			t.Errorf("%+v: no error", a)
+		}
+		if *calls != 1 || len(*waits) != 0 {
+			t.Errorf("%+v: calls = %d, waits = %v, want no retry", a, *calls, *waits)
+		}
+	}
+}
+
+func TestErrorMapping(t *testing.T) {
+
+	tests := []struct {
+		status int
+		body   string
+		want   error
+	}{
+		{http.StatusUnauthorized, errorBody("apiKeyInvalid"), ErrAPIKeyInvalid},
+		{http.StatusUnauthorized, errorBody("apiKeyDisabled"), ErrAPIKeyInvalid},
+		{http.StatusUnauthorized, errorBody("apiKeyMissing"), ErrAPIKeyInvalid},
+		{http.StatusTooManyRequests, errorBody("rateLimited"), ErrRateLimited},
+		{http.StatusTooManyRequests, errorBody("apiKeyExhausted"), ErrRateLimited},
+		{http.StatusBadRequest, errorBody("parameterInvalid"), ErrBadRequest},
+		{http.StatusBadRequest, errorBody("parametersMissing"), ErrBadRequest},
+		{http.StatusBadRequest, errorBody("sourcesTooMany"), ErrBadRequest},
+		{http.StatusBadRequest, errorBody("sourceDoesNotExist"), ErrBadRequest},
+		{http.StatusInternalServerError, errorBody("unexpectedError"), ErrUpstreamUnavailable},
+		// unknown codes and bodies of proxies go by the HTTP status
+		{http.StatusBadRequest, errorBody("somethingNew"), ErrBadRequest},
+		{http.StatusUnauthorized, "Unauthorized", ErrAPIKeyInvalid},
+		{http.StatusTooManyRequests, "<html>slow down</html>", ErrRateLimited},
+		{http.StatusBadGateway, "<html>bad gateway</html>", ErrUpstreamUnavailable},
+	}
+
+	for _, tt := range tests {
+
+		c, _, _ := newTestClient(t, answer{tt.status, "", tt.body})
+		c.Retries = 0
+
+		_, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%d %s: err = %v, want %v", tt.status, tt.body, err, tt.want)
+		}
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.status {
+			t.Errorf("%d %s: err = %#v, want an APIError of the status", tt.status, tt.body, err)
+		}
+	}
+
+	// the message of the body is kept for the logs
+	c, _, _ := newTestClient(t, answer{http.StatusBadRequest, "", errorBody("sourcesTooMany")})
+	_, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}})
+	if err == nil || err.Error() != "status 400, sourcesTooMany: sourcesTooMany message" {
+		t.Errorf("err = %v", err)
+	}
+
+	// unknown statuses are none of the errors of the package
+	c, _, _ = newTestClient(t, answer{http.StatusUpgradeRequired, "", ""})
+	_, err = c.FetchArticles(Query{Domains: []string{"ansa.it"}})
+	for _, kind := range []error{ErrAPIKeyInvalid, ErrRateLimited, ErrUpstreamUnavailable, ErrBadRequest} {
+		if errors.Is(err, kind) {
+			t.Errorf("426: err = %v is %v", err, kind)
+		}
+	}
+}
+
+func TestNetworkError(t *testing.T) {
+
+	c, _, waits := newTestClient(t, answer{http.StatusOK, "", okBody})
+	c.baseURL = "http://127.0.0.1:1"
+
+	_, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}})
+	if !errors.Is(err, ErrUpstreamUnavailable) {
+		t.Errorf("err = %v, want ErrUpstreamUnavailable", err)
+	}
+	if len(*waits) != c.Retries {
+		t.Errorf("waits = %v, want %d retries", *waits, c.Retries)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+
+	now := time.Date(2021, 11, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{"Mon, 15 Nov 2021 12:00:30 GMT", 30 * time.Second},
+		// a date gone is no wait
+		{"Mon, 15 Nov 2021 11:00:00 GMT", 0},
+	}
+
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// countingBudget allows the calls up to its tokens
+type countingBudget struct {
+	tokens int
+	taken  int
+}
+
+func (b *countingBudget) Take(priority string) error {
+	if b.taken >= b.tokens {
+		return errors.New("no calls left")
+	}
+	b.taken++
+	return nil
+}
+
+func TestRetriesTakeTheBudget(t *testing.T) {
+
+	c, calls, _ := newTestClient(t, answer{http.StatusServiceUnavailable, "", ""})
+	budget := &countingBudget{tokens: 2}
+	c.Budget = budget
+
+	if _, err := c.FetchArticles(Query{Domains: []string{"ansa.it"}}); err == nil {
+		t.Fatal("no error")
+	}
+
+	// every attempt is a call of the quota
+	if *calls != 2 || budget.taken != 2 {
+		t.Errorf("calls = %d, taken = %d, want 2 of each", *calls, budget.taken)
+	}
+}
@@ -0,0 +1,244 @@
+// Package newsapitest is a fake NEWS API server serving the canned fixtures in the 'fixtures' dir,
+// for the integration tests (NewServer) and the local docker-compose (cmd/fakenewsapi).
+//
+// It implements the bits of the API the collector uses:
+//   - /v2/top-headlines: articles of fixtures/top-headlines-<country>.json
+//   - /v2/everything: articles of fixtures/everything.json whose URL is on one of the 'domains'
+//   - /v2/top-headlines/sources: fixtures/sources.json
+//
+// with 'q', 'from', 'page' and 'pageSize' params, and the NEWS API error bodies for a missing API key,
+// missing params and too many domains.
+package newsapitest
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed fixtures/*.json
+var fixtures embed.FS
+
+// MaxDomains is the max number of domains of an 'everything' call, as the real API
+const MaxDomains = 20
+
+// article is the subset of the NEWS API article the server needs to filter on,
+// the rest of the fixture is returned as it is
+type article struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	PublishedAt time.Time `json:"publishedAt"`
+	raw         json.RawMessage
+}
+
+// NewServer starts a fake NEWS API server, to be closed by the caller.
+// Point the client to it with news.WithBaseURL(server.URL).
+func NewServer() *httptest.Server {
+	return httptest.NewServer(Handler())
+}
+
+// Handler returns the handler of the fake NEWS API
+func Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/top-headlines", withKey(topHeadlines))
+	mux.HandleFunc("/v2/everything", withKey(everything))
+	mux.HandleFunc("/v2/top-headlines/sources", withKey(sources))
+
+	return mux
+}
+
+// withKey refuses the calls without the API key, in the header or in the query as the real API
+func withKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") == "" && r.URL.Query().Get("apiKey") == "" {
+			writeError(w, http.StatusUnauthorized, "apiKeyMissing", "Your API key is missing. Append this to the URL with the apiKey param, or use the x-api-key HTTP header.")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func topHeadlines(w http.ResponseWriter, r *http.Request) {
+
+	country := r.URL.Query().Get("country")
+	if country == "" && r.URL.Query().Get("q") == "" {
+		writeError(w, http.StatusBadRequest, "parametersMissing", "Required parameters are missing. Please set any of the following parameters and try again: sources, q, language, country, category.")
+		return
+	}
+
+	// fixtures exist for a few countries only, the others have no news
+	articles, err := loadArticles(fmt.Sprintf("fixtures/top-headlines-%s.json", country))
+	if err != nil {
+		articles = nil
+	}
+
+	writeArticles(w, r, filter(articles, r.URL.Query(), nil))
+}
+
+func everything(w http.ResponseWriter, r *http.Request) {
+
+	var domains []string
+	if value := r.URL.Query().Get("domains"); value != "" {
+		domains = strings.Split(value, ",")
+	}
+
+	if len(domains) == 0 && r.URL.Query().Get("q") == "" {
+		writeError(w, http.StatusBadRequest, "parametersMissing", "Required parameters are missing, the scope of your search is too broad. Please set any of the following required parameters and try again: q, qInTitle, sources, domains.")
+		return
+	}
+
+	if len(domains) > MaxDomains {
+		writeError(w, http.StatusBadRequest, "sourcesTooMany", fmt.Sprintf("You have requested too many domains in a single request. You may request a maximum of %d.", MaxDomains))
+		return
+	}
+
+	articles, err := loadArticles("fixtures/everything.json")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "unexpectedError", err.Error())
+		return
+	}
+
+	writeArticles(w, r, filter(articles, r.URL.Query(), domains))
+}
+
+func sources(w http.ResponseWriter, r *http.Request) {
+
+	body, err := fixtures.ReadFile("fixtures/sources.json")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "unexpectedError", err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// loadArticles reads the articles of the fixture, keeping each one as it is in the file
+func loadArticles(name string) ([]article, error) {
+
+	body, err := fixtures.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixture struct {
+		Articles []json.RawMessage `json:"articles"`
+	}
+	if err := json.Unmarshal(body, &fixture); err != nil {
+		return nil, fmt.Errorf("error parsing %s => %v", name, err)
+	}
+
+	articles := make([]article, 0, len(fixture.Articles))
+	for _, raw := range fixture.Articles {
+		a := article{raw: raw}
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return nil, fmt.Errorf("error parsing %s => %v", name, err)
+		}
+		articles = append(articles, a)
+	}
+
+	return articles, nil
+}
+
+// filter keeps the articles matching 'q', 'from' and the domains (if any), newest first
+func filter(articles []article, params url.Values, domains []string) []article {
+
+	q := strings.ToLower(params.Get("q"))
+	from, _ := time.Parse(time.RFC3339, params.Get("from"))
+
+	var kept []article
+	for _, a := range articles {
+		if q != "" && !strings.Contains(strings.ToLower(a.Title+" "+a.Description), q) {
+			continue
+		}
+		if a.PublishedAt.Before(from) {
+			continue
+		}
+		if len(domains) > 0 && !onDomains(a.URL, domains) {
+			continue
+		}
+		kept = append(kept, a)
+	}
+
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].PublishedAt.After(kept[j].PublishedAt)
+	})
+
+	return kept
+}
+
+// onDomains tells if the URL host is one of the domains or a subdomain of them
+func onDomains(articleURL string, domains []string) bool {
+
+	u, err := url.Parse(articleURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeArticles writes the page of the articles asked by 'page' and 'pageSize' (default 100)
+func writeArticles(w http.ResponseWriter, r *http.Request, articles []article) {
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if err != nil || pageSize < 1 || pageSize > 100 {
+		pageSize = 100
+	}
+
+	start := (page - 1) * pageSize
+	if start > len(articles) {
+		start = len(articles)
+	}
+	end := start + pageSize
+	if end > len(articles) {
+		end = len(articles)
+	}
+
+	raws := make([]json.RawMessage, 0, end-start)
+	for _, a := range articles[start:end] {
+		raws = append(raws, a.raw)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":       "ok",
+		"totalResults": len(articles),
+		"articles":     raws,
+	})
+}
+
+// writeError writes the error body of the NEWS API
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]string{
+		"status":  "error",
+		"code":    code,
+		"message": message,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,78 @@
+package news
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Query describes what a Provider should fetch for a single feed
+type Query struct {
+	Keywords string    // free text query, can be empty for everything
+	Country  string    // ISO 3166-1 alpha-2 code of the country (e.g. 'it')
+	Category string    // one of Categories, for the top headlines of the Country
+	Domains  []string  // restricts the search to the given domains (e.g. 'ansa.it')
+	FeedURL  string    // RSS/Atom feed of the domain, used by the 'rss' provider
+	Language string    // ISO 639-1 code of the articles (e.g. 'en')
+	Page     int       // page of results to fetch, starting from 1
+	PageSize int       // results per page, 0 for the provider default
+	Priority string    // priority of the job when the API quota is running out (see Budget)
+	Since    time.Time // only articles published from this time, zero for no limit. Providers not supporting it return older articles too
+}
+
+// Categories are the categories of the top headlines
+var Categories = []string{"business", "entertainment", "general", "health", "science", "sports", "technology"}
+
+// Budget is consulted before every API call of a rate limited provider (see the quota package).
+// Take returns an error if the call is not allowed.
+type Budget interface {
+	Take(priority string) error
+}
+
+// Provider is implemented by every news source the collector can pull articles from
+// (NewsAPI, GNews, RSS feeds, etc.), so the fetch loops don't need to know about URLs and params.
+type Provider interface {
+	// Name is the identifier used in the configuration to select the provider (e.g. 'newsapi')
+	Name() string
+	// FetchArticles returns the articles matching the Query
+	FetchArticles(q Query) (*Results, error)
+	// FetchSources returns the list of sources known by the provider
+	FetchSources() (*Sources, error)
+}
+
+// IndependentFeeds is implemented by the providers fetching every feed from the server of its site (e.g. RSS):
+// with no API key or rate limit shared by the feeds, a feed refusing the calls (401, 429) doesn't stop the other ones
+type IndependentFeeds interface {
+	IndependentFeeds() bool
+}
+
+// Registry keeps the available providers by name
+type Registry map[string]Provider
+
+// NewRegistry creates a Registry with the given providers
+func NewRegistry(providers ...Provider) Registry {
+	r := Registry{}
+	for _, p := range providers {
+		r[p.Name()] = p
+	}
+	return r
+}
+
+// Get returns the provider registered with the given name
+func (r Registry) Get(name string) (Provider, error) {
+	p, ok := r[name]
+	if !ok {
+		return nil, fmt.Errorf("news provider '%s' is not registered. Available providers: %v", name, r.Names())
+	}
+	return p, nil
+}
+
+// Names returns the sorted names of the registered providers
+func (r Registry) Names() []string {
+	names := make([]string, 0, len(r))
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,299 @@
+package news
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/* RSS 2.0 structs */
+type rssFeed struct {
+	Channel struct {
+		Title string    `xml:"title"`
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Author      string `xml:"author"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	PubDate     string `xml:"pubDate"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Enclosure   struct {
+		URL  string `xml:"url,attr"`
+		Type string `xml:"type,attr"`
+	} `xml:"enclosure"`
+	Media []struct {
+		URL    string `xml:"url,attr"`
+		Medium string `xml:"medium,attr"`
+	} `xml:"http://search.yahoo.com/mrss/ content"`
+}
+
+/* Atom structs */
+type atomFeed struct {
+	Title   string      `xml:"title"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Summary   string     `xml:"summary"`
+	Content   string     `xml:"content"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Authors   []struct {
+		Name string `xml:"name"`
+	} `xml:"author"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// date layouts found in the wild for RSS 'pubDate' and Atom 'published'/'updated'
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+}
+
+// RSSClient is the Provider for RSS and Atom feeds
+type RSSClient struct {
+	http *http.Client
+}
+
+// NewRSSClient creates the RSS/Atom provider, fetching the feeds with httpClient
+func NewRSSClient(httpClient *http.Client) *RSSClient {
+	return &RSSClient{httpClient}
+}
+
+// Name returns the provider name used in the configuration
+func (c *RSSClient) Name() string {
+	return "rss"
+}
+
+// IndependentFeeds tells that each feed is on the server of its site
+func (c *RSSClient) IndependentFeeds() bool {
+	return true
+}
+
+// FetchSources is not supported by feeds, there is no catalogue to pull
+func (c *RSSClient) FetchSources() (*Sources, error) {
+	return &Sources{Status: "ok"}, nil
+}
+
+// FetchArticles downloads and parses the feed at Query.FeedURL.
+// Feeds are not paginated, so only the first page returns articles.
+func (c *RSSClient) FetchArticles(q Query) (*Results, error) {
+
+	if q.FeedURL == "" {
+		return nil, fmt.Errorf("feed URL is not set for domains %v", q.Domains)
+	}
+
+	if q.Page > 1 {
+		return &Results{Status: "ok"}, nil
+	}
+
+	// the feed URL comes from the domains table, not to be read from anywhere but the web
+	if u, err := url.Parse(q.FeedURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("feed URL '%s' of domains %v isn't http(s)", q.FeedURL, q.Domains)
+	}
+
+	resp, err := c.http.Get(q.FeedURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error fetching feed '%s' => %v", ErrUpstreamUnavailable, q.FeedURL, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching feed '%s' => %w", q.FeedURL, statusError(resp.StatusCode, nil))
+	}
+
+	articles, err := ParseFeed(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing feed '%s' => %v", q.FeedURL, err)
+	}
+
+	// feeds without a title are attributed to the domain
+	for i := range articles {
+		if articles[i].Source.Name == "" && len(q.Domains) > 0 {
+			articles[i].Source.Name = q.Domains[0]
+		}
+	}
+
+	// keywords are matched locally as feeds have no search
+	if q.Keywords != "" {
+		articles = filterArticles(articles, q.Keywords)
+	}
+
+	return &Results{Status: "ok", TotalResults: len(articles), Articles: articles}, nil
+}
+
+// ParseFeed reads an RSS 2.0 or Atom document and maps its items onto Articles
+func ParseFeed(r io.Reader) ([]Article, error) {
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// look at the root element to pick the format
+	root, err := rootElement(body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch root {
+	case "rss":
+		feed := &rssFeed{}
+		if err := xml.Unmarshal(body, feed); err != nil {
+			return nil, err
+		}
+		return feed.articles(), nil
+	case "feed":
+		feed := &atomFeed{}
+		if err := xml.Unmarshal(body, feed); err != nil {
+			return nil, err
+		}
+		return feed.articles(), nil
+	default:
+		return nil, fmt.Errorf("unsupported feed format '%s'. Allowed values: 'rss', 'feed' (Atom)", root)
+	}
+}
+
+func rootElement(body []byte) (string, error) {
+
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+func (f *rssFeed) articles() []Article {
+
+	articles := make([]Article, 0, len(f.Channel.Items))
+
+	for _, item := range f.Channel.Items {
+		var a Article
+		a.Source.Name = strings.TrimSpace(f.Channel.Title)
+		a.Title = strings.TrimSpace(item.Title)
+		a.URL = strings.TrimSpace(item.Link)
+		a.Description = strings.TrimSpace(item.Description)
+		a.Content = strings.TrimSpace(item.Content)
+		a.PublishedAt = publishedOrNow(parseFeedDate(item.PubDate))
+
+		a.Author = strings.TrimSpace(item.Creator)
+		if a.Author == "" {
+			a.Author = strings.TrimSpace(item.Author)
+		}
+
+		if strings.HasPrefix(item.Enclosure.Type, "image/") {
+			a.URLToImage = item.Enclosure.URL
+		}
+		for _, m := range item.Media {
+			if a.URLToImage == "" && (m.Medium == "" || m.Medium == "image") {
+				a.URLToImage = m.URL
+			}
+		}
+
+		articles = append(articles, a)
+	}
+
+	return articles
+}
+
+func (f *atomFeed) articles() []Article {
+
+	articles := make([]Article, 0, len(f.Entries))
+
+	for _, entry := range f.Entries {
+		var a Article
+		a.Source.Name = strings.TrimSpace(f.Title)
+		a.Title = strings.TrimSpace(entry.Title)
+		a.Description = strings.TrimSpace(entry.Summary)
+		a.Content = strings.TrimSpace(entry.Content)
+
+		if len(entry.Authors) > 0 {
+			a.Author = strings.TrimSpace(entry.Authors[0].Name)
+		}
+
+		a.PublishedAt = parseFeedDate(entry.Published)
+		if a.PublishedAt.IsZero() {
+			a.PublishedAt = parseFeedDate(entry.Updated)
+		}
+		a.PublishedAt = publishedOrNow(a.PublishedAt)
+
+		for _, l := range entry.Links {
+			switch {
+			case (l.Rel == "" || l.Rel == "alternate") && a.URL == "":
+				a.URL = l.Href
+			case l.Rel == "enclosure" && strings.HasPrefix(l.Type, "image/"):
+				a.URLToImage = l.Href
+			}
+		}
+
+		articles = append(articles, a)
+	}
+
+	return articles
+}
+
+// parseFeedDate tries the known layouts, returning the zero Time if none matches
+func parseFeedDate(value string) time.Time {
+
+	value = strings.TrimSpace(value)
+	for _, layout := range feedDateLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// items without a date are considered published when fetched
+func publishedOrNow(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now()
+	}
+	return t
+}
+
+// filterArticles keeps the articles having the keywords in the title or description
+func filterArticles(articles []Article, keywords string) []Article {
+
+	keywords = strings.ToLower(keywords)
+	filtered := make([]Article, 0, len(articles))
+
+	for _, a := range articles {
+		if strings.Contains(strings.ToLower(a.Title), keywords) || strings.Contains(strings.ToLower(a.Description), keywords) {
+			filtered = append(filtered, a)
+		}
+	}
+
+	return filtered
+}
@@ -0,0 +1,145 @@
+package news
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeedRSS(t *testing.T) {
+
+	f, err := os.Open("testdata/ansa.rss.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	articles, err := ParseFeed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(articles) != 2 {
+		t.Fatalf("got %d articles, want 2", len(articles))
+	}
+
+	a := articles[0]
+	if a.Source.Name != "ANSA.it" || a.Title != "Covid: in Italia 10.172 nuovi casi e 72 vittime" {
+		t.Errorf("source/title = %q/%q", a.Source.Name, a.Title)
+	}
+	if a.URL != "https://www.ansa.it/sito/notizie/cronaca/2021/11/15/covid-nuovi-casi_a1b2c3.html" {
+		t.Errorf("url = %q", a.URL)
+	}
+	// dc:creator wins over author
+	if a.Author != "Redazione ANSA" {
+		t.Errorf("author = %q", a.Author)
+	}
+	if want := time.Date(2021, 11, 15, 16, 30, 0, 0, time.UTC); !a.PublishedAt.Equal(want) {
+		t.Errorf("publishedAt = %v, want %v", a.PublishedAt, want)
+	}
+	if a.URLToImage != "https://www.ansa.it/webimages/covid.jpg" {
+		t.Errorf("image from the enclosure = %q", a.URLToImage)
+	}
+
+	b := articles[1]
+	if b.Author != "redazione@ansa.it (Mario Rossi)" {
+		t.Errorf("author = %q", b.Author)
+	}
+	if b.URLToImage != "https://www.ansa.it/webimages/cop26.jpg" {
+		t.Errorf("image from media:content = %q", b.URLToImage)
+	}
+}
+
+func TestParseFeedAtom(t *testing.T) {
+
+	f, err := os.Open("testdata/corriere.atom.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	articles, err := ParseFeed(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(articles) != 2 {
+		t.Fatalf("got %d articles, want 2", len(articles))
+	}
+
+	a := articles[0]
+	if a.Source.Name != "Corriere della Sera" || a.Author != "Enrico Marro" {
+		t.Errorf("source/author = %q/%q", a.Source.Name, a.Author)
+	}
+	if a.URL != "https://www.corriere.it/economia/21_novembre_15/manovra-decreto-fiscale.shtml" {
+		t.Errorf("url of the alternate link = %q", a.URL)
+	}
+	if a.URLToImage != "https://images2.corriereobjects.it/manovra.jpg" {
+		t.Errorf("image of the enclosure link = %q", a.URLToImage)
+	}
+	if want := time.Date(2021, 11, 15, 15, 45, 0, 0, time.UTC); !a.PublishedAt.Equal(want) {
+		t.Errorf("publishedAt = %v, want %v", a.PublishedAt, want)
+	}
+
+	// no 'published', the date is the 'updated'
+	if want := time.Date(2021, 11, 15, 11, 0, 0, 0, time.UTC); !articles[1].PublishedAt.Equal(want) {
+		t.Errorf("publishedAt = %v, want %v", articles[1].PublishedAt, want)
+	}
+}
+
+func TestParseFeedUnsupported(t *testing.T) {
+
+	if _, err := ParseFeed(strings.NewReader("<html><body>not a feed</body></html>")); err == nil {
+		t.Error("HTML parsed as a feed")
+	}
+	if _, err := ParseFeed(strings.NewReader("")); err == nil {
+		t.Error("empty document parsed")
+	}
+}
+
+func TestRSSFetchArticles(t *testing.T) {
+
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata")))
+	defer server.Close()
+
+	c := NewRSSClient(server.Client())
+
+	res, err := c.FetchArticles(Query{FeedURL: server.URL + "/ansa.rss.xml", Domains: []string{"ansa.it"}, Page: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.TotalResults != 2 || len(res.Articles) != 2 {
+		t.Fatalf("got %d/%d articles, want 2", res.TotalResults, len(res.Articles))
+	}
+
+	// keywords are matched locally
+	res, err = c.FetchArticles(Query{FeedURL: server.URL + "/ansa.rss.xml", Keywords: "glasgow", Page: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Articles) != 1 || res.Articles[0].Title != "Cop26: accordo raggiunto a Glasgow" {
+		t.Errorf("filtered articles = %+v", res.Articles)
+	}
+
+	// feeds have one page only
+	res, err = c.FetchArticles(Query{FeedURL: server.URL + "/ansa.rss.xml", Page: 2})
+	if err != nil || len(res.Articles) != 0 {
+		t.Errorf("page 2 = %v, %v", res, err)
+	}
+
+	if _, err := c.FetchArticles(Query{FeedURL: server.URL + "/missing.xml", Page: 1}); err == nil {
+		t.Error("no error for a 404 feed")
+	}
+}
+
+func TestRSSFetchArticlesLocalFile(t *testing.T) {
+
+	c := NewRSSClient(http.DefaultClient)
+
+	for _, feedURL := range []string{"file:///etc/passwd", "FILE:///etc/passwd", "/etc/passwd", "ftp://example.com/feed.xml"} {
+		if _, err := c.FetchArticles(Query{FeedURL: feedURL, Page: 1}); err == nil {
+			t.Errorf("feed URL %q fetched", feedURL)
+		}
+	}
+}
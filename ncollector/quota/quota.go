@@ -0,0 +1,98 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// ErrExhausted is returned when there's no budget left for a call of the given priority
+var ErrExhausted = errors.New("API quota exhausted")
+
+// Priorities of the jobs, see Reserves
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// Reserves is the share of the capacity a call of each priority must leave in the bucket,
+// so the lower priority feeds are skipped first when the budget is running out
+var Reserves = map[string]float64{
+	PriorityHigh:   0,
+	PriorityNormal: 0.2,
+	PriorityLow:    0.4,
+}
+
+// Store keeps the token buckets, implemented by data.DBClient on the 'api_quotas' table.
+// UpdateQuota runs 'take' on the bucket of the provider, locked until the tokens it returns are stored
+// (untouched if it fails), creating the bucket full the first time.
+type Store interface {
+	UpdateQuota(provider string, capacity int, period time.Duration, take func(tokens float64, updatedAt time.Time) (float64, error)) error
+}
+
+// Manager is a token bucket of API calls, persisted in the 'api_quotas' table
+// so it's shared by all the jobs and survives restarts.
+// Capacity is the number of calls allowed in a Period (e.g. 50 calls every 12 hours),
+// the bucket refills continuously at Capacity/Period.
+type Manager struct {
+	db       Store
+	Provider string
+	Capacity int
+	Period   time.Duration
+	// clock of the refills, time.Now but in the tests
+	now func() time.Time
+}
+
+// NewManager creates the Manager of the provider quota
+func NewManager(db Store, provider string, capacity int, period time.Duration) *Manager {
+	return &Manager{db, provider, capacity, period, time.Now}
+}
+
+// Take consumes one call from the budget, returning ErrExhausted if the priority is not allowed to
+func (m *Manager) Take(priority string) error {
+
+	reserve, ok := Reserves[priority]
+	if !ok {
+		reserve = Reserves[PriorityNormal]
+	}
+
+	var left float64
+
+	err := m.db.UpdateQuota(m.Provider, m.Capacity, m.Period, func(tokens float64, updatedAt time.Time) (float64, error) {
+
+		left = Refill(tokens, m.Capacity, m.Period, m.now().Sub(updatedAt))
+
+		if left-1 < reserve*float64(m.Capacity) {
+			return left, ErrExhausted
+		}
+
+		left--
+		return left, nil
+	})
+
+	if errors.Is(err, ErrExhausted) {
+		log.Printf("Quota | %s | %.1f calls left, '%s' priority calls not allowed", m.Provider, left, priority)
+		return fmt.Errorf("%s: %w", m.Provider, ErrExhausted)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Quota | %s | %.1f calls left", m.Provider, left)
+	return nil
+}
+
+// Refill returns the tokens in the bucket after 'elapsed', never more than the capacity
+func Refill(tokens float64, capacity int, period time.Duration, elapsed time.Duration) float64 {
+
+	if elapsed < 0 {
+		elapsed = 0
+	}
+
+	refilled := tokens + float64(capacity)*elapsed.Seconds()/period.Seconds()
+
+	return math.Min(refilled, float64(capacity))
+}
@@ -0,0 +1,186 @@
+package quota
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// Take logs every call
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// memStore is the bucket of a provider in memory, updated at the time of the clock
+type memStore struct {
+	clock     *time.Time
+	created   bool
+	tokens    float64
+	updatedAt time.Time
+	// every update fails with err when set
+	err error
+}
+
+func (s *memStore) UpdateQuota(provider string, capacity int, period time.Duration, take func(tokens float64, updatedAt time.Time) (float64, error)) error {
+
+	if s.err != nil {
+		return s.err
+	}
+	if !s.created {
+		s.created, s.tokens, s.updatedAt = true, float64(capacity), *s.clock
+	}
+
+	tokens, err := take(s.tokens, s.updatedAt)
+	if err != nil {
+		return err
+	}
+
+	s.tokens, s.updatedAt = tokens, *s.clock
+	return nil
+}
+
+// newTestManager returns the Manager of 10 calls every 10 hours (one an hour) on a memStore, and its clock
+func newTestManager() (*Manager, *memStore, *time.Time) {
+
+	clock := time.Date(2021, 11, 20, 9, 0, 0, 0, time.UTC)
+	store := &memStore{clock: &clock}
+
+	m := NewManager(store, "newsapi", 10, 10*time.Hour)
+	m.now = func() time.Time { return clock }
+
+	return m, store, &clock
+}
+
+// takeAll takes the calls of the priority until the quota is exhausted, returning how many
+func takeAll(t *testing.T, m *Manager, priority string) int {
+	t.Helper()
+
+	for calls := 0; calls <= m.Capacity; calls++ {
+		err := m.Take(priority)
+		if errors.Is(err, ErrExhausted) {
+			if !strings.Contains(err.Error(), m.Provider) {
+				t.Errorf("error '%v' without the provider", err)
+			}
+			return calls
+		}
+		if err != nil {
+			t.Fatalf("%s call %d: %v", priority, calls+1, err)
+		}
+	}
+
+	t.Fatalf("%s: more calls than the capacity", priority)
+	return 0
+}
+
+func TestTakeReserves(t *testing.T) {
+
+	m, store, _ := newTestManager()
+
+	// the bucket starts full: the low priority calls leave 40% of it, the normal ones 20%, the high ones nothing
+	if calls := takeAll(t, m, PriorityLow); calls != 6 {
+		t.Errorf("low priority calls = %d, want 6", calls)
+	}
+	if calls := takeAll(t, m, PriorityNormal); calls != 2 {
+		t.Errorf("normal priority calls = %d, want 2", calls)
+	}
+	// an unknown priority is a normal one
+	if calls := takeAll(t, m, "urgent"); calls != 0 {
+		t.Errorf("unknown priority calls = %d, want 0", calls)
+	}
+	if calls := takeAll(t, m, PriorityHigh); calls != 2 {
+		t.Errorf("high priority calls = %d, want 2", calls)
+	}
+	if store.tokens != 0 {
+		t.Errorf("tokens left = %v, want 0", store.tokens)
+	}
+}
+
+func TestTakeRefill(t *testing.T) {
+
+	m, store, clock := newTestManager()
+
+	if calls := takeAll(t, m, PriorityHigh); calls != 10 {
+		t.Fatalf("calls of the full bucket = %d, want 10", calls)
+	}
+
+	// one call an hour
+	*clock = clock.Add(time.Hour)
+	if calls := takeAll(t, m, PriorityHigh); calls != 1 {
+		t.Errorf("calls after an hour = %d, want 1", calls)
+	}
+
+	// half a call is not a call, and the refused calls leave the bucket as it is
+	*clock = clock.Add(30 * time.Minute)
+	if calls := takeAll(t, m, PriorityHigh); calls != 0 {
+		t.Errorf("calls after half an hour = %d, want 0", calls)
+	}
+	*clock = clock.Add(30 * time.Minute)
+	if calls := takeAll(t, m, PriorityHigh); calls != 1 {
+		t.Errorf("calls after another half an hour = %d, want 1", calls)
+	}
+
+	// the low priority calls wait for the reserve: 40% of the capacity, plus the call
+	*clock = clock.Add(4 * time.Hour)
+	if calls := takeAll(t, m, PriorityLow); calls != 0 {
+		t.Errorf("low priority calls after 4 hours = %d, want 0", calls)
+	}
+	*clock = clock.Add(time.Hour)
+	if calls := takeAll(t, m, PriorityLow); calls != 1 {
+		t.Errorf("low priority calls after 5 hours = %d, want 1", calls)
+	}
+
+	// never more than the capacity
+	*clock = clock.Add(100 * time.Hour)
+	if calls := takeAll(t, m, PriorityHigh); calls != 10 {
+		t.Errorf("calls after 100 hours = %d, want 10", calls)
+	}
+
+	// a clock going back doesn't refill
+	*clock = clock.Add(-5 * time.Hour)
+	if calls := takeAll(t, m, PriorityHigh); calls != 0 || store.tokens != 0 {
+		t.Errorf("calls back in time = %d, tokens %v, want none", calls, store.tokens)
+	}
+}
+
+func TestTakeStoreError(t *testing.T) {
+
+	m, store, _ := newTestManager()
+	store.err = errors.New("pq: connection refused")
+
+	if err := m.Take(PriorityHigh); err != store.err {
+		t.Errorf("err = %v, want the one of the store", err)
+	}
+}
+
+func TestRefill(t *testing.T) {
+
+	tests := []struct {
+		tokens   float64
+		capacity int
+		period   time.Duration
+		elapsed  time.Duration
+		want     float64
+	}{
+		{0, 10, 10 * time.Hour, time.Hour, 1},
+		{5, 10, 10 * time.Hour, 0, 5},
+		{5, 10, 10 * time.Hour, -time.Hour, 5},
+		{9.5, 10, 10 * time.Hour, 2 * time.Hour, 10},
+		{12, 10, 10 * time.Hour, 0, 10},
+		{0, 50, 12 * time.Hour, 6 * time.Hour, 25},
+		{2.5, 50, 12 * time.Hour, 36 * time.Minute, 5},
+		{0, 100, 24 * time.Hour, time.Second, 100.0 / 86400},
+	}
+
+	for _, tt := range tests {
+		got := Refill(tt.tokens, tt.capacity, tt.period, tt.elapsed)
+		if math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("Refill(%v, %d, %v, %v) = %v, want %v", tt.tokens, tt.capacity, tt.period, tt.elapsed, got, tt.want)
+		}
+	}
+}
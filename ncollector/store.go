@@ -0,0 +1,33 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mesmerai/news-aggregator/ncollector/data"
+)
+
+// ** Store **
+// The collection reads and stores the data through store, implemented by data.DBClient on Postgres
+// (the tests run it on an in-memory one).
+
+type store interface {
+	// ** feeds **
+	GetFavourites() ([]data.Feed, error)
+	GetDomainsByName(name string) ([]data.Domain, error)
+	InsertDomain(domainName string) (domainID int, err error)
+	GetCursor(job, feed string) (time.Time, error)
+	SetCursor(job, feed string, publishedAt time.Time) error
+
+	// ** sources **
+	GetSourcesByName(name string) ([]data.Source, error)
+	GetSourceByNewsAPIID(newsapiID string) (int, error)
+	InsertSource(newsapiID, sourceName string) (sourceID int, err error)
+	UpsertSource(source data.CatalogueSource) (data.UpsertResult, error)
+
+	// ** articles **
+	UpsertArticle(sourceID, domainID int, author string, title string, description string, url string, urlToImage string, publishedAt time.Time, content, country, language, category string) (data.UpsertResult, int, error)
+	GetArticlesToCluster(ids []int) ([]data.ArticleToCluster, error)
+	GetRecentClusters(days int) ([]data.StoryCluster, error)
+	InsertCluster(articleID int, fingerprint uint64) (clusterID int, err error)
+	SetArticleCluster(articleID, clusterID int, fingerprint uint64) error
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+)
+
+// ** JSON API **
+// The same data of the web pages for the scripts, under /api/v1/ and described by openapi.yaml.
+// The answers are {"data": ..., "meta": ...} or, on error, {"error": {"status": 400, "code": "...", "message": "..."}}.
+
+//go:embed openapi.yaml
+var openAPIDoc []byte
+
+// apiPrefix is the prefix of the API paths, the version changes when the answers do
+const apiPrefix = "/api/v1"
+
+// codes of the API errors
+const (
+	apiInvalidParam     = "invalid_param"
+	apiInvalidCursor    = "invalid_cursor"
+	apiNotFound         = "not_found"
+	apiMethodNotAllowed = "method_not_allowed"
+	apiUnauthorized     = "unauthorized"
+	apiInternalError    = "internal_error"
+)
+
+// apiError is the error of the API answers
+type apiError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiArticle is the article of the API answers
+type apiArticle struct {
+	ID           int       `json:"id"`
+	Title        string    `json:"title"`
+	Description  string    `json:"description"`
+	Content      string    `json:"content"`
+	URL          string    `json:"url"`
+	URLToImage   string    `json:"url_to_image"`
+	Author       string    `json:"author"`
+	Source       string    `json:"source"`
+	Domain       string    `json:"domain"`
+	PublishedAt  time.Time `json:"published_at"`
+	Country      string    `json:"country"`
+	Language     string    `json:"language"`
+	Category     string    `json:"category"`
+	OtherSources int       `json:"other_sources"`
+	// relevance and snippet (HTML, the words searched in <mark>) when searching words
+	Rank    float64 `json:"rank,omitempty"`
+	Snippet string  `json:"snippet,omitempty"`
+}
+
+// apiArticlesMeta is the page of the articles found
+type apiArticlesMeta struct {
+	Total          int    `json:"total"`
+	Approximate    bool   `json:"approximate"`
+	Page           int    `json:"page"`
+	Limit          int    `json:"limit"`
+	NextCursor     string `json:"next_cursor,omitempty"`
+	PreviousCursor string `json:"previous_cursor,omitempty"`
+}
+
+type apiDomain struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	Favourite bool   `json:"favourite"`
+}
+
+type apiFeedStats struct {
+	Feed     string `json:"feed"`
+	Articles int    `json:"articles"`
+}
+
+func newAPIArticle(a data.Article) apiArticle {
+
+	// the ids come from a SERIAL column
+	id, _ := strconv.Atoi(a.ID)
+
+	article := apiArticle{
+		ID:           id,
+		Title:        a.Title,
+		Description:  a.Description,
+		Content:      a.Content,
+		URL:          a.URL,
+		URLToImage:   a.URLToImage,
+		Author:       a.Author,
+		Source:       a.Source,
+		Domain:       a.Domain,
+		PublishedAt:  a.PublishedAt,
+		Country:      a.Country,
+		Language:     a.Language,
+		Category:     a.Category,
+		OtherSources: a.OtherSources,
+		Rank:         a.Rank,
+	}
+
+	if a.Snippet != "" {
+		article.Snippet = string(a.Highlight())
+	}
+
+	return article
+}
+
+// writeAPIData writes the data of a successful answer, meta is omitted if nil
+func writeAPIData(w http.ResponseWriter, data interface{}, meta interface{}) {
+
+	answer := map[string]interface{}{"data": data}
+	if meta != nil {
+		answer["meta"] = meta
+	}
+
+	writeAPIJSON(w, http.StatusOK, answer)
+}
+
+// writeAPIError writes the error envelope
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIJSON(w, status, map[string]apiError{
+		"error": {Status: status, Code: code, Message: message},
+	})
+}
+
+// writeAPIInternalError logs the error and writes the internal_error envelope, not to tell the clients
+// the details of the DB and of the tokens
+func writeAPIInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Error answering %s %s => %v", r.Method, r.URL.Path, err)
+	writeAPIError(w, http.StatusInternalServerError, apiInternalError, "Internal error, try again later.")
+}
+
+func writeAPIJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error writing the API answer => ", err)
+	}
+}
+
+// checkAPITokenMiddleware checks and renews the JWT as checkTokenMiddleware (see checkSession), from the 'token' cookie
+// or the 'Authorization: Bearer' header for the scripts, answering with the error envelope instead of the login
+func checkAPITokenMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// log the request
+		log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+
+		tokenStr := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if tokenStr == "" {
+			if c, err := r.Cookie(tokenCookie); err == nil {
+				tokenStr = c.Value
+			}
+		}
+
+		if tokenStr == "" {
+			writeAPIError(w, http.StatusUnauthorized, apiUnauthorized, "Authentication required: the token cookie or an Authorization Bearer header.")
+			return
+		}
+
+		user, err := checkSession(w, tokenStr, time.Now())
+		if errors.Is(err, errInvalidToken) {
+			log.Printf("Unauthorized API Access => %v", err)
+			writeAPIError(w, http.StatusUnauthorized, apiUnauthorized, "Token isn't valid. Authentication required.")
+			return
+		}
+		if err != nil {
+			writeAPIInternalError(w, r, err)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeAPIError(w, http.StatusMethodNotAllowed, apiMethodNotAllowed, "Only GET is allowed.")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), loggedUserKey, user)))
+	})
+}
+
+// apiGetArticles answers /api/v1/articles with the page of the articles found, same params as /search
+func apiGetArticles(w http.ResponseWriter, r *http.Request) {
+
+	countries, err := myDB.GetCountries()
+	if err != nil {
+		writeAPIInternalError(w, r, err)
+		return
+	}
+
+	req, err := parseSearch(r.URL.Query(), countries, time.Now())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, apiInvalidParam, err.Error())
+		return
+	}
+
+	results, err := myDB.GetArticles(req.Limit, req.Cursor, req.Filter, req.ByRelevance)
+	if errors.Is(err, data.ErrCursor) {
+		writeAPIError(w, http.StatusBadRequest, apiInvalidCursor, "Not a valid cursor: "+req.Cursor)
+		return
+	}
+	if err != nil {
+		writeAPIInternalError(w, r, err)
+		return
+	}
+
+	total, approximate, err := myDB.CountArticles(req.Filter, approxCountAbove)
+	if err != nil {
+		writeAPIInternalError(w, r, err)
+		return
+	}
+
+	articles := make([]apiArticle, 0, len(results.Articles))
+	for _, a := range results.Articles {
+		articles = append(articles, newAPIArticle(a))
+	}
+
+	writeAPIData(w, articles, apiArticlesMeta{
+		Total:          total,
+		Approximate:    approximate,
+		Page:           results.Page,
+		Limit:          req.Limit,
+		NextCursor:     results.NextCursor,
+		PreviousCursor: results.PreviousCursor,
+	})
+}
+
+// apiGetArticle answers /api/v1/articles/{id}
+func apiGetArticle(w http.ResponseWriter, r *http.Request) {
+
+	idParam := strings.TrimPrefix(r.URL.Path, apiPrefix+"/articles/")
+	id, err := strconv.Atoi(idParam)
+	if err != nil || id < 1 {
+		writeAPIError(w, http.StatusBadRequest, apiInvalidParam, "Not a valid article id: "+idParam)
+		return
+	}
+
+	article, err := myDB.GetArticle(id)
+	if errors.Is(err, data.ErrNotFound) {
+		writeAPIError(w, http.StatusNotFound, apiNotFound, "No article with id "+idParam)
+		return
+	}
+	if err != nil {
+		writeAPIInternalError(w, r, err)
+		return
+	}
+
+	writeAPIData(w, newAPIArticle(*article), nil)
+}
+
+// apiGetDomains answers /api/v1/domains with the domains (feeds) collected, 'favourite=true|false' to filter them
+// by the favourites of the user
+func apiGetDomains(w http.ResponseWriter, r *http.Request) {
+
+	favourite := r.URL.Query().Get("favourite")
+	if favourite != "" && favourite != "true" && favourite != "false" {
+		writeAPIError(w, http.StatusBadRequest, apiInvalidParam, "Not a valid favourite: "+favourite)
+		return
+	}
+
+	user := loggedUser(r)
+
+	var found []data.Domain
+	if favourite != "false" {
+		favourites, err := myDB.GetFavouriteDomains(user.ID)
+		if err != nil {
+			writeAPIInternalError(w, r, err)
+			return
+		}
+		found = append(found, favourites.Domains...)
+	}
+	if favourite != "true" {
+		notFavourites, err := myDB.GetNotFavouriteDomains(user.ID)
+		if err != nil {
+			writeAPIInternalError(w, r, err)
+			return
+		}
+		found = append(found, notFavourites.Domains...)
+	}
+
+	domains := make([]apiDomain, 0, len(found))
+	for _, d := range found {
+		domains = append(domains, apiDomain{ID: d.ID, Name: d.Name, Favourite: d.Favourite})
+	}
+
+	writeAPIData(w, domains, map[string]int{"total": len(domains)})
+}
+
+// apiGetFeedStats answers /api/v1/feeds/stats with the number of articles of each favourite feed of the user
+func apiGetFeedStats(w http.ResponseWriter, r *http.Request) {
+
+	perFeed, err := myDB.CountArticlesGroupByFavourites(loggedUser(r).ID)
+	if err != nil {
+		writeAPIInternalError(w, r, err)
+		return
+	}
+
+	stats := make([]apiFeedStats, 0, len(perFeed))
+	for _, apf := range perFeed {
+		stats = append(stats, apiFeedStats{Feed: apf.FeedName, Articles: apf.ArticlesCount})
+	}
+
+	writeAPIData(w, stats, nil)
+}
+
+// apiUnknown answers the paths of the API that don't exist
+func apiUnknown(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, apiNotFound, "No such API: "+r.URL.Path)
+}
+
+// openAPI serves the OpenAPI document of the API
+func openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPIDoc)
+}
+
+// handleAPI adds the handlers of the API to the mux
+func handleAPI(mux *http.ServeMux) {
+
+	mux.Handle(apiPrefix+"/articles", checkAPITokenMiddleware(http.HandlerFunc(apiGetArticles)))
+	mux.Handle(apiPrefix+"/articles/", checkAPITokenMiddleware(http.HandlerFunc(apiGetArticle)))
+	mux.Handle(apiPrefix+"/domains", checkAPITokenMiddleware(http.HandlerFunc(apiGetDomains)))
+	mux.Handle(apiPrefix+"/feeds/stats", checkAPITokenMiddleware(http.HandlerFunc(apiGetFeedStats)))
+	mux.Handle(apiPrefix+"/", checkAPITokenMiddleware(http.HandlerFunc(apiUnknown)))
+
+	// the document is public, to generate the clients
+	mux.HandleFunc(apiPrefix+"/openapi.yaml", openAPI)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+)
+
+// newAPIRequest returns the GET of the API path by the user checkAPITokenMiddleware let in
+func newAPIRequest(user *data.User, path string) *http.Request {
+
+	r := httptest.NewRequest(http.MethodGet, apiPrefix+path, nil)
+	logged := &LoggedUser{ID: user.ID, Username: user.Username, Role: user.Role}
+
+	return r.WithContext(context.WithValue(r.Context(), loggedUserKey, logged))
+}
+
+func TestAPIInternalError(t *testing.T) {
+
+	db := newTestStore(t)
+	user := db.addUser(data.User{Username: "viewer", Role: data.RoleViewer})
+
+	tests := []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/articles", apiGetArticles},
+		{"/articles?country=Italy", apiGetArticles},
+		{"/articles/1", apiGetArticle},
+		{"/domains", apiGetDomains},
+		{"/domains?favourite=false", apiGetDomains},
+		{"/feeds/stats", apiGetFeedStats},
+	}
+
+	// the DB is down
+	db.err = errors.New("pq: connection refused by 10.0.0.5")
+
+	for _, tt := range tests {
+
+		w := httptest.NewRecorder()
+		tt.handler(w, newAPIRequest(user, tt.path))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: status = %d, want 500", tt.path, w.Code)
+		}
+
+		var answer struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&answer); err != nil {
+			t.Errorf("%s: answer is not JSON => %v", tt.path, err)
+			continue
+		}
+		if answer.Error.Status != http.StatusInternalServerError || answer.Error.Code != apiInternalError {
+			t.Errorf("%s: error = %+v, want the internal_error envelope", tt.path, answer.Error)
+		}
+		// the clients are not told the details of the DB
+		if strings.Contains(answer.Error.Message, "pq:") {
+			t.Errorf("%s: message = '%s'", tt.path, answer.Error.Message)
+		}
+	}
+}
+
+func TestAPITokenMiddleware(t *testing.T) {
+
+	db := newTestStore(t)
+	handler := checkAPITokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(loggedUser(r).Username))
+	}))
+
+	// the tokens of the user, issued now and 20 minutes ago: the second one is renewed
+	tokens := func(user *data.User) (fresh, old string, oldClaims *Claims) {
+		fresh, _, err := newToken(user, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		old, oldClaims, err = newToken(user, time.Now().Add(-20*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fresh, old, oldClaims
+	}
+
+	request := func(method, token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, apiPrefix+"/articles", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	checkError := func(name string, w *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		var answer struct {
+			Error apiError `json:"error"`
+		}
+		json.NewDecoder(w.Body).Decode(&answer)
+		if w.Code != status || answer.Error.Code != code {
+			t.Errorf("%s: status = %d, error = %+v, want %d %s", name, w.Code, answer.Error, status, code)
+		}
+	}
+
+	alice := db.addUser(data.User{Username: "alice", Role: data.RoleViewer})
+	fresh, old, oldClaims := tokens(alice)
+
+	checkError("no token", request(http.MethodGet, ""), http.StatusUnauthorized, apiUnauthorized)
+	checkError("not a token", request(http.MethodGet, "abc"), http.StatusUnauthorized, apiUnauthorized)
+	checkError("POST", request(http.MethodPost, fresh), http.StatusMethodNotAllowed, apiMethodNotAllowed)
+
+	// a token far from expiry is not renewed
+	w := request(http.MethodGet, fresh)
+	if w.Code != http.StatusOK || w.Body.String() != "alice" || w.Header().Get("Set-Cookie") != "" {
+		t.Errorf("fresh token: status = %d, body = %s, Set-Cookie = %s", w.Code, w.Body, w.Header().Get("Set-Cookie"))
+	}
+
+	// a token halfway to expiry is renewed in the cookie, and revoked
+	w = request(http.MethodGet, old)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Set-Cookie"), tokenCookie+"=") {
+		t.Errorf("old token: status = %d, Set-Cookie = %s", w.Code, w.Header().Get("Set-Cookie"))
+	}
+	if !db.revoked[oldClaims.Id] {
+		t.Errorf("renewed token not revoked")
+	}
+	checkError("renewed token", request(http.MethodGet, old), http.StatusUnauthorized, apiUnauthorized)
+
+	// a password to change, or a disabled user, ends the sessions
+	bob := db.addUser(data.User{Username: "bob", Role: data.RoleViewer})
+	fresh, old, _ = tokens(bob)
+	if err := db.RequirePasswordChange("bob"); err != nil {
+		t.Fatal(err)
+	}
+	checkError("password to change", request(http.MethodGet, fresh), http.StatusUnauthorized, apiUnauthorized)
+	checkError("password to change, renewal", request(http.MethodGet, old), http.StatusUnauthorized, apiUnauthorized)
+
+	carol := db.addUser(data.User{Username: "carol", Role: data.RoleViewer})
+	fresh, _, _ = tokens(carol)
+	if err := db.SetUserDisabled("carol", true); err != nil {
+		t.Fatal(err)
+	}
+	checkError("disabled", request(http.MethodGet, fresh), http.StatusUnauthorized, apiUnauthorized)
+
+	// the DB is down
+	fresh, _, _ = tokens(alice)
+	db.err = errors.New("pq: connection refused")
+	checkError("DB down", request(http.MethodGet, fresh), http.StatusInternalServerError, apiInternalError)
+}
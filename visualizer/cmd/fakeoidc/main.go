@@ -0,0 +1,45 @@
+// fakeoidc is the mock OpenID Connect issuer of the oidctest package, to try the single sign-on locally:
+//
+//	go run ./cmd/fakeoidc
+//	OIDC_ISSUER=http://localhost:8082 OIDC_CLIENT_ID=visualizer OIDC_CLIENT_SECRET=secret \
+//	OIDC_REDIRECT_URL=http://localhost:8080/oidc/callback ./visualizer
+//
+// It asks for the email and the groups of the user at every login.
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/mesmerai/news-aggregator/visualizer/oidc/oidctest"
+)
+
+func main() {
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8082"
+	}
+
+	issuer := os.Getenv("ISSUER")
+	if issuer == "" {
+		issuer = "http://localhost:" + port
+	}
+
+	clientID := os.Getenv("CLIENT_ID")
+	if clientID == "" {
+		clientID = "visualizer"
+	}
+
+	clientSecret := os.Getenv("CLIENT_SECRET")
+	if clientSecret == "" {
+		clientSecret = "secret"
+	}
+
+	server := oidctest.New(issuer, clientID, clientSecret, oidctest.User{Email: "alice@example.com", Groups: []string{"news-editors"}})
+	server.AutoApprove = false
+
+	log.Printf("Fake OIDC issuer %s listening on port %s, client '%s'", issuer, port, clientID)
+	log.Fatal(http.ListenAndServe(":"+port, server.Handler()))
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// cursor is the position of a page of articles in the sort order: the key of the last article of the previous page
+// or, Backward, of the first article of the next page. It's handed out opaque, see encode.
+type cursor struct {
+	// 'date' or 'relevance', the key is (Rank, PublishedAt, ID) for the relevance and (PublishedAt, ID) for the date
+	Sort        string    `json:"s"`
+	Backward    bool      `json:"b,omitempty"`
+	Rank        float64   `json:"r,omitempty"`
+	PublishedAt time.Time `json:"p"`
+	ID          int       `json:"i"`
+	// number of the page the cursor leads to, the first is 1
+	Page int `json:"n"`
+}
+
+// sortName returns the name of the sort in the cursors
+func sortName(byRank bool) string {
+	if byRank {
+		return "relevance"
+	}
+	return "date"
+}
+
+// newCursor returns the cursor of the page before or after the article
+func newCursor(a Article, byRank, backward bool, page int) (*cursor, error) {
+
+	id, err := strconv.Atoi(a.ID)
+	if err != nil {
+		return nil, fmt.Errorf("article id '%s' => %v", a.ID, err)
+	}
+
+	return &cursor{Sort: sortName(byRank), Backward: backward, Rank: a.Rank, PublishedAt: a.PublishedAt, ID: id, Page: page}, nil
+}
+
+// encode returns the cursor as an opaque string for the URLs
+func (c *cursor) encode() string {
+
+	// only fails for values JSON can't represent, not the case of the fields of the cursor
+	b, _ := json.Marshal(c)
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor decodes the cursor of a search sorted byRank or by date, nil for the first page
+func decodeCursor(s string, byRank bool) (*cursor, error) {
+
+	if s == "" {
+		return nil, nil
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrCursor
+	}
+
+	c := &cursor{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, ErrCursor
+	}
+
+	if c.Sort != sortName(byRank) || c.Page < 1 || c.ID < 1 {
+		return nil, ErrCursor
+	}
+
+	return c, nil
+}
+
+// newResults returns the page of the articles read after the cursor c (nil for the first page) or, Backward, before it
+// in the opposite order. The articles are up to limit+1, the one more telling there's another page.
+func newResults(articles []Article, limit int, c *cursor, byRank bool) (*Results, error) {
+
+	res := &Results{Articles: articles}
+	backward := c != nil && c.Backward
+
+	more := len(res.Articles) > limit
+	if more {
+		res.Articles = res.Articles[:limit]
+	}
+
+	if backward {
+		for i, j := 0, len(res.Articles)-1; i < j; i, j = i+1, j-1 {
+			res.Articles[i], res.Articles[j] = res.Articles[j], res.Articles[i]
+		}
+	}
+
+	// backward with nothing more it's the first page, whatever the cursor says
+	res.Page = 1
+	if c != nil && (!backward || more) {
+		res.Page = c.Page
+	}
+
+	if len(res.Articles) == 0 {
+		return res, nil
+	}
+
+	// forward there's a next page if there's more, backward there's always the page we come from
+	if more || backward {
+		next, err := newCursor(res.Articles[len(res.Articles)-1], byRank, false, res.Page+1)
+		if err != nil {
+			return nil, err
+		}
+		res.NextCursor = next.encode()
+	}
+
+	// backward there's a previous page if there's more, forward if we're not on the first one
+	if (backward && more) || (!backward && res.Page > 1) {
+		// new articles may have come before the first page
+		page := res.Page - 1
+		if page < 1 {
+			page = 1
+		}
+		previous, err := newCursor(res.Articles[0], byRank, true, page)
+		if err != nil {
+			return nil, err
+		}
+		res.PreviousCursor = previous.encode()
+	}
+
+	return res, nil
+}
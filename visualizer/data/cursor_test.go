@@ -0,0 +1,179 @@
+package data
+
+import (
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCursorEncodeDecode(t *testing.T) {
+
+	publishedAt := time.Date(2021, 11, 20, 9, 30, 15, 0, location())
+
+	for _, c := range []cursor{
+		{Sort: "date", PublishedAt: publishedAt, ID: 42, Page: 2},
+		{Sort: "date", Backward: true, PublishedAt: publishedAt, ID: 42, Page: 1},
+		{Sort: "relevance", Rank: 0.125, PublishedAt: publishedAt, ID: 7, Page: 5},
+	} {
+		byRank := c.Sort == "relevance"
+
+		encoded := c.encode()
+		// opaque in the URLs
+		if strings.ContainsAny(encoded, "+/=&?") {
+			t.Errorf("%+v: encoded '%s' is not URL safe", c, encoded)
+		}
+
+		decoded, err := decodeCursor(encoded, byRank)
+		if err != nil {
+			t.Errorf("%+v: decode error: %v", c, err)
+			continue
+		}
+		if !decoded.PublishedAt.Equal(c.PublishedAt) {
+			t.Errorf("%+v: published at %v", c, decoded.PublishedAt)
+		}
+		decoded.PublishedAt = c.PublishedAt
+		if *decoded != c {
+			t.Errorf("decoded %+v, want %+v", *decoded, c)
+		}
+	}
+
+	// the first page has no cursor
+	if c, err := decodeCursor("", false); c != nil || err != nil {
+		t.Errorf("empty cursor = %+v, %v", c, err)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+
+	valid := (&cursor{Sort: "date", PublishedAt: time.Now(), ID: 42, Page: 2}).encode()
+	raw := func(json string) string { return base64.RawURLEncoding.EncodeToString([]byte(json)) }
+
+	tests := []struct {
+		name   string
+		cursor string
+		byRank bool
+	}{
+		{"not base64", "not a cursor!", false},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"date","p":"2021-11-20T09:30:00Z","i":42,"n":2}`)), false},
+		{"truncated", valid[:len(valid)-4], false},
+		{"not JSON", raw("page 2"), false},
+		{"wrong types", raw(`{"s":"date","p":"yesterday","i":"42","n":2}`), false},
+		{"no sort", raw(`{"p":"2021-11-20T09:30:00Z","i":42,"n":2}`), false},
+		{"no page", raw(`{"s":"date","p":"2021-11-20T09:30:00Z","i":42}`), false},
+		{"no id", raw(`{"s":"date","p":"2021-11-20T09:30:00Z","n":2}`), false},
+		{"negative id", raw(`{"s":"date","p":"2021-11-20T09:30:00Z","i":-1,"n":2}`), false},
+		// a cursor of the other sort
+		{"by date for relevance", valid, true},
+		{"by relevance for date", raw(`{"s":"relevance","r":0.5,"p":"2021-11-20T09:30:00Z","i":42,"n":2}`), false},
+	}
+
+	for _, tt := range tests {
+		if c, err := decodeCursor(tt.cursor, tt.byRank); err != ErrCursor {
+			t.Errorf("%s: cursor = %+v, err = %v, want ErrCursor", tt.name, c, err)
+		}
+	}
+}
+
+// readPage reads the articles as the SELECT of GetArticles by date: the limit+1 after the cursor, most recent first,
+// or the limit+1 before it in the opposite order when it's Backward
+func readPage(all []Article, limit int, c *cursor) []Article {
+
+	// the key of the sort (published_at, id)
+	less := func(a, b Article) bool {
+		if !a.PublishedAt.Equal(b.PublishedAt) {
+			return a.PublishedAt.Before(b.PublishedAt)
+		}
+		return a.ID < b.ID
+	}
+
+	sorted := append([]Article(nil), all...)
+	sort.Slice(sorted, func(i, j int) bool { return less(sorted[j], sorted[i]) })
+
+	var found []Article
+	if c == nil || !c.Backward {
+		for _, a := range sorted {
+			if c == nil || less(a, Article{ID: strconv.Itoa(c.ID), PublishedAt: c.PublishedAt}) {
+				found = append(found, a)
+			}
+		}
+	} else {
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if less(Article{ID: strconv.Itoa(c.ID), PublishedAt: c.PublishedAt}, sorted[i]) {
+				found = append(found, sorted[i])
+			}
+		}
+	}
+
+	if len(found) > limit+1 {
+		found = found[:limit+1]
+	}
+	return found
+}
+
+func TestNewResults(t *testing.T) {
+
+	// articles 1 to 8 an hour apart, the ids with the same number of digits so they sort as the numbers
+	var all []Article
+	start := time.Date(2021, 11, 20, 0, 0, 0, 0, location())
+	for i := 1; i <= 8; i++ {
+		all = append(all, Article{ID: strconv.Itoa(i), PublishedAt: start.Add(time.Duration(i) * time.Hour)})
+	}
+
+	const limit = 3
+
+	// page returns the page at the cursor
+	page := func(all []Article, pageCursor string) *Results {
+		t.Helper()
+		c, err := decodeCursor(pageCursor, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := newResults(readPage(all, limit, c), limit, c, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	check := func(name string, res *Results, number int, ids string, next, previous bool) {
+		t.Helper()
+		var got []string
+		for _, a := range res.Articles {
+			got = append(got, a.ID)
+		}
+		if res.Page != number || strings.Join(got, " ") != ids || (res.NextCursor != "") != next || (res.PreviousCursor != "") != previous {
+			t.Errorf("%s: page %d, articles '%s', next %t, previous %t; want page %d, articles '%s', next %t, previous %t",
+				name, res.Page, strings.Join(got, " "), res.NextCursor != "", res.PreviousCursor != "", number, ids, next, previous)
+		}
+	}
+
+	first := page(all, "")
+	check("first", first, 1, "8 7 6", true, false)
+
+	second := page(all, first.NextCursor)
+	check("second", second, 2, "5 4 3", true, true)
+
+	third := page(all, second.NextCursor)
+	check("third", third, 3, "2 1", false, true)
+
+	// back, the pages read before the cursor in the opposite order are reversed
+	check("back to the second", page(all, third.PreviousCursor), 2, "5 4 3", true, true)
+	check("back to the first", page(all, second.PreviousCursor), 1, "8 7 6", true, false)
+
+	// new articles come while on the second page: back there are still the articles before it,
+	// then the new ones on a first page of their own
+	newer := append(all, Article{ID: "9", PublishedAt: start.Add(9 * time.Hour)})
+	back := page(newer, second.PreviousCursor)
+	check("back with new articles", back, 1, "8 7 6", true, true)
+	check("the new articles", page(newer, back.PreviousCursor), 1, "9", true, false)
+
+	// nothing after the last article, e.g. deleted meanwhile
+	after, err := newCursor(all[0], false, false, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("after the last", page(all, after.encode()), 4, "", false, false)
+}
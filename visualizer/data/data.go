@@ -1,11 +1,14 @@
 package data
 
 import (
-	"bytes"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type DBClient struct {
@@ -15,7 +18,13 @@ type DBClient struct {
 type Results struct {
 	Status       string
 	TotalResults int
-	Articles     []Article
+	// TotalResults is an estimate, see CountArticles
+	Approximate bool
+	Articles    []Article
+	// number of the page, the first is 1, and the cursors of the pages around it (empty if none), see GetArticles
+	Page           int
+	NextCursor     string
+	PreviousCursor string
 }
 
 type Source struct {
@@ -61,21 +70,35 @@ type Article struct {
 	Country     string
 	Language    string
 	Category    string
+	// number of near-duplicates from other sources, when grouping by story cluster
+	OtherSources int
+	// relevance to the words searched and the text around them, see Highlight
+	Rank    float64
+	Snippet string
 }
 
+// Timezone of the dates shown, of the date filters and of the days of the histogram
+const Timezone = "Australia/Sydney"
+
 // format the 'PublishedAt' date
 func (a *Article) FormatPublishedDate() string {
 
 	var t time.Time = a.PublishedAt
 
-	loc, err := time.LoadLocation("Australia/Sydney")
+	localTime := t.In(location())
+	return fmt.Sprintf("%v", localTime)
+
+}
+
+// location returns the location of the Timezone
+func location() *time.Location {
+
+	loc, err := time.LoadLocation(Timezone)
 	if err != nil {
 		log.Fatal("Cannot Load Location: ", err)
 	}
 
-	localTime := t.In(loc)
-	return fmt.Sprintf("%v", localTime)
-
+	return loc
 }
 
 func NewDBClient(db_host string, db_port int, db_name string, db_user string, db_password string, maxRetries int) (db *DBClient) {
@@ -110,144 +133,158 @@ func NewDBClient(db_host string, db_port int, db_name string, db_user string, db
 
 }
 
-func (db *DBClient) SetFavourites(dList []string) *sql.Rows {
+// add the domains to the Favourites of the user
+func (db *DBClient) SetFavourites(userID int, dList []string) error {
 
 	log.Printf("Initiate SetFavourites")
 
-	var updateRows *sql.Rows
-	var updateErr error
-
-	buf := bytes.NewBufferString("UPDATE domains SET favourite = TRUE WHERE name in ('")
-	for i, dName := range dList {
-		if i > 0 {
-			buf.WriteString("','")
-		}
-		buf.WriteString(dName)
-	}
-	buf.WriteString("')")
-
-	// Debug
-	//fmt.Println("SQL: ", buf.String())
+	sqlInsert := `INSERT INTO user_favourites (user_id, domain_id) 
+	SELECT $1, d.id FROM domains d WHERE d.name = ANY($2) 
+	ON CONFLICT DO NOTHING`
 
-	updateRows, updateErr = db.Database.Query(buf.String())
-	if updateErr != nil {
-		log.Fatal("Error on SQL UPDATE => ", updateErr)
+	_, insertErr := db.Database.Exec(sqlInsert, userID, pq.Array(dList))
+	if insertErr != nil {
+		return fmt.Errorf("error on SQL INSERT => %w", insertErr)
 	}
 
-	return updateRows
+	return nil
 }
 
-// delete all Favourites
-func (db *DBClient) ResetFavourites() *sql.Rows {
+// delete all Favourites of the user
+func (db *DBClient) ResetFavourites(userID int) error {
 
 	log.Printf("Initiate ResetFavourites")
 
-	var updateRows *sql.Rows
-	var updateErr error
-
-	sqlUpdate := "UPDATE domains SET favourite = FALSE"
-
-	updateRows, updateErr = db.Database.Query(sqlUpdate)
-	if updateErr != nil {
-		log.Fatal("Error on SQL UPDATE => ", updateErr)
+	_, deleteErr := db.Database.Exec("DELETE FROM user_favourites WHERE user_id = $1", userID)
+	if deleteErr != nil {
+		return fmt.Errorf("error on SQL DELETE => %w", deleteErr)
 	}
 
-	return updateRows
+	return nil
 }
 
-func (db *DBClient) CountFavouriteDomains() int {
+func (db *DBClient) CountFavouriteDomains(userID int) (int, error) {
 	log.Printf("Initiate CountFavouriteDomains")
 
 	var id = 0
 	var selectRow *sql.Row
 	var selectErr error
 
-	sqlSelect := "SELECT COUNT(*) FROM domains WHERE favourite IS TRUE"
-	selectRow = db.Database.QueryRow(sqlSelect)
+	sqlSelect := "SELECT COUNT(*) FROM user_favourites WHERE user_id = $1"
+	selectRow = db.Database.QueryRow(sqlSelect, userID)
 
 	selectErr = selectRow.Scan(&id)
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return 0, fmt.Errorf("error on SQL SELECT => %w", selectErr)
 	}
 
-	return id
+	return id, nil
 
 }
 
-func (db *DBClient) CountNotFavouriteDomains() int {
+func (db *DBClient) CountNotFavouriteDomains(userID int) (int, error) {
 	log.Printf("Initiate CountNotFavouriteDomains")
 
 	var id = 0
 	var selectRow *sql.Row
 	var selectErr error
 
-	sqlSelect := "SELECT COUNT(*) FROM domains WHERE favourite IS FALSE"
-	selectRow = db.Database.QueryRow(sqlSelect)
+	sqlSelect := `SELECT COUNT(*) FROM domains d 
+	WHERE NOT EXISTS (SELECT 1 FROM user_favourites uf WHERE uf.domain_id = d.id AND uf.user_id = $1)`
+	selectRow = db.Database.QueryRow(sqlSelect, userID)
 
 	selectErr = selectRow.Scan(&id)
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return 0, fmt.Errorf("error on SQL SELECT => %w", selectErr)
 	}
 
-	return id
+	return id, nil
+
+}
 
+// Filter is the filter of the articles of a search, the empty Filter matches all the articles
+type Filter struct {
+	// country name as stored with the articles, empty for all the countries
+	Country string
+	// top headlines category, empty for all
+	Category string
+	Query    *Query
+	// published from (included) and to (excluded), zero for no limit
+	From time.Time
+	To   time.Time
+	// one article per story cluster, see ncollector/cluster
+	Collapse bool
 }
 
-func (db *DBClient) CountArticles(word string) int {
+// CountArticles counts the articles matching the filter, or the story clusters they belong to with Collapse.
+// When the planner estimates more than approxAbove of them (0 to always count) the estimate is returned,
+// approximate, not to count a large part of the table at every search.
+func (db *DBClient) CountArticles(filter Filter, approxAbove int) (count int, approximate bool, err error) {
 	log.Printf("Initiate CountArticles")
 
 	var id = 0
 	var selectRow *sql.Row
 	var selectErr error
 
-	if word == "" {
-		sqlSelect := "SELECT COUNT(*) FROM articles"
-		// QueryRow returns a *Row
-		selectRow = db.Database.QueryRow(sqlSelect)
-	} else {
-		sqlSelect := `SELECT COUNT(*) FROM articles 
-		WHERE title ILIKE '%'||$1||'%' OR content ILIKE '%'||$1||'%'`
-		// QueryRow returns a *Row
-		selectRow = db.Database.QueryRow(sqlSelect, word)
+	where, args, _ := articlesFilter(filter, 1)
+
+	if approxAbove > 0 {
+		estimate, err := db.estimateArticles(filter, where, args)
+		if err != nil {
+			return 0, false, err
+		}
+		if estimate > approxAbove {
+			return estimate, true, nil
+		}
+	}
+
+	sqlSelect := `SELECT COUNT(*) FROM articles a WHERE ` + where
+	if filter.Collapse {
+		sqlSelect = `SELECT COUNT(DISTINCT COALESCE(a.cluster_id, -a.id)) FROM articles a WHERE ` + where
 	}
 
+	// QueryRow returns a *Row
+	selectRow = db.Database.QueryRow(sqlSelect, args...)
+
 	selectErr = selectRow.Scan(&id)
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return 0, false, fmt.Errorf("error on SQL SELECT => %w", selectErr)
 	}
 
-	return id
+	return id, false, nil
 
 }
 
-func (db *DBClient) CountArticlesByCountry(country, word string) int {
-	log.Printf("Initiate CountArticlesByCountry")
+// estimateArticles returns the planner estimate of the articles (or story clusters) matching the WHERE condition
+func (db *DBClient) estimateArticles(filter Filter, where string, args []interface{}) (int, error) {
 
-	var id = 0
-	var selectRow *sql.Row
-	var selectErr error
+	var plan []byte
 
-	if word == "" {
-		sqlSelect := `SELECT COUNT(*) FROM articles WHERE country = $1`
-		// QueryRow returns a *Row
-		selectRow = db.Database.QueryRow(sqlSelect, country)
-	} else {
-		sqlSelect := `SELECT COUNT(*) FROM articles 
-		WHERE country = $1 AND (title ILIKE '%'||$2||'%' OR content ILIKE '%'||$2||'%')`
-		// QueryRow returns a *Row
-		selectRow = db.Database.QueryRow(sqlSelect, country, word)
+	sqlSelect := `EXPLAIN (FORMAT JSON) SELECT 1 FROM articles a WHERE ` + where
+	if filter.Collapse {
+		sqlSelect += ` GROUP BY COALESCE(a.cluster_id, -a.id)`
 	}
 
-	selectErr = selectRow.Scan(&id)
+	selectErr := db.Database.QueryRow(sqlSelect, args...).Scan(&plan)
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return 0, fmt.Errorf("error on SQL EXPLAIN => %w", selectErr)
 	}
 
-	return id
+	var explain []struct {
+		Plan struct {
+			Rows float64 `json:"Plan Rows"`
+		}
+	}
+	if err := json.Unmarshal(plan, &explain); err != nil || len(explain) == 0 {
+		log.Printf("Error reading the SQL EXPLAIN => %v", err)
+		return 0, nil
+	}
 
+	return int(explain[0].Plan.Rows), nil
 }
 
-func (db *DBClient) CountArticlesGroupByFavourites() []ArticlePerFeed {
+// CountArticlesGroupByFavourites counts the articles of each favourite feed of the user
+func (db *DBClient) CountArticlesGroupByFavourites(userID int) ([]ArticlePerFeed, error) {
 
 	log.Printf("Initiate CountArticlesGroupByFavourites")
 
@@ -256,214 +293,285 @@ func (db *DBClient) CountArticlesGroupByFavourites() []ArticlePerFeed {
 	var selectRows *sql.Rows
 	var selectErr error
 	sqlSelect := `SELECT COUNT(a) articlesCount, d.name feed 
-	FROM articles a, domains d 
-	WHERE a.domain_id = d.id AND d.favourite IS TRUE 
+	FROM articles a, domains d, user_favourites uf 
+	WHERE a.domain_id = d.id AND uf.domain_id = d.id AND uf.user_id = $1 
 	GROUP BY d.name 
 	ORDER by feed ASC;`
 
-	selectRows, selectErr = db.Database.Query(sqlSelect)
+	selectRows, selectErr = db.Database.Query(sqlSelect, userID)
 
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return nil, fmt.Errorf("error on SQL SELECT => %w", selectErr)
 	}
 
+	defer selectRows.Close()
+
 	for selectRows.Next() {
 
 		var apf ArticlePerFeed
 
 		err := selectRows.Scan(&apf.ArticlesCount, &apf.FeedName)
 		if err != nil {
-			log.Fatal("Error on reading SQL SELECT results => ", err)
+			return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
 		}
 
 		apfSlice = append(apfSlice, apf)
 	}
 
-	return apfSlice
+	return apfSlice, selectRows.Err()
 
 }
 
-func (db *DBClient) GetFavouriteDomains() *FavouriteDomains {
+// GetFavouriteDomains returns the favourite domains of the user
+func (db *DBClient) GetFavouriteDomains(userID int) (*FavouriteDomains, error) {
 
 	log.Printf("Initiate GetFavouriteDomains")
 	res := &FavouriteDomains{}
 
-	var selectRows *sql.Rows
-	var selectErr error
-	sqlSelect := ""
-
-	sqlSelect = `SELECT d.id, d.name, d.favourite  
-	FROM domains d 
-	WHERE  favourite IS TRUE
+	sqlSelect := `SELECT d.id, d.name, TRUE  
+	FROM domains d, user_favourites uf 
+	WHERE uf.domain_id = d.id AND uf.user_id = $1
 	ORDER BY d.name ASC`
 
-	selectRows, selectErr = db.Database.Query(sqlSelect)
-
-	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
-	}
-
-	for selectRows.Next() {
-		var d Domain
-		err := selectRows.Scan(&d.ID, &d.Name, &d.Favourite)
-		if err != nil {
-			log.Fatal("Error on reading SQL SELECT results => ", err)
-		}
-
-		// populate the domains struct and write that into FavouriteDomains.Domain that is a slice:  []Domain
-		res.Domains = append(res.Domains, d)
+	domains, err := db.selectDomains(sqlSelect, userID)
+	if err != nil {
+		return nil, err
 	}
+	res.Domains = domains
 
-	return res
+	return res, nil
 
 }
 
-func (db *DBClient) GetNotFavouriteDomains() *NotFavouriteDomains {
+// GetNotFavouriteDomains returns the domains that aren't favourites of the user
+func (db *DBClient) GetNotFavouriteDomains(userID int) (*NotFavouriteDomains, error) {
 
 	log.Printf("Initiate GetNotFavouriteDomains")
 	res := &NotFavouriteDomains{}
 
-	var selectRows *sql.Rows
-	var selectErr error
-	sqlSelect := ""
-
-	sqlSelect = `SELECT d.id, d.name, d.favourite  
+	sqlSelect := `SELECT d.id, d.name, FALSE  
 	FROM domains d 
-	WHERE  favourite IS FALSE
+	WHERE NOT EXISTS (SELECT 1 FROM user_favourites uf WHERE uf.domain_id = d.id AND uf.user_id = $1)
 	ORDER BY d.name ASC`
 
-	selectRows, selectErr = db.Database.Query(sqlSelect)
+	domains, err := db.selectDomains(sqlSelect, userID)
+	if err != nil {
+		return nil, err
+	}
+	res.Domains = domains
+
+	return res, nil
+
+}
+
+// selectDomains returns the domains of the SELECT of id, name and favourite
+func (db *DBClient) selectDomains(sqlSelect string, args ...interface{}) ([]Domain, error) {
 
+	selectRows, selectErr := db.Database.Query(sqlSelect, args...)
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return nil, fmt.Errorf("error on SQL SELECT => %w", selectErr)
 	}
 
+	defer selectRows.Close()
+
+	var domains []Domain
 	for selectRows.Next() {
 		var d Domain
 		err := selectRows.Scan(&d.ID, &d.Name, &d.Favourite)
 		if err != nil {
-			log.Fatal("Error on reading SQL SELECT results => ", err)
+			return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
 		}
+		domains = append(domains, d)
+	}
+
+	return domains, selectRows.Err()
+}
+
+// articleColumns are the columns scanned into an Article, the last one is the number of OtherSources
+const articleColumns = `a.id, s.name AS source_name, d.name AS domain_name, a.author, a.title, a.description, 
+	a.url, a.url_to_image, a.published_at, a.content, a.country, a.language, a.category`
+
+// articlesFilter returns the WHERE condition of the filter, with the args starting from $first.
+// The words of the query are searched with the full-text search, tsquery is their tsquery in the language of each article
+// (empty without words) to rank the articles and highlight the snippets.
+func articlesFilter(filter Filter, first int) (where string, args []interface{}, tsquery string) {
+
+	conditions := []string{"TRUE"}
+	args = []interface{}{}
+
+	if filter.Country != "" {
+		args = append(args, filter.Country)
+		conditions = append(conditions, fmt.Sprintf("a.country = $%d", first+len(args)-1))
+	}
 
-		// populate the domains struct and write that into FavouriteDomains.Domain that is a slice:  []Domain
-		res.Domains = append(res.Domains, d)
+	if filter.Category != "" {
+		args = append(args, filter.Category)
+		conditions = append(conditions, fmt.Sprintf("a.category = $%d", first+len(args)-1))
 	}
 
-	return res
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		conditions = append(conditions, fmt.Sprintf("a.published_at >= $%d", first+len(args)-1))
+	}
+
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		conditions = append(conditions, fmt.Sprintf("a.published_at < $%d", first+len(args)-1))
+	}
 
+	queryConditions, queryArgs, tsquery := filter.Query.filter(first + len(args))
+	conditions = append(conditions, queryConditions...)
+	args = append(args, queryArgs...)
+
+	return strings.Join(conditions, " AND "), args, tsquery
 }
 
-func (db *DBClient) GetArticles(limit, offset int, word string) *Results {
+// GetArticles returns the page of articles matching the filter after (or before) the pageCursor, most recent first
+// or, when searching words byRelevance, most relevant first.
+// The pages are keyed on (published_at, id), after the rank by relevance, so they don't shift
+// when new articles are collected and the deep pages are as fast as the first one.
+// With Collapse only one article per story cluster is returned (the representative, if matching the filter),
+// counting the other articles of the cluster in OtherSources.
+// It returns ErrCursor for a cursor not handed out by a page of the same sort.
+func (db *DBClient) GetArticles(limit int, pageCursor string, filter Filter, byRelevance bool) (*Results, error) {
 
 	log.Printf("Initiate GetArticles")
 
-	//articles := []Article{}
-	res := &Results{}
-
 	var selectRows *sql.Rows
 	var selectErr error
 	sqlSelect := ""
 
-	if word == "" {
+	where, args, tsquery := articlesFilter(filter, 2)
+	byRank := byRelevance && tsquery != ""
 
-		sqlSelect = `SELECT a.id, s.name, d.name, a.author, a.title, a.description, 
-		a.url, a.url_to_image, a.published_at, a.content, a.country, a.language, a.category 
-		FROM articles a, sources s, domains d 
-		WHERE a.source_id = s.id AND a.domain_id = d.id 
-		ORDER BY published_at 
-		DESC LIMIT $1 OFFSET $2 `
+	c, err := decodeCursor(pageCursor, byRank)
+	if err != nil {
+		return nil, err
+	}
 
-		selectRows, selectErr = db.Database.Query(sqlSelect, limit, offset)
+	// relevance and snippet of the search
+	rank := "0"
+	snippet := "''"
+	if tsquery != "" {
+		args = append(args, snippetOptions)
+		rank = "ts_rank_cd(a.search_vector, " + tsquery + ")::float8"
+		snippet = fmt.Sprintf("ts_headline(%s, coalesce(NULLIF(a.description, ''), a.content, ''), %s, $%d)", articleConfig, tsquery, len(args)+1)
+	}
+	searchColumns := rank + " AS rank, " + snippet + " AS snippet"
 
-	} else {
+	// the key of the sort and the position of the cursor in it
+	keys := []string{"r.published_at", "r.id"}
+	if byRank {
+		keys = append([]string{"r.rank"}, keys...)
+	}
 
-		sqlSelect = `SELECT a.id, s.name, d.name, a.author, a.title, a.description, 
-		a.url, a.url_to_image, a.published_at, a.content, a.country, a.language, a.category 
-		FROM articles a, sources s, domains d 
-		WHERE (a.source_id = s.id AND a.domain_id = d.id) 
-		AND (title ILIKE '%'||$3||'%' OR description ILIKE  '%'||$3||'%' OR content ILIKE  '%'||$3||'%') 
-		ORDER BY published_at 
-		DESC LIMIT $1 OFFSET $2 `
+	keyset := "TRUE"
+	backward := c != nil && c.Backward
+	if c != nil {
+		var values []string
+		if byRank {
+			args = append(args, c.Rank)
+			values = append(values, fmt.Sprintf("$%d::float8", len(args)+1))
+		}
+		args = append(args, c.PublishedAt, c.ID)
+		values = append(values, fmt.Sprintf("$%d::timestamptz", len(args)), fmt.Sprintf("$%d::int", len(args)+1))
 
-		selectRows, selectErr = db.Database.Query(sqlSelect, limit, offset, word)
+		operator := "<"
+		if backward {
+			operator = ">"
+		}
+		keyset = "(" + strings.Join(keys, ", ") + ") " + operator + " (" + strings.Join(values, ", ") + ")"
+	}
+
+	// backward the page before the cursor is read in the opposite order, then reversed
+	direction := " DESC"
+	if backward {
+		direction = " ASC"
+	}
+	order := strings.Join(keys, direction+", ") + direction
+
+	if !filter.Collapse {
+
+		sqlSelect = `SELECT * FROM (
+			SELECT ` + articleColumns + `, 0 AS other_sources, ` + searchColumns + ` 
+			FROM articles a, sources s, domains d 
+			WHERE a.source_id = s.id AND a.domain_id = d.id 
+			AND ` + where + `
+		) r
+		WHERE ` + keyset + `
+		ORDER BY ` + order + ` 
+		LIMIT $1 `
+
+	} else {
+
+		// articles without a cluster yet are on their own (negative id to not clash with the cluster ids)
+		sqlSelect = `SELECT * FROM (
+			SELECT DISTINCT ON (COALESCE(a.cluster_id, -a.id)) ` + articleColumns + `, 
+			COUNT(*) OVER (PARTITION BY COALESCE(a.cluster_id, -a.id)) - 1 AS other_sources, ` + searchColumns + `
+			FROM articles a 
+			JOIN sources s ON a.source_id = s.id 
+			JOIN domains d ON a.domain_id = d.id 
+			LEFT JOIN story_clusters c ON a.cluster_id = c.id
+			WHERE ` + where + `
+			ORDER BY COALESCE(a.cluster_id, -a.id), (a.id = c.representative_id) DESC NULLS LAST, a.published_at ASC
+		) r
+		WHERE ` + keyset + `
+		ORDER BY ` + order + ` 
+		LIMIT $1 `
 
 	}
 
+	// one more article to know if there's another page
+	selectRows, selectErr = db.Database.Query(sqlSelect, append([]interface{}{limit + 1}, args...)...)
+
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
+		return nil, fmt.Errorf("error on SQL SELECT => %w", selectErr)
 	}
 
+	defer selectRows.Close()
+
+	var articles []Article
+
 	for selectRows.Next() {
 		var a Article
-		err := selectRows.Scan(&a.ID, &a.Source, &a.Domain, &a.Author, &a.Title, &a.Description, &a.URL, &a.URLToImage, &a.PublishedAt, &a.Content, &a.Country, &a.Content, &a.Category)
+		err := selectRows.Scan(&a.ID, &a.Source, &a.Domain, &a.Author, &a.Title, &a.Description, &a.URL, &a.URLToImage, &a.PublishedAt, &a.Content, &a.Country, &a.Language, &a.Category, &a.OtherSources, &a.Rank, &a.Snippet)
 		if err != nil {
-			log.Fatal("Error on reading SQL SELECT results => ", err)
+			return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
 		}
 
-		// populate articles
-		//articles = append(articles, a)
 		// this is a slice of Article type
-		res.Articles = append(res.Articles, a)
+		articles = append(articles, a)
+	}
+	if err := selectRows.Err(); err != nil {
+		return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
 	}
 
-	return res
+	return newResults(articles, limit, c, byRank)
 
 }
 
-func (db *DBClient) GetArticlesByCountry(limit, offset int, country, word string) *Results {
-
-	log.Printf("Initiate GetArticlesByCountry")
-
-	//articles := []Article{}
-	res := &Results{}
-
-	var selectRows *sql.Rows
-	var selectErr error
-	sqlSelect := ""
-
-	if word == "" {
-
-		sqlSelect = `SELECT a.id, s.name, d.name, a.author, a.title, a.description, 
-		a.url, a.url_to_image, a.published_at, a.content, a.country, a.language, a.category 
-		FROM articles a, sources s, domains d 
-		WHERE a.source_id = s.id AND a.domain_id = d.id 
-		AND country = $3
-		ORDER BY published_at 
-		DESC LIMIT $1 OFFSET $2 `
+// GetArticle returns the article by id, with the number of the other articles of its story cluster.
+// It returns ErrNotFound if there's no such article.
+func (db *DBClient) GetArticle(id int) (*Article, error) {
 
-		selectRows, selectErr = db.Database.Query(sqlSelect, limit, offset, country)
+	log.Printf("Initiate GetArticle")
 
-	} else {
-
-		sqlSelect = `SELECT a.id, s.name, d.name, a.author, a.title, a.description, 
-		a.url, a.url_to_image, a.published_at, a.content, a.country, a.language, a.category 
-		FROM articles a, sources s, domains d 
-		WHERE a.source_id = s.id AND a.domain_id = d.id 
-		AND country = $3
-		AND (title ILIKE '%'||$4||'%' OR description ILIKE '%'||$4||'%' OR content ILIKE '%'||$4||'%') 
-		ORDER BY published_at 
-		DESC LIMIT $1 OFFSET $2 `
+	var a Article
 
-		selectRows, selectErr = db.Database.Query(sqlSelect, limit, offset, country, word)
+	sqlSelect := `SELECT ` + articleColumns + `, 
+	(SELECT COUNT(*) FROM articles o WHERE o.cluster_id = a.cluster_id AND o.id <> a.id) AS other_sources
+	FROM articles a, sources s, domains d 
+	WHERE a.source_id = s.id AND a.domain_id = d.id AND a.id = $1`
 
+	selectErr := db.Database.QueryRow(sqlSelect, id).Scan(&a.ID, &a.Source, &a.Domain, &a.Author, &a.Title, &a.Description, &a.URL, &a.URLToImage, &a.PublishedAt, &a.Content, &a.Country, &a.Language, &a.Category, &a.OtherSources)
+	if selectErr == sql.ErrNoRows {
+		return nil, ErrNotFound
 	}
-
 	if selectErr != nil {
-		log.Fatal("Error on SQL SELECT => ", selectErr)
-	}
-
-	for selectRows.Next() {
-		var a Article
-		err := selectRows.Scan(&a.ID, &a.Source, &a.Domain, &a.Author, &a.Title, &a.Description, &a.URL, &a.URLToImage, &a.PublishedAt, &a.Content, &a.Country, &a.Content, &a.Category)
-		if err != nil {
-			log.Fatal("Error on reading SQL SELECT results => ", err)
-		}
-
-		res.Articles = append(res.Articles, a)
+		return nil, fmt.Errorf("error on SQL SELECT => %w", selectErr)
 	}
 
-	return res
-
+	return &a, nil
 }
 
 func (db *DBClient) GetDomainID(name string) int {
@@ -535,7 +643,7 @@ func (db *DBClient) GetSourcesByName(name string) *sql.Rows {
 	var sqlSelect = ""
 	var selectErr error
 
-	sqlSelect = "SELECT * FROM sources WHERE name = $1"
+	sqlSelect = "SELECT id, name FROM sources WHERE name = $1"
 
 	selectRows, selectErr = db.Database.Query(sqlSelect, name)
 	if selectErr != nil {
@@ -616,3 +724,125 @@ func (db *DBClient) InsertArticle(sourceID, domainID int, author string, title s
 
 	log.Printf("Article stored in the DB.")
 }
+
+// Country is a country the articles are collected for, see the 'countries' table
+type Country struct {
+	Code string
+	Name string
+	Flag string
+}
+
+// GetCountries returns the enabled countries, to populate the search filter
+func (db *DBClient) GetCountries() ([]Country, error) {
+
+	log.Printf("Initiate GetCountries")
+
+	var countries []Country
+	var selectRows *sql.Rows
+	var selectErr error
+	sqlSelect := ""
+
+	sqlSelect = `SELECT code, name, flag FROM countries WHERE enabled IS TRUE ORDER BY name ASC`
+
+	selectRows, selectErr = db.Database.Query(sqlSelect)
+	if selectErr != nil {
+		return nil, fmt.Errorf("error on SQL SELECT => %w", selectErr)
+	}
+
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var c Country
+		err := selectRows.Scan(&c.Code, &c.Name, &c.Flag)
+		if err != nil {
+			return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
+		}
+		countries = append(countries, c)
+	}
+
+	return countries, selectRows.Err()
+}
+
+// Quota is the API calls budget of a news provider, see ncollector/quota
+type Quota struct {
+	Provider string
+	Capacity int
+	Left     int
+}
+
+// GetQuotas returns the calls left per provider, refilled up to now
+func (db *DBClient) GetQuotas() ([]Quota, error) {
+
+	log.Printf("Initiate GetQuotas")
+
+	var quotas []Quota
+	var selectRows *sql.Rows
+	var selectErr error
+	sqlSelect := ""
+
+	// same refill as ncollector/quota.Refill
+	sqlSelect = `SELECT provider, capacity, 
+	FLOOR(LEAST(capacity, tokens + capacity * EXTRACT(EPOCH FROM NOW() - updated_at) / period_seconds))::INT
+	FROM api_quotas 
+	ORDER BY provider ASC`
+
+	selectRows, selectErr = db.Database.Query(sqlSelect)
+	if selectErr != nil {
+		return nil, fmt.Errorf("error on SQL SELECT => %w", selectErr)
+	}
+
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var q Quota
+		err := selectRows.Scan(&q.Provider, &q.Capacity, &q.Left)
+		if err != nil {
+			return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
+		}
+		quotas = append(quotas, q)
+	}
+
+	return quotas, selectRows.Err()
+}
+
+// Categories are the top headlines categories the collector can fetch
+var Categories = []string{"business", "entertainment", "general", "health", "science", "sports", "technology"}
+
+// Category is a top headlines category with the number of its articles, for the search facet
+type Category struct {
+	Name  string
+	Count int
+}
+
+// GetCategories returns the categories of the articles of the country (all if empty), most used first
+func (db *DBClient) GetCategories(country string) ([]Category, error) {
+
+	log.Printf("Initiate GetCategories")
+
+	var categories []Category
+	var selectRows *sql.Rows
+	var selectErr error
+	sqlSelect := ""
+
+	sqlSelect = `SELECT category, COUNT(*) FROM articles 
+	WHERE category <> '' AND ($1 = '' OR country = $1) 
+	GROUP BY category ORDER BY COUNT(*) DESC, category ASC`
+
+	selectRows, selectErr = db.Database.Query(sqlSelect, country)
+	if selectErr != nil {
+		return nil, fmt.Errorf("error on SQL SELECT => %w", selectErr)
+	}
+
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var c Category
+		err := selectRows.Scan(&c.Name, &c.Count)
+		if err != nil {
+			return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
+		}
+		categories = append(categories, c)
+	}
+
+	return categories, selectRows.Err()
+}
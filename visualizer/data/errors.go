@@ -0,0 +1,13 @@
+package data
+
+import "errors"
+
+// Errors of the DBClient methods the handlers answer with a 4xx, the other errors returned are the DB ones (a 500)
+var (
+	// ErrCursor is returned for a cursor that can't be decoded or doesn't belong to the sort of the search
+	ErrCursor = errors.New("cursor not valid")
+	// ErrNotFound is returned when the record asked doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrUserExists is returned when creating a user whose username is taken
+	ErrUserExists = errors.New("user already exists")
+)
@@ -0,0 +1,124 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+// maxHistogramDays is the max number of days filled with the days without articles,
+// longer histograms show the days with articles only
+const maxHistogramDays = 366
+
+// DayCount is the number of articles published in a day, Percent of the busiest day of the histogram
+type DayCount struct {
+	Day     time.Time
+	Count   int
+	Percent int
+}
+
+// Label returns the day as shown under the bars of the histogram
+func (d DayCount) Label() string {
+	return d.Day.Format("2006-01-02")
+}
+
+// Histogram is the number of articles per day matching a filter, oldest day first
+type Histogram struct {
+	Days []DayCount
+	Max  int
+}
+
+// GetHistogram counts the articles matching the filter per day (in the Timezone),
+// or the story clusters they belong to with Collapse
+func (db *DBClient) GetHistogram(filter Filter) (*Histogram, error) {
+
+	log.Printf("Initiate GetHistogram")
+
+	res := &Histogram{}
+
+	var selectRows *sql.Rows
+	var selectErr error
+
+	where, args, _ := articlesFilter(filter, 2)
+
+	count := "COUNT(*)"
+	if filter.Collapse {
+		count = "COUNT(DISTINCT COALESCE(a.cluster_id, -a.id))"
+	}
+
+	sqlSelect := `SELECT (a.published_at AT TIME ZONE $1)::date AS day, ` + count + `
+	FROM articles a
+	WHERE a.published_at IS NOT NULL AND ` + where + `
+	GROUP BY day
+	ORDER BY day ASC`
+
+	selectRows, selectErr = db.Database.Query(sqlSelect, append([]interface{}{Timezone}, args...)...)
+	if selectErr != nil {
+		return nil, fmt.Errorf("error on SQL SELECT => %w", selectErr)
+	}
+
+	defer selectRows.Close()
+
+	for selectRows.Next() {
+		var d DayCount
+		err := selectRows.Scan(&d.Day, &d.Count)
+		if err != nil {
+			return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
+		}
+		res.Days = append(res.Days, d)
+	}
+	if err := selectRows.Err(); err != nil {
+		return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
+	}
+
+	res.fill()
+
+	return res, nil
+}
+
+// fill adds the days without articles between the first and the last day, and sets the Percent of each day
+func (h *Histogram) fill() {
+
+	if len(h.Days) == 0 {
+		return
+	}
+
+	first, last := h.Days[0].Day, h.Days[len(h.Days)-1].Day
+	// rounded, the days of the DST changes are 23 or 25 hours long in the Timezone
+	if days := int(math.Round(last.Sub(first).Hours()/24)) + 1; days <= maxHistogramDays {
+
+		filled := make([]DayCount, 0, days)
+		next := 0
+		for day := first; !day.After(last); day = day.AddDate(0, 0, 1) {
+			if next < len(h.Days) && h.Days[next].Day.Equal(day) {
+				filled = append(filled, h.Days[next])
+				next++
+				continue
+			}
+			filled = append(filled, DayCount{Day: day})
+		}
+		h.Days = filled
+	}
+
+	for _, d := range h.Days {
+		if d.Count > h.Max {
+			h.Max = d.Count
+		}
+	}
+
+	for i := range h.Days {
+		if h.Max > 0 {
+			h.Days[i].Percent = h.Days[i].Count * 100 / h.Max
+		}
+	}
+}
+
+// Title returns the tooltip of the histogram
+func (h *Histogram) Title() string {
+	if len(h.Days) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s to %s, at most %d a day", h.Days[0].Label(), h.Days[len(h.Days)-1].Label(), h.Max)
+}
@@ -0,0 +1,61 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestHistogramFill(t *testing.T) {
+
+	tests := []struct {
+		name string
+		days []DayCount
+		// days after the fill, with their count and percent, e.g. '2021-11-01:2:50'
+		want []string
+		max  int
+	}{
+		{"empty", nil, nil, 0},
+		{"one day", []DayCount{{Day: day(2021, 11, 1), Count: 3}}, []string{"2021-11-01:3:100"}, 3},
+		{"gaps", []DayCount{{Day: day(2021, 11, 1), Count: 2}, {Day: day(2021, 11, 4), Count: 4}, {Day: day(2021, 11, 5), Count: 3}},
+			[]string{"2021-11-01:2:50", "2021-11-02:0:0", "2021-11-03:0:0", "2021-11-04:4:100", "2021-11-05:3:75"}, 4},
+		{"end of the month", []DayCount{{Day: day(2021, 11, 29), Count: 1}, {Day: day(2021, 12, 2), Count: 1}},
+			[]string{"2021-11-29:1:100", "2021-11-30:0:0", "2021-12-01:0:0", "2021-12-02:1:100"}, 1},
+
+		// the days of the DST changes in the Timezone are 23 and 25 hours long
+		{"DST start", []DayCount{{Day: day(2021, 10, 2), Count: 1}, {Day: day(2021, 10, 5), Count: 2}},
+			[]string{"2021-10-02:1:50", "2021-10-03:0:0", "2021-10-04:0:0", "2021-10-05:2:100"}, 2},
+		{"DST end", []DayCount{{Day: day(2022, 4, 2), Count: 2}, {Day: day(2022, 4, 4), Count: 2}},
+			[]string{"2022-04-02:2:100", "2022-04-03:0:0", "2022-04-04:2:100"}, 2},
+
+		// more than maxHistogramDays from the DST start, 1 hour short of 367 days: the days with articles only
+		{"longer than a year", []DayCount{{Day: day(2021, 10, 3), Count: 1}, {Day: day(2022, 10, 4), Count: 4}},
+			[]string{"2021-10-03:1:25", "2022-10-04:4:100"}, 4},
+	}
+
+	for _, tt := range tests {
+
+		h := &Histogram{Days: tt.days}
+		h.fill()
+
+		var got []string
+		for _, d := range h.Days {
+			got = append(got, fmt.Sprintf("%s:%d:%d", d.Label(), d.Count, d.Percent))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") || h.Max != tt.max {
+			t.Errorf("%s: days %v, max %d; want %v, max %d", tt.name, got, h.Max, tt.want, tt.max)
+		}
+	}
+
+	// a year from the DST start is filled, all the days in the Timezone
+	h := &Histogram{Days: []DayCount{{Day: day(2021, 10, 3), Count: 1}, {Day: day(2022, 10, 3), Count: 1}}}
+	h.fill()
+	if len(h.Days) != maxHistogramDays {
+		t.Fatalf("a year: %d days, want %d", len(h.Days), maxHistogramDays)
+	}
+	for i, d := range h.Days {
+		if d.Day.Hour() != 0 || (i > 0 && d.Day.Equal(h.Days[i-1].Day)) {
+			t.Errorf("a year: day %d is %v", i, d.Day)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package data
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Job is a collector job: the schedule of the config of the collector, what the admins set (see the collector_jobs table)
+// and its feed cursors (see the feed_cursors table)
+type Job struct {
+	Name string
+	// false for the jobs no longer in the config, with feed cursors only
+	Configured bool
+	Schedule   string
+	// set by the admins, replaces Schedule. Empty if none
+	ScheduleOverride string
+	Enabled          bool
+	Feeds            int
+	// newest article stored and last complete run, zero if none
+	NewestArticle time.Time
+	LastRun       time.Time
+}
+
+// GetJobs returns the jobs of the collector and the ones with feed cursors, by name
+func (db *DBClient) GetJobs() ([]Job, error) {
+
+	log.Printf("Initiate GetJobs")
+
+	sqlSelect := `SELECT COALESCE(j.name, c.job), j.name IS NOT NULL, COALESCE(j.schedule, ''), COALESCE(j.schedule_override, ''), 
+	COALESCE(j.enabled, true), COALESCE(c.feeds, 0), c.newest, c.last_run 
+	FROM collector_jobs j 
+	FULL JOIN (SELECT job, COUNT(*) AS feeds, MAX(published_at) AS newest, MAX(updated_at) AS last_run FROM feed_cursors GROUP BY job) c 
+	ON c.job = j.name 
+	ORDER BY 1 ASC`
+
+	rows, err := db.Database.Query(sqlSelect)
+	if err != nil {
+		return nil, fmt.Errorf("error on SQL SELECT => %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		var j Job
+		var newest, lastRun sql.NullTime
+		if err := rows.Scan(&j.Name, &j.Configured, &j.Schedule, &j.ScheduleOverride, &j.Enabled, &j.Feeds, &newest, &lastRun); err != nil {
+			return nil, fmt.Errorf("error on reading SQL SELECT results => %w", err)
+		}
+		j.NewestArticle, j.LastRun = newest.Time, lastRun.Time
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+// ResetJob deletes the feed cursors of the job, so its next run pages through the results up to max_pages.
+// ErrNotFound if the job has none.
+func (db *DBClient) ResetJob(name string) error {
+
+	log.Printf("Initiate ResetJob")
+
+	res, err := db.Database.Exec(`DELETE FROM feed_cursors WHERE job = $1`, name)
+	if err != nil {
+		return fmt.Errorf("error on SQL DELETE => %w", err)
+	}
+
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error on SQL DELETE => %w", err)
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// SetJobEnabled enables or disables the job, the collector reschedules it within a minute.
+// ErrNotFound if the job isn't in the config of the collector.
+func (db *DBClient) SetJobEnabled(name string, enabled bool) error {
+
+	log.Printf("Initiate SetJobEnabled")
+
+	return db.updateJob(`UPDATE collector_jobs SET enabled = $2, updated_at = NOW() WHERE name = $1`, name, enabled)
+}
+
+// SetJobSchedule replaces the schedule of the config of the job, empty to go back to it.
+// The collector reschedules the job within a minute. ErrNotFound if the job isn't in the config of the collector.
+func (db *DBClient) SetJobSchedule(name, schedule string) error {
+
+	log.Printf("Initiate SetJobSchedule")
+
+	return db.updateJob(`UPDATE collector_jobs SET schedule_override = NULLIF($2, ''), updated_at = NOW() WHERE name = $1`, name, schedule)
+}
+
+// updateJob runs the UPDATE of the job by name ($1), ErrNotFound if there's none
+func (db *DBClient) updateJob(sqlUpdate, name string, args ...interface{}) error {
+
+	res, err := db.Database.Exec(sqlUpdate, append([]interface{}{name}, args...)...)
+	if err != nil {
+		return fmt.Errorf("error on SQL UPDATE => %w", err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error on SQL UPDATE => %w", err)
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,332 @@
+package data
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Fields of the search query filters, e.g. 'source:ANSA'
+const (
+	FieldSource = "source"
+	FieldDomain = "domain"
+	FieldAuthor = "author"
+	FieldAfter  = "after"
+	FieldBefore = "before"
+	FieldLang   = "lang"
+)
+
+var queryFields = []string{FieldSource, FieldDomain, FieldAuthor, FieldAfter, FieldBefore, FieldLang}
+
+// dateLayout is the layout of the 'after:' and 'before:' dates
+const dateLayout = "2006-01-02"
+
+// languages of the 'lang:' filter, by code, as stored with the articles (see the countries table)
+var languages = map[string]string{
+	"it": "Italian",
+	"en": "English",
+}
+
+// Query is the AST of a search query like
+//
+//	source:ANSA domain:corriere.it author:"Mario Rossi" after:2021-11-01 before:2021-11-15 lang:it "exact phrase" -excluded
+//
+// All the terms must match, except the filters on the same field (e.g. two sources) that match either value
+// and the words joined by 'or', searched as websearch_to_tsquery does.
+type Query struct {
+	Terms []Term
+}
+
+// Term is a word, a "phrase" or a field:value filter, Negated by a leading '-'
+type Term struct {
+	// one of the Field constants, empty for the words and the phrases
+	Field   string
+	Value   string
+	Phrase  bool
+	Negated bool
+	// the date of 'after:' and 'before:'
+	Date time.Time
+	// offset in the query, for the errors
+	pos int
+}
+
+// ParseError is the error of a malformed search query, Pos is the offset of the term in error
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s (at character %d)", e.Msg, e.Pos+1)
+}
+
+// ParseQuery parses the search query typed in the search box, an empty query matches all the articles.
+// The 'field:' words other than the filters are searched as the other words, the errors are for the
+// malformed values of the filters.
+func ParseQuery(s string) (*Query, error) {
+
+	q := &Query{}
+	runes := []rune(s)
+
+	for i := 0; i < len(runes); {
+
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		start := i
+		term := Term{pos: start}
+
+		if runes[i] == '-' {
+			term.Negated = true
+			i++
+		}
+
+		if i < len(runes) && runes[i] == '"' {
+			value, next, err := readPhrase(runes, i)
+			if err != nil {
+				return nil, err
+			}
+			term.Value, term.Phrase = value, true
+			i = next
+		} else {
+			// a bare word ends at the first space or quote
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && runes[j] != '"' {
+				j++
+			}
+			term.Value = string(runes[i:j])
+			i = j
+
+			// 'Covid:' or 'https://...' are not filters, they're searched as words
+			field, value, ok := splitField(term.Value)
+			if ok && isQueryField(field) {
+				term.Field, term.Value = field, value
+
+				// author:"Mario Rossi"
+				if value == "" && i < len(runes) && runes[i] == '"' {
+					phrase, next, err := readPhrase(runes, i)
+					if err != nil {
+						return nil, err
+					}
+					term.Value = phrase
+					i = next
+				}
+			}
+		}
+
+		if term.Value == "" {
+			if term.Field != "" {
+				return nil, &ParseError{Pos: start, Msg: fmt.Sprintf("missing value of '%s:'", term.Field)}
+			}
+			// a lone '-' or an empty phrase
+			continue
+		}
+
+		if err := term.check(); err != nil {
+			return nil, err
+		}
+
+		q.Terms = append(q.Terms, term)
+	}
+
+	if err := q.checkDates(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// readPhrase reads the quoted text starting at runes[start], returning it and the position after the closing quote
+func readPhrase(runes []rune, start int) (string, int, error) {
+
+	for j := start + 1; j < len(runes); j++ {
+		if runes[j] == '"' {
+			return strings.TrimSpace(string(runes[start+1 : j])), j + 1, nil
+		}
+	}
+
+	return "", 0, &ParseError{Pos: start, Msg: "missing closing quote"}
+}
+
+// splitField splits 'field:value', the field being made of letters only so that words like '12:30' are left as they are
+func splitField(word string) (field, value string, ok bool) {
+
+	i := strings.Index(word, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+
+	for _, r := range word[:i] {
+		if !unicode.IsLetter(r) {
+			return "", "", false
+		}
+	}
+
+	return strings.ToLower(word[:i]), word[i+1:], true
+}
+
+func isQueryField(field string) bool {
+	for _, f := range queryFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// check validates the value of the filters, normalising the dates and the language
+func (t *Term) check() error {
+
+	switch t.Field {
+	case FieldAfter, FieldBefore:
+		if t.Negated {
+			return &ParseError{Pos: t.pos, Msg: fmt.Sprintf("'-%s:' is not supported, use '%s:'", t.Field, opposite(t.Field))}
+		}
+		date, err := time.ParseInLocation(dateLayout, t.Value, location())
+		if err != nil {
+			return &ParseError{Pos: t.pos, Msg: fmt.Sprintf("'%s:%s' is not a date, use YYYY-MM-DD", t.Field, t.Value)}
+		}
+		t.Date = date
+	case FieldLang:
+		language, ok := languages[strings.ToLower(t.Value)]
+		if !ok {
+			for _, name := range languages {
+				if strings.EqualFold(name, t.Value) {
+					language, ok = name, true
+				}
+			}
+		}
+		if !ok {
+			return &ParseError{Pos: t.pos, Msg: fmt.Sprintf("'lang:%s' is not a known language, use it or en", t.Value)}
+		}
+		t.Value = language
+	}
+
+	return nil
+}
+
+func opposite(field string) string {
+	if field == FieldAfter {
+		return FieldBefore
+	}
+	return FieldAfter
+}
+
+// checkDates makes sure the 'after:' dates are before the 'before:' ones, or nothing would match
+func (q *Query) checkDates() error {
+
+	for _, after := range q.Terms {
+		if after.Field != FieldAfter {
+			continue
+		}
+		for _, before := range q.Terms {
+			if before.Field == FieldBefore && !after.Date.Before(before.Date) {
+				return &ParseError{Pos: before.pos, Msg: fmt.Sprintf("'after:%s' must be earlier than 'before:%s'", after.Value, before.Value)}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Empty tells if the query has no terms, matching all the articles
+func (q *Query) Empty() bool {
+	return q == nil || len(q.Terms) == 0
+}
+
+// text returns the words and phrases of the query in the websearch_to_tsquery syntax
+func (q *Query) text() string {
+
+	var words []string
+
+	for _, t := range q.Terms {
+		if t.Field != "" {
+			continue
+		}
+		word := t.Value
+		if t.Phrase {
+			word = `"` + word + `"`
+		}
+		if t.Negated {
+			word = "-" + word
+		}
+		words = append(words, word)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// filter compiles the query into the WHERE conditions on the articles 'a', with the args starting from $first,
+// and the tsquery of the words (empty without words) to rank the articles and highlight the snippets.
+// 'after:' includes the day, 'before:' excludes it (days in the Timezone).
+func (q *Query) filter(first int) (conditions []string, args []interface{}, query string) {
+
+	if q.Empty() {
+		return nil, nil, ""
+	}
+
+	param := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", first+len(args)-1)
+	}
+
+	// the values of the same field match either one, the negated ones none
+	for _, field := range []string{FieldSource, FieldDomain, FieldAuthor, FieldLang} {
+
+		var matches []string
+
+		for _, t := range q.Terms {
+			if t.Field != field {
+				continue
+			}
+
+			var match string
+			switch field {
+			case FieldSource:
+				match = "a.source_id IN (SELECT id FROM sources WHERE lower(name) = lower(" + param(t.Value) + "))"
+			case FieldDomain:
+				match = "a.domain_id IN (SELECT id FROM domains WHERE lower(name) = lower(" + param(t.Value) + "))"
+			case FieldAuthor:
+				match = "coalesce(a.author, '') ILIKE " + param("%"+escapeLike(t.Value)+"%")
+			case FieldLang:
+				match = "lower(a.language) = lower(" + param(t.Value) + ")"
+			}
+
+			if t.Negated {
+				conditions = append(conditions, "NOT "+match)
+			} else {
+				matches = append(matches, match)
+			}
+		}
+
+		if len(matches) > 0 {
+			conditions = append(conditions, "("+strings.Join(matches, " OR ")+")")
+		}
+	}
+
+	for _, t := range q.Terms {
+		switch t.Field {
+		case FieldAfter:
+			conditions = append(conditions, "a.published_at >= "+param(t.Date))
+		case FieldBefore:
+			conditions = append(conditions, "a.published_at < "+param(t.Date))
+		}
+	}
+
+	if search := newTextSearch(q.text()); !search.empty() {
+		n := first + len(args)
+		args = append(args, search.args()...)
+		conditions = append(conditions, search.condition(n))
+		query = search.query(articleConfig, n)
+	}
+
+	return conditions, args, query
+}
+
+// escapeLike escapes the wildcards of ILIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,219 @@
+package data
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// day returns the midnight of the day in the Timezone, as the 'after:' and 'before:' dates
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, location())
+}
+
+// equalTerms compares the terms, the dates by instant
+func equalTerms(got, want []Term) bool {
+
+	if len(got) != len(want) {
+		return false
+	}
+
+	for i := range got {
+		g, w := got[i], want[i]
+		if !g.Date.Equal(w.Date) {
+			return false
+		}
+		g.Date, w.Date, g.pos = time.Time{}, time.Time{}, 0
+		if g != w {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestParseQuery(t *testing.T) {
+
+	tests := []struct {
+		query string
+		want  []Term
+	}{
+		{"", nil},
+		{"   ", nil},
+		{"roma", []Term{{Value: "roma"}}},
+		{"roma -calcio", []Term{{Value: "roma"}, {Value: "calcio", Negated: true}}},
+		{`"serie a" -"serie b"`, []Term{{Value: "serie a", Phrase: true}, {Value: "serie b", Phrase: true, Negated: true}}},
+		// the quotes end the words
+		{`roma"serie a"`, []Term{{Value: "roma"}, {Value: "serie a", Phrase: true}}},
+		// the lone '-' and the empty phrases are dropped
+		{`- "" " " roma`, []Term{{Value: "roma"}}},
+
+		// ** filters **
+		{"source:ANSA", []Term{{Field: FieldSource, Value: "ANSA"}}},
+		{"Source:ANSA -DOMAIN:corriere.it", []Term{{Field: FieldSource, Value: "ANSA"}, {Field: FieldDomain, Value: "corriere.it", Negated: true}}},
+		{`author:"Mario Rossi" roma`, []Term{{Field: FieldAuthor, Value: "Mario Rossi"}, {Value: "roma"}}},
+		{`author:" Mario Rossi "`, []Term{{Field: FieldAuthor, Value: "Mario Rossi"}}},
+		{"lang:it", []Term{{Field: FieldLang, Value: "Italian"}}},
+		{"lang:EN", []Term{{Field: FieldLang, Value: "English"}}},
+		{"lang:italian", []Term{{Field: FieldLang, Value: "Italian"}}},
+		{"after:2021-11-01 before:2021-11-15", []Term{
+			{Field: FieldAfter, Value: "2021-11-01", Date: day(2021, 11, 1)},
+			{Field: FieldBefore, Value: "2021-11-15", Date: day(2021, 11, 15)},
+		}},
+
+		// ** unknown fields are searched as words **
+		{"title:roma", []Term{{Value: "title:roma"}}},
+		{"Covid: 12:30 https://ansa.it", []Term{{Value: "Covid:"}, {Value: "12:30"}, {Value: "https://ansa.it"}}},
+		{":roma", []Term{{Value: ":roma"}}},
+		{`-title:"serie a"`, []Term{{Value: "title:", Negated: true}, {Value: "serie a", Phrase: true}}},
+	}
+
+	for _, tt := range tests {
+
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) error: %v", tt.query, err)
+			continue
+		}
+		if !equalTerms(q.Terms, tt.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.query, q.Terms, tt.want)
+		}
+		if q.Empty() != (len(tt.want) == 0) {
+			t.Errorf("ParseQuery(%q).Empty() = %t", tt.query, q.Empty())
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+
+	tests := []struct {
+		query string
+		pos   int
+		msg   string
+	}{
+		{`"serie a`, 0, "missing closing quote"},
+		{`roma -"serie a`, 6, "missing closing quote"},
+		{`roma author:"Mario Rossi`, 12, "missing closing quote"},
+		{"source:", 0, "missing value of 'source:'"},
+		{"roma -domain:", 5, "missing value of 'domain:'"},
+		{`author:""`, 0, "missing value of 'author:'"},
+		{"roma after:2021-13-01", 5, "'after:2021-13-01' is not a date"},
+		{"before:yesterday", 0, "'before:yesterday' is not a date"},
+		{"roma -after:2021-11-01", 5, "'-after:' is not supported, use 'before:'"},
+		{"-before:2021-11-01", 0, "'-before:' is not supported, use 'after:'"},
+		{"lang:fr", 0, "'lang:fr' is not a known language"},
+		// the error is on the 'before:' of the range
+		{"after:2021-11-15 roma before:2021-11-01", 22, "'after:2021-11-15' must be earlier than 'before:2021-11-01'"},
+		{"before:2021-11-01 after:2021-11-01", 0, "must be earlier"},
+		// the positions are in characters, not bytes
+		{"città lang:fr", 6, "'lang:fr' is not a known language"},
+	}
+
+	for _, tt := range tests {
+
+		_, err := ParseQuery(tt.query)
+		parseErr, ok := err.(*ParseError)
+		if !ok {
+			t.Errorf("ParseQuery(%q) error = %v, want a ParseError", tt.query, err)
+			continue
+		}
+		if parseErr.Pos != tt.pos || !strings.Contains(parseErr.Msg, tt.msg) {
+			t.Errorf("ParseQuery(%q) error = %q at %d, want %q at %d", tt.query, parseErr.Msg, parseErr.Pos, tt.msg, tt.pos)
+		}
+	}
+}
+
+// equalArgs compares the args of the conditions, the dates by instant
+func equalArgs(got, want []interface{}) bool {
+
+	if len(got) != len(want) {
+		return false
+	}
+
+	for i := range got {
+		if date, ok := want[i].(time.Time); ok {
+			if g, ok := got[i].(time.Time); !ok || !g.Equal(date) {
+				return false
+			}
+			continue
+		}
+		if !reflect.DeepEqual(got[i], want[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestQueryFilter(t *testing.T) {
+
+	// the tsquery of the words, in the config of the article
+	const roma = `(websearch_to_tsquery(` + articleConfig + `, $3))`
+
+	tests := []struct {
+		query      string
+		conditions []string
+		args       []interface{}
+		tsquery    string
+	}{
+		{"", nil, nil, ""},
+
+		// the values of the same field match either one, the negated ones none
+		{"source:ANSA source:Reuters -source:Fox", []string{
+			"NOT a.source_id IN (SELECT id FROM sources WHERE lower(name) = lower($5))",
+			"(a.source_id IN (SELECT id FROM sources WHERE lower(name) = lower($3)) OR a.source_id IN (SELECT id FROM sources WHERE lower(name) = lower($4)))",
+		}, []interface{}{"ANSA", "Reuters", "Fox"}, ""},
+
+		// the fields in order: source, domain, author, lang, then the dates
+		{"lang:it domain:ansa.it", []string{
+			"(a.domain_id IN (SELECT id FROM domains WHERE lower(name) = lower($3)))",
+			"(lower(a.language) = lower($4))",
+		}, []interface{}{"ansa.it", "Italian"}, ""},
+		{"before:2021-11-15 after:2021-11-01", []string{
+			"a.published_at < $3",
+			"a.published_at >= $4",
+		}, []interface{}{day(2021, 11, 15), day(2021, 11, 1)}, ""},
+
+		// the wildcards of ILIKE are searched as they are
+		{`author:"50%_off\"`, []string{
+			`(coalesce(a.author, '') ILIKE $3)`,
+		}, []interface{}{`%50\%\_off\\%`}, ""},
+
+		// the words and the phrases, the prefixes apart
+		{"roma", []string{
+			`(a.search_vector @@ (websearch_to_tsquery('english', $3)) OR a.search_vector @@ (websearch_to_tsquery('italian', $3)))`,
+		}, []interface{}{"roma"}, roma},
+		{`roma -calcio "serie a" elect*`, []string{
+			`(a.search_vector @@ (websearch_to_tsquery('english', $3) && to_tsquery('english', $4)) OR a.search_vector @@ (websearch_to_tsquery('italian', $3) && to_tsquery('italian', $4)))`,
+		}, []interface{}{`roma -calcio "serie a"`, "elect:*"}, `(websearch_to_tsquery(` + articleConfig + `, $3) && to_tsquery(` + articleConfig + `, $4))`},
+
+		// the unknown fields are words, after the filters
+		{"title:roma source:ANSA", []string{
+			"(a.source_id IN (SELECT id FROM sources WHERE lower(name) = lower($3)))",
+			`(a.search_vector @@ (websearch_to_tsquery('english', $4)) OR a.search_vector @@ (websearch_to_tsquery('italian', $4)))`,
+		}, []interface{}{"ANSA", "title:roma"}, `(websearch_to_tsquery(` + articleConfig + `, $4))`},
+	}
+
+	for _, tt := range tests {
+
+		q, err := ParseQuery(tt.query)
+		if err != nil {
+			t.Errorf("ParseQuery(%q) error: %v", tt.query, err)
+			continue
+		}
+
+		// the args of the filter follow the first two of the caller
+		conditions, args, tsquery := q.filter(3)
+
+		if !reflect.DeepEqual(conditions, tt.conditions) {
+			t.Errorf("%q: conditions =\n%s\nwant\n%s", tt.query, strings.Join(conditions, "\n"), strings.Join(tt.conditions, "\n"))
+		}
+		if !equalArgs(args, tt.args) {
+			t.Errorf("%q: args = %v, want %v", tt.query, args, tt.args)
+		}
+		if tsquery != tt.tsquery {
+			t.Errorf("%q: tsquery = %s, want %s", tt.query, tsquery, tt.tsquery)
+		}
+	}
+}
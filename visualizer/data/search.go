@@ -0,0 +1,127 @@
+package data
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// text search configs of the articles, see 'search_vector' in db/CreateTables.sql
+var searchConfigs = []string{"'english'", "'italian'"}
+
+// articleConfig is the text search config of the article the row is about
+const articleConfig = `(CASE lower(a.language) WHEN 'italian' THEN 'italian'::regconfig ELSE 'english'::regconfig END)`
+
+// markers of the matches in the snippets, escaped with the rest of the text before becoming <mark> tags
+const (
+	markStart = "\ue000"
+	markStop  = "\ue001"
+)
+
+// snippetOptions are the ts_headline options of the snippets
+var snippetOptions = fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxFragments=2, MaxWords=30, MinWords=12, FragmentDelimiter=" … "`, markStart, markStop)
+
+// prefix terms like 'elect*', not supported by websearch_to_tsquery
+var prefixTerm = regexp.MustCompile(`(^|\s)(-?)([\pL\pN]+)\*`)
+
+// textSearch is the full-text search of the words typed in the search box.
+// Words is the query for websearch_to_tsquery ("phrase", or, -excluded), Prefixes the one for to_tsquery ('elect:*').
+type textSearch struct {
+	Words    string
+	Prefixes string
+}
+
+// newTextSearch splits the prefix terms out of the words, quoted phrases are left as they are
+func newTextSearch(word string) textSearch {
+
+	var t textSearch
+	var prefixes []string
+
+	// the odd parts are inside quotes
+	parts := strings.Split(word, `"`)
+	for i := range parts {
+		if i%2 == 1 {
+			continue
+		}
+		parts[i] = prefixTerm.ReplaceAllStringFunc(parts[i], func(term string) string {
+			m := prefixTerm.FindStringSubmatch(term)
+			not := ""
+			if m[2] == "-" {
+				not = "!"
+			}
+			prefixes = append(prefixes, not+strings.ToLower(m[3])+":*")
+			return m[1]
+		})
+	}
+
+	t.Words = strings.TrimSpace(strings.Join(parts, `"`))
+	t.Prefixes = strings.Join(prefixes, " & ")
+
+	return t
+}
+
+// empty tells if there's nothing to search, e.g. only punctuation was typed
+func (t textSearch) empty() bool {
+	return t.Words == "" && t.Prefixes == ""
+}
+
+// args returns the args of the search, the one of the words first
+func (t textSearch) args() []interface{} {
+
+	var args []interface{}
+
+	if t.Words != "" {
+		args = append(args, t.Words)
+	}
+	if t.Prefixes != "" {
+		args = append(args, t.Prefixes)
+	}
+
+	return args
+}
+
+// query returns the tsquery of the search in the text search config, with the args from $first
+func (t textSearch) query(config string, first int) string {
+
+	var queries []string
+
+	if t.Words != "" {
+		queries = append(queries, fmt.Sprintf("websearch_to_tsquery(%s, $%d)", config, first))
+		first++
+	}
+	if t.Prefixes != "" {
+		queries = append(queries, fmt.Sprintf("to_tsquery(%s, $%d)", config, first))
+	}
+
+	return "(" + strings.Join(queries, " && ") + ")"
+}
+
+// condition returns the WHERE condition of the search, one match per config so the GIN index is used
+func (t textSearch) condition(first int) string {
+
+	var matches []string
+
+	for _, config := range searchConfigs {
+		matches = append(matches, "a.search_vector @@ "+t.query(config, first))
+	}
+
+	return "(" + strings.Join(matches, " OR ") + ")"
+}
+
+// Highlight returns the snippet of the article with the words searched in <mark>,
+// or the description when there's no snippet
+func (a *Article) Highlight() template.HTML {
+
+	text := a.Snippet
+	if text == "" {
+		text = a.Description
+	}
+
+	escaped := html.EscapeString(text)
+	escaped = strings.ReplaceAll(escaped, markStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, markStop, "</mark>")
+
+	return template.HTML(escaped)
+}
@@ -0,0 +1,43 @@
+package data
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// RevokeToken adds the id (jti) of the token to the revoked ones until it expires, dropping the expired ones
+func (db *DBClient) RevokeToken(jti string, expiresAt time.Time) error {
+
+	log.Printf("Initiate RevokeToken")
+
+	_, err := db.Database.Exec(`DELETE FROM revoked_tokens WHERE expires_at < NOW()`)
+	if err != nil {
+		return fmt.Errorf("error on SQL DELETE => %w", err)
+	}
+
+	_, err = db.Database.Exec(`INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT DO NOTHING`, jti, expiresAt)
+	if err != nil {
+		return fmt.Errorf("error on SQL INSERT => %w", err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked tells if the token with the id (jti) was revoked, or the user of the token is disabled, deleted,
+// must change the password or has a newer generation of tokens than the one of the token (see SetUserRole and SetUserDisabled)
+func (db *DBClient) IsTokenRevoked(jti string, userID, generation int) (bool, error) {
+
+	var revoked bool
+
+	sqlSelect := `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1) 
+	OR NOT EXISTS (SELECT 1 FROM users WHERE id = $2 AND token_generation = $3 AND disabled IS FALSE 
+	AND must_change_password IS FALSE)`
+
+	err := db.Database.QueryRow(sqlSelect, jti, userID, generation).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("error on SQL SELECT => %w", err)
+	}
+
+	return revoked, nil
+}
@@ -0,0 +1,278 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Roles of the users, from the least to the most allowed
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Roles are the roles in order, each one allowed what the previous ones are
+var Roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// IsRole checks the role is one of the Roles
+func IsRole(role string) bool {
+	return RoleRank(role) >= 0
+}
+
+// RoleRank returns the position of the role in Roles, -1 if it isn't one
+func RoleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// User is an account of the visualizer, see the users table
+type User struct {
+	ID       int
+	Username string
+	// empty for the single sign-on users, who have an Email instead
+	PasswordHash string
+	Email        string
+	Role         string
+	Disabled     bool
+	FailedLogins int
+	// zero if not locked
+	LockedUntil time.Time
+	// the password must be changed at the next login
+	MustChangePassword bool
+	// the tokens of an older generation are revoked, see IsTokenRevoked
+	TokenGeneration int
+}
+
+// Locked tells if the account is locked at the time, after too many failed logins
+func (u *User) Locked(now time.Time) bool {
+	return now.Before(u.LockedUntil)
+}
+
+// userColumns are the columns scanned into a User by scanUser
+const userColumns = `id, username, password_hash, COALESCE(email, ''), role, disabled, failed_logins, locked_until, must_change_password, token_generation`
+
+// GetUser returns the user by username, ErrNotFound if there's none
+func (db *DBClient) GetUser(username string) (*User, error) {
+
+	log.Printf("Initiate GetUser")
+
+	return scanUser(db.Database.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = $1`, username))
+}
+
+// GetUserByEmail returns the single sign-on user by email, ErrNotFound if there's none
+func (db *DBClient) GetUserByEmail(email string) (*User, error) {
+
+	log.Printf("Initiate GetUserByEmail")
+
+	return scanUser(db.Database.QueryRow(`SELECT `+userColumns+` FROM users WHERE lower(email) = lower($1)`, email))
+}
+
+// scanUser scans the userColumns of the row (a *sql.Row or the current of *sql.Rows), ErrNotFound if there's none
+func scanUser(row interface{ Scan(...interface{}) error }) (*User, error) {
+
+	var u User
+	var lockedUntil sql.NullTime
+
+	err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Email, &u.Role, &u.Disabled, &u.FailedLogins, &lockedUntil, &u.MustChangePassword, &u.TokenGeneration)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error on SQL SELECT => %w", err)
+	}
+
+	u.LockedUntil = lockedUntil.Time
+
+	return &u, nil
+}
+
+// GetUsers returns all the users, by username
+func (db *DBClient) GetUsers() ([]User, error) {
+
+	log.Printf("Initiate GetUsers")
+
+	rows, err := db.Database.Query(`SELECT ` + userColumns + ` FROM users ORDER BY username ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("error on SQL SELECT => %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, *u)
+	}
+
+	return users, rows.Err()
+}
+
+// CountUsers returns the number of users, disabled included
+func (db *DBClient) CountUsers() (int, error) {
+
+	var count int
+
+	err := db.Database.QueryRow(`SELECT COUNT(*) FROM users`).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("error on SQL SELECT => %w", err)
+	}
+
+	return count, nil
+}
+
+// CreateUser inserts the user with the bcrypt hash of the password and the role, ErrUserExists if the username is taken
+func (db *DBClient) CreateUser(username, passwordHash, role string) error {
+
+	log.Printf("Initiate CreateUser")
+
+	_, err := db.Database.Exec(`INSERT INTO users (username, password_hash, role) VALUES ($1, $2, $3)`, username, passwordHash, role)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrUserExists
+	}
+	if err != nil {
+		return fmt.Errorf("error on SQL INSERT => %w", err)
+	}
+
+	return nil
+}
+
+// CreateSSOUser inserts the single sign-on user, named by the email and without a password.
+// ErrUserExists if the email, or a username equal to it, is taken.
+func (db *DBClient) CreateSSOUser(email, role string) (*User, error) {
+
+	log.Printf("Initiate CreateSSOUser")
+
+	row := db.Database.QueryRow(`INSERT INTO users (username, email, role) VALUES ($1, $1, $2) RETURNING `+userColumns, email, role)
+
+	u, err := scanUser(row)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return nil, ErrUserExists
+	}
+
+	return u, err
+}
+
+// SetUserRole sets the role of the user, revoking the tokens with the old one. ErrNotFound if there's none
+func (db *DBClient) SetUserRole(username, role string) error {
+
+	log.Printf("Initiate SetUserRole")
+
+	return db.updateUser(`UPDATE users SET role = $2, token_generation = token_generation + CASE WHEN role = $2 THEN 0 ELSE 1 END, 
+	updated_at = NOW() WHERE username = $1`, username, role)
+}
+
+// AdoptFavourites makes the favourites of the old global flag (domains.favourite) favourites of the user
+func (db *DBClient) AdoptFavourites(username string) error {
+
+	log.Printf("Initiate AdoptFavourites")
+
+	sqlInsert := `INSERT INTO user_favourites (user_id, domain_id) 
+	SELECT u.id, d.id FROM users u, domains d WHERE u.username = $1 AND d.favourite IS TRUE 
+	ON CONFLICT DO NOTHING`
+
+	_, err := db.Database.Exec(sqlInsert, username)
+	if err != nil {
+		return fmt.Errorf("error on SQL INSERT => %w", err)
+	}
+
+	return nil
+}
+
+// SetUserDisabled disables or enables the user, revoking the tokens when disabling. ErrNotFound if there's none
+func (db *DBClient) SetUserDisabled(username string, disabled bool) error {
+
+	log.Printf("Initiate SetUserDisabled")
+
+	return db.updateUser(`UPDATE users SET disabled = $2, token_generation = token_generation + CASE WHEN $2 THEN 1 ELSE 0 END, 
+	updated_at = NOW() WHERE username = $1`, username, disabled)
+}
+
+// SetUserPassword sets the bcrypt hash of the new password, unlocking the user, clearing MustChangePassword
+// and revoking the tokens. ErrNotFound if there's none
+func (db *DBClient) SetUserPassword(username, passwordHash string) error {
+
+	log.Printf("Initiate SetUserPassword")
+
+	return db.updateUser(`UPDATE users SET password_hash = $2, failed_logins = 0, locked_until = NULL, must_change_password = false, 
+	token_generation = token_generation + 1, updated_at = NOW() WHERE username = $1`, username, passwordHash)
+}
+
+// RequirePasswordChange makes the user change the password at the next login, revoking the tokens.
+// ErrNotFound if there's none
+func (db *DBClient) RequirePasswordChange(username string) error {
+
+	log.Printf("Initiate RequirePasswordChange")
+
+	return db.updateUser(`UPDATE users SET must_change_password = true, token_generation = token_generation + 1, 
+	updated_at = NOW() WHERE username = $1`, username)
+}
+
+// RecordLoginFailure counts a failed login of the user: at maxFailures in a row the account is locked for lockFor
+// (and the count starts again after it). It returns the time the account is locked until, zero if not locked.
+func (db *DBClient) RecordLoginFailure(id, maxFailures int, lockFor time.Duration) (time.Time, error) {
+
+	log.Printf("Initiate RecordLoginFailure")
+
+	var lockedUntil sql.NullTime
+
+	sqlUpdate := `UPDATE users SET
+		locked_until = CASE WHEN failed_logins + 1 >= $2 THEN NOW() + $3 * INTERVAL '1 second' ELSE locked_until END,
+		failed_logins = CASE WHEN failed_logins + 1 >= $2 THEN 0 ELSE failed_logins + 1 END
+	WHERE id = $1
+	RETURNING locked_until`
+
+	err := db.Database.QueryRow(sqlUpdate, id, maxFailures, int(lockFor.Seconds())).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error on SQL UPDATE => %w", err)
+	}
+
+	return lockedUntil.Time, nil
+}
+
+// RecordLoginSuccess resets the failed logins of the user
+func (db *DBClient) RecordLoginSuccess(id int) error {
+
+	log.Printf("Initiate RecordLoginSuccess")
+
+	_, err := db.Database.Exec(`UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = $1 AND failed_logins > 0`, id)
+	if err != nil {
+		return fmt.Errorf("error on SQL UPDATE => %w", err)
+	}
+
+	return nil
+}
+
+// updateUser runs the UPDATE of the user by username ($1), ErrNotFound if there's none
+func (db *DBClient) updateUser(sqlUpdate, username string, args ...interface{}) error {
+
+	res, err := db.Database.Exec(sqlUpdate, append([]interface{}{username}, args...)...)
+	if err != nil {
+		return fmt.Errorf("error on SQL UPDATE => %w", err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error on SQL UPDATE => %w", err)
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
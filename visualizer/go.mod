@@ -6,3 +6,5 @@ require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/lib/pq v1.10.3
 )
+
+require golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
@@ -2,40 +2,59 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	_ "github.com/lib/pq"
 	"github.com/mesmerai/news-aggregator/visualizer/data"
+	"github.com/mesmerai/news-aggregator/visualizer/oidc"
 )
 
 // DB Conn Vars
-var environment = getEnv()
-
 var db_port int = 5432
 var db_name string = "news"
 var db_user string = "news_db_user"
 var dbconn_max_retries = 10
+
+// above this estimate the number of articles found is not counted, 0 to always count them
+var approxCountAbove = 10000
+
+// first user, created from USER_AUTH when there are no users yet (see bootstrapUser)
 var web_user = "carmelo"
 
-// ** Secrets from ENV **
-var db_host = environment["db_host"]
-var db_password = environment["db_password"]
+// ** Secrets from ENV, read by main **
+var db_host string
+var db_password string
 
 // Create the JWT Key from  our secret
-var jwtKey = []byte(environment["jwt_key"])
+var jwtKey []byte
+
+// password of the first user
+var web_password string
 
-// user auth
-var web_password = environment["user_auth"]
+// how long a session lasts without requests, see session.go
+var sessionTTL time.Duration
 
-//DB
-var myDB *data.DBClient
+// the token cookie is sent over HTTPS only, unless COOKIE_SECURE=false for the plain HTTP deployments
+var cookieSecure bool
+
+// single sign-on with the OIDC_* env, nil if not configured (see sso.go)
+var sso *oidc.Provider
+var ssoAdminGroups []string
+var ssoEditorGroups []string
+
+//DB, see store.go
+var myDB store
 
 // should get those creds from the login form via POST
 type Credentials struct {
@@ -47,6 +66,12 @@ type Credentials struct {
 // We add jwt.StandardClaims to provide fields like expiry time
 type Claims struct {
 	Username string `json:"username"`
+	// id in the users table
+	UserID int `json:"uid"`
+	// viewer, editor or admin (see roles.go)
+	Role string `json:"role"`
+	// token generation of the user when the token was issued, see data.IsTokenRevoked
+	Generation int `json:"gen"`
 	jwt.StandardClaims
 }
 
@@ -55,43 +80,75 @@ var tmpl = template.Must(template.ParseFiles("./index.html"))
 
 type Data struct {
 	Query           string
-	NextPage        int
+	Collapse        bool
+	Sort            string
+	From            string
+	To              string
+	Period          string
+	Histogram       *data.Histogram
+	Country         string
+	Countries       []data.Country
+	Category        string
+	Categories      []data.Category
+	// number of the page, the first is 1, and the cursors of the pages around it (empty if none)
+	Page            int
+	NextPage        string
+	PreviousPage    string
 	TotalPages      int
 	Results         *data.Results
 	Favourites      *data.FavouriteDomains
 	NotFavourites   *data.NotFavouriteDomains
 	ArticlesPerFeed []data.ArticlePerFeed
+	Quotas          []data.Quota
 	LoggedUser      *LoggedUser
 	Message         string
+	// the login offers the single sign-on
+	SSO bool
+	// the user whose password must be changed at the login, see auth
+	PasswordChange string
+	// for the admins, see roles.go
+	Users []data.User
+	Jobs  []data.Job
+	Roles []string
 }
 
-var pageData Data
-
+// LoggedUser is the user of the request, from the claims of the token (see checkTokenMiddleware)
 type LoggedUser struct {
+	ID         int
 	Username   string
-	TTL        int
+	Role       string
+	// expiry of the token, in Unix seconds
+	ExpiresAt  int64
 	LastAccess int64
 }
 
-var userInfo LoggedUser
+// contextKey is the key of the values the middlewares add to the request context
+type contextKey string
+
+const loggedUserKey contextKey = "loggedUser"
+
+// loggedUser returns the user checkTokenMiddleware added to the request, nil if none
+func loggedUser(r *http.Request) *LoggedUser {
+	user, _ := r.Context().Value(loggedUserKey).(*LoggedUser)
+	return user
+}
 
 // determine if it's LastPage to set the 'Next' button
 func (s *Data) IsLastPage() bool {
-	return s.NextPage >= s.TotalPages
+	return s.NextPage == ""
 }
 
-// get CurrentPage to set the 'Previous' button
-// CurrentPage is always NextPage - 1, except when we have only one page
-func (s *Data) CurrentPage() int {
-	if s.NextPage == 1 {
-		return s.NextPage
-	}
-	return s.NextPage - 1
+// determine if it's FirstPage to set the 'Previous' button
+func (s *Data) IsFirstPage() bool {
+	return s.PreviousPage == ""
 }
 
-// PreviousPage
-func (s *Data) PreviousPage() int {
-	return s.CurrentPage() - 1
+// get CurrentPage for the results count
+func (s *Data) CurrentPage() int {
+	if s.Page < 1 {
+		return 1
+	}
+	return s.Page
 }
 
 /* * Handler function *
@@ -106,46 +163,119 @@ func index(w http.ResponseWriter, r *http.Request) {
 	// log the request
 	log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
 
-	// some vars declared
-	var err error
-	var favResults *data.FavouriteDomains
-	var notFavResults *data.NotFavouriteDomains
-	var favCount, notFavCount int
+	page, err := newPageData(r)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	render(w, http.StatusOK, page)
+
+}
 
-	var articlesPerFeed []data.ArticlePerFeed
+// newPageData returns the view model of the page for the request: the menus, the search filter and the logged user.
+// Each request builds its own, as the handlers run concurrently.
+func newPageData(r *http.Request) (*Data, error) {
 
-	// ** retrieve Feeds to populate the menu on the left side **
+	user := loggedUser(r)
 
-	favCount = myDB.CountFavouriteDomains()
-	favResults = myDB.GetFavouriteDomains()
-	favResults.Count = favCount
+	// ** retrieve Feeds of the user to populate the menu on the left side **
 
-	notFavCount = myDB.CountNotFavouriteDomains()
-	notFavResults = myDB.GetNotFavouriteDomains()
-	notFavResults.Count = notFavCount
+	favResults, err := myDB.GetFavouriteDomains(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if favResults.Count, err = myDB.CountFavouriteDomains(user.ID); err != nil {
+		return nil, err
+	}
+
+	notFavResults, err := myDB.GetNotFavouriteDomains(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if notFavResults.Count, err = myDB.CountNotFavouriteDomains(user.ID); err != nil {
+		return nil, err
+	}
 
 	// ** articlesPerFeed for the menu on the right **
+	articlesPerFeed, err := myDB.CountArticlesGroupByFavourites(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// ** API calls left for the menu on the right **
+	quotas, err := myDB.GetQuotas()
+	if err != nil {
+		return nil, err
+	}
+
+	// ** countries of the search filter **
+	countries, err := myDB.GetCountries()
+	if err != nil {
+		return nil, err
+	}
 
-	articlesPerFeed = myDB.CountArticlesGroupByFavourites() // should return a []ArticlePerFeed having .FeedName and .ArticlesCount
+	// ** categories of all the countries, the search shows the ones of its country **
+	categories, err := myDB.GetCategories("")
+	if err != nil {
+		return nil, err
+	}
+
+	// ** users and collector jobs for the admin menu **
+	var users []data.User
+	var jobs []data.Job
+	if user.IsAdmin() {
+		if users, err = myDB.GetUsers(); err != nil {
+			log.Println("Error reading the users => ", err)
+		}
+		if jobs, err = myDB.GetJobs(); err != nil {
+			log.Println("Error reading the jobs => ", err)
+		}
+	}
 
-	thisData := &pageData
-	*thisData = Data{
-		Query:           pageData.Query,
-		NextPage:        pageData.NextPage,
-		TotalPages:      pageData.TotalPages,
-		Results:         pageData.Results,
+	return &Data{
+		Sort:            "date",
+		Country:         "Global",
+		Countries:       countries,
+		Categories:      categories,
 		Favourites:      favResults,
 		NotFavourites:   notFavResults,
 		ArticlesPerFeed: articlesPerFeed,
-		LoggedUser:      pageData.LoggedUser,
-		Message:         thisData.Message,
+		Quotas:          quotas,
+		LoggedUser:      user,
+		Users:           users,
+		Jobs:            jobs,
+		Roles:           data.Roles,
+	}, nil
+}
+
+// renderMessage writes the page of the user with the message
+func renderMessage(w http.ResponseWriter, r *http.Request, status int, message string) {
+
+	page, err := newPageData(r)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
 	}
 
+	page.Message = message
+	render(w, status, page)
+}
+
+// writeInternalError logs the error of the request and answers 500 with a generic message
+func writeInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	log.Printf("Error answering %s %s => %v", r.Method, r.URL.Path, err)
+	http.Error(w, "Internal error, try again later", http.StatusInternalServerError)
+}
+
+// render writes the page, executing the template in a buffer first not to send half a page on error
+func render(w http.ResponseWriter, status int, page *Data) {
+
 	// define empty intermediate buffer
 	buffer := &bytes.Buffer{}
 
 	// write to intermediate buffer to check errors
-	err = tmpl.Execute(buffer, thisData)
+	err := tmpl.Execute(buffer, page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -153,8 +283,18 @@ func index(w http.ResponseWriter, r *http.Request) {
 
 	// Then the buffer is written to the ResponseWriter
 	// func (r *Reader) WriteTo(w io.Writer) (n int64, err error)
+	w.WriteHeader(status)
 	buffer.WriteTo(w)
+}
+
+// renderLogin writes the login page with the message, if any
+func renderLogin(w http.ResponseWriter, status int, message string) {
+	render(w, status, &Data{Message: message, SSO: sso != nil})
+}
 
+// renderPasswordChange prints the login asking the new password of the user too
+func renderPasswordChange(w http.ResponseWriter, status int, username, message string) {
+	render(w, status, &Data{Message: message, PasswordChange: username})
 }
 
 func auth(w http.ResponseWriter, r *http.Request) {
@@ -163,7 +303,8 @@ func auth(w http.ResponseWriter, r *http.Request) {
 
 	// Call ParseForm() to parse the raw query and update r.PostForm and r.Form.
 	if err := r.ParseForm(); err != nil {
-		log.Fatal("Error Parsing the Form: ", err)
+		w.WriteHeader(http.StatusBadRequest)
+		log.Println("Error Parsing the Form: ", err)
 		return
 	}
 
@@ -172,106 +313,59 @@ func auth(w http.ResponseWriter, r *http.Request) {
 	thisUser := r.FormValue("username")
 	thisPasswd := r.FormValue("password")
 
-	if web_password == "" {
-		log.Fatal("Password cannot be blank.")
-		w.WriteHeader(http.StatusUnauthorized)
+	// check the password against the users table, see users.go
+	user, err := authenticate(thisUser, thisPasswd, time.Now())
+	if errors.Is(err, errWrongCredentials) {
+		log.Printf("Login of '%s' refused => %v", thisUser, err)
 
-		// Clear up the data struct
-		thisData1 := &pageData
-		*thisData1 = Data{}
+		// ** Print the login instead of redirect **
+		renderLogin(w, http.StatusUnauthorized, "Wrong Username or Password.")
+		return
+	}
+	if err != nil {
+		log.Println("Error checking the login => ", err)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	if thisUser != web_user || thisPasswd != web_password {
-		message := "Wrong Username or Password."
-		log.Println(message)
-		w.WriteHeader(http.StatusUnauthorized)
-
-		// ** Print the login instead of redirect **
-		var err error
-		thisData1 := &pageData
-
-		// Clear up the data struct first
-		*thisData1 = Data{}
-
-		// set the message only
-		*thisData1 = Data{
-			Query:           pageData.Query,
-			NextPage:        pageData.NextPage,
-			TotalPages:      pageData.TotalPages,
-			Results:         pageData.Results,
-			Favourites:      pageData.Favourites,
-			NotFavourites:   pageData.NotFavourites,
-			ArticlesPerFeed: pageData.ArticlesPerFeed,
-			LoggedUser:      pageData.LoggedUser,
-			Message:         message,
+	// no session until the password is changed, see bootstrapUser
+	if user.MustChangePassword {
+		newPasswd := r.FormValue("new_password")
+		if newPasswd == "" {
+			renderPasswordChange(w, http.StatusOK, user.Username, "Choose a new password to log in.")
+			return
 		}
-
-		buffer := &bytes.Buffer{}
-		// NOTE that I'm passing nil instead of pageData
-		err = tmpl.Execute(buffer, thisData1)
+		if newPasswd == thisPasswd {
+			renderPasswordChange(w, http.StatusBadRequest, user.Username, "The new password must be different from the current one.")
+			return
+		}
+		hash, err := hashPassword(newPasswd)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			renderPasswordChange(w, http.StatusBadRequest, user.Username, "New password not valid: "+err.Error()+".")
 			return
 		}
-		buffer.WriteTo(w)
-		// ** End Printing Login **
-
-		return
-	}
-
-	// 5 minutes expiration time for our token
-	expirationTime := time.Now().Add(5 * time.Minute)
-
-	// Create the JWT claims, which includes the username and expiry time
-	claims := &Claims{
-		Username: thisUser,
-		StandardClaims: jwt.StandardClaims{
-			// in JWT expire time is expressed in Unix milliseconds
-			ExpiresAt: expirationTime.Unix(),
-		},
+		if err := myDB.SetUserPassword(user.Username, hash); err != nil {
+			log.Println("Error changing the password => ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// the session is of the new token generation
+		if user, err = myDB.GetUser(user.Username); err != nil {
+			log.Println("Error changing the password => ", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		log.Printf("User '%s' changed the password.", user.Username)
 	}
 
-	// declare the token with Signign algorithm and claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// create the Token String
-	tokenString, err := token.SignedString(jwtKey)
-	if err != nil {
+	// set the client cookie with the token, expiring after sessionTTL (see session.go)
+	if _, err := startSession(w, user, time.Now()); err != nil {
 		// raise an Internal Server Error if there's any error creating the JWT
+		log.Println("Error creating the token => ", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
-	// finally set the client cookie with the token and same expiration time
-	http.SetCookie(w, &http.Cookie{
-		Name:    "token",
-		Value:   tokenString,
-		Expires: expirationTime,
-	})
-
-	// first we set the LoggedUser with TTL and last access
-	thisLoggedUser := &userInfo
-	*thisLoggedUser = LoggedUser{
-		Username:   thisUser,
-		TTL:        int(expirationTime.Unix()),
-		LastAccess: time.Now().Unix(),
-	}
-
-	// set the LoggedUser in the pageData for the template logic
-	thisData := &pageData
-	*thisData = Data{
-		Query:           pageData.Query,
-		NextPage:        pageData.NextPage,
-		TotalPages:      thisData.TotalPages,
-		Results:         pageData.Results,
-		Favourites:      pageData.Favourites,
-		NotFavourites:   pageData.NotFavourites,
-		ArticlesPerFeed: pageData.ArticlesPerFeed,
-		LoggedUser:      thisLoggedUser,
-		Message:         pageData.Message,
-	}
-
 	log.Println("Token set.")
 	log.Println("Redirecting to main page.")
 	http.Redirect(w, r, "/", http.StatusFound)
@@ -296,10 +390,13 @@ func addFeeds(w http.ResponseWriter, r *http.Request) {
 	params := u.Query()
 	feeds := params["afeed"]
 
-	myDB.SetFavourites(feeds)
+	if err := myDB.SetFavourites(loggedUser(r).ID, feeds); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
 
-	// redirect to root
-	http.Redirect(w, r, "/", http.StatusFound)
+	// redirect to the page of the form
+	http.Redirect(w, r, backTo(r), http.StatusFound)
 
 }
 
@@ -321,12 +418,31 @@ func saveFeeds(w http.ResponseWriter, r *http.Request) {
 	params := u.Query()
 	feeds := params["sfeed"]
 
-	myDB.ResetFavourites()
-	myDB.SetFavourites(feeds)
+	// only the favourites of this user change
+	user := loggedUser(r)
+	if err := myDB.ResetFavourites(user.ID); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	if err := myDB.SetFavourites(user.ID, feeds); err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+
+	// redirect to the page of the form
+	http.Redirect(w, r, backTo(r), http.StatusFound)
 
-	// redirect to root
-	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// backTo returns the page to go back to after a form: the search the user was on, if any, or the root
+func backTo(r *http.Request) string {
 
+	referer, err := url.Parse(r.Referer())
+	if err != nil || referer.Host != r.Host || referer.Path != "/search" {
+		return "/"
+	}
+
+	return referer.RequestURI()
 }
 
 func search(w http.ResponseWriter, r *http.Request) {
@@ -337,7 +453,6 @@ func search(w http.ResponseWriter, r *http.Request) {
 	// some vars declared
 	var err error
 	var results *data.Results
-	var count int
 
 	// Package url parses URLs and implements query escaping ==> http://localhost:8080/search?q=ciccio
 	u, err := url.Parse(r.URL.String())
@@ -347,128 +462,292 @@ func search(w http.ResponseWriter, r *http.Request) {
 	}
 
 	params := u.Query()
-	searchQuery := params.Get("q")
-	page := params.Get("page")
-	limit := params.Get("limit")
-	country := params.Get("country")
 
-	// set defaults if param is missing
-	if page == "" {
-		page = "1"
+	// the menus and the logged user, the search below
+	page, err := newPageData(r)
+	if err != nil {
+		writeInternalError(w, r, err)
+		return
+	}
+	status := http.StatusOK
+
+	// the query and the dates typed are reported in the page, the other params are set by the form
+	var message string
+	var histogram *data.Histogram
+	req, err := parseSearch(params, page.Countries, time.Now())
+	var paramErr *paramError
+	if errors.As(err, &paramErr) && !paramErr.typed() {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	if limit == "" {
-		limit = "100"
+	if err != nil {
+		message = err.Error()
+		status = http.StatusBadRequest
+		results = &data.Results{}
+	} else {
+		results, err = myDB.GetArticles(req.Limit, req.Cursor, req.Filter, req.ByRelevance)
+		if errors.Is(err, data.ErrCursor) {
+			http.Error(w, "Not a valid cursor: "+req.Cursor, http.StatusBadRequest)
+			return
+		}
+		if err == nil {
+			results.TotalResults, results.Approximate, err = myDB.CountArticles(req.Filter, approxCountAbove)
+		}
+		if err != nil {
+			log.Printf("Error searching the articles => %v", err)
+			http.Error(w, "Error searching the articles, try again later", http.StatusInternalServerError)
+			return
+		}
+		// the page is still useful without the histogram
+		histogram, err = myDB.GetHistogram(req.Filter)
+		if err != nil {
+			log.Printf("Error on the histogram of the articles => %v", err)
+		}
 	}
 
-	if country == "" {
-		country = "Global"
+	// calculate total pages
+	var tot int
+	mod := results.TotalResults % req.Limit
+	if mod == 0 {
+		tot = results.TotalResults / req.Limit
+	} else {
+		tot = (results.TotalResults / req.Limit) + 1
 	}
 
-	// convert to int some params
-	limitToInt, err := strconv.Atoi(limit)
+	// We save our results into the page
+	// so that we can use it for Pagination
+	page.Query = req.Query
+	page.Collapse = req.Collapse
+	page.Sort = req.Sort
+	page.From = req.From
+	page.To = req.To
+	page.Period = req.Period
+	page.Histogram = histogram
+	page.Country = req.Country
+	page.Category = req.Category
+	page.Categories, err = myDB.GetCategories(countryFilter(req.Country))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeInternalError(w, r, err)
 		return
 	}
+	page.Page = results.Page
+	page.NextPage = results.NextCursor
+	page.PreviousPage = results.PreviousCursor
+	page.TotalPages = tot
+	page.Results = results
+	page.Message = message
 
-	pageToInt, err := strconv.Atoi(page)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	render(w, status, page)
+
+}
+
+// maxLimit is the max number of articles of a page
+const maxLimit = 1000
+
+// searchRequest is the search of the articles asked by the params of /search and /api/v1/articles
+type searchRequest struct {
+	// the params as sent, for the form and the links of the pages
+	Query    string
+	Cursor   string
+	Country  string
+	Category string
+	Sort     string
+	From     string
+	To       string
+	Period   string
+	Collapse bool
+	Limit    int
+
+	Filter      data.Filter
+	ByRelevance bool
+}
+
+// paramError is a search param not valid
+type paramError struct {
+	Param string
+	Msg   string
+}
+
+func (e *paramError) Error() string {
+	return e.Msg
+}
+
+// typed tells if the param is typed by the user (the query and the dates) rather than set by the form
+func (e *paramError) typed() bool {
+	return e.Param == "q" || e.Param == "from" || e.Param == "to"
+}
+
+// parseSearch reads the params of a search:
+//   - q: words and filters, see data.ParseQuery
+//   - country: 'Global' (default) or one of the countries
+//   - category: one of the top headlines categories, see ncollector config
+//   - sort: 'date' (default) or 'relevance' to the words searched
+//   - from, to, period: the published dates, see dateRange
+//   - collapse: 'true' for one article per story cluster, see ncollector/cluster
+//   - limit (default 100) and cursor: the page, see data.GetArticles
+//
+// The request is returned with the *paramError too, to show the params in the form.
+func parseSearch(params url.Values, countries []data.Country, now time.Time) (*searchRequest, error) {
+
+	req := &searchRequest{
+		Query:    params.Get("q"),
+		Cursor:   params.Get("cursor"),
+		Country:  params.Get("country"),
+		Category: params.Get("category"),
+		Sort:     params.Get("sort"),
+		From:     params.Get("from"),
+		To:       params.Get("to"),
+		Period:   params.Get("period"),
+		Collapse: params.Get("collapse") == "true",
+		Limit:    100,
 	}
 
-	//limit := 100
-	offset := (pageToInt * limitToInt) - limitToInt
+	// set defaults if param is missing
+	if req.Country == "" {
+		req.Country = "Global"
+	}
 
-	// call Global Search
-	switch {
-	case country == "Global":
-		if searchQuery == "" {
-			count = myDB.CountArticles("")
-			results = myDB.GetArticles(limitToInt, offset, "")
-		} else {
-			count = myDB.CountArticles(searchQuery)
-			results = myDB.GetArticles(limitToInt, offset, searchQuery)
-		}
-		results.TotalResults = count
-	case country == "Australia":
-		if searchQuery == "" {
-			count = myDB.CountArticlesByCountry(country, "")
-			results = myDB.GetArticlesByCountry(limitToInt, offset, country, "")
-		} else {
-			count = myDB.CountArticlesByCountry(country, searchQuery)
-			results = myDB.GetArticlesByCountry(limitToInt, offset, country, searchQuery)
+	if req.Sort == "" {
+		req.Sort = "date"
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		limitToInt, err := strconv.Atoi(limit)
+		if err != nil || limitToInt < 1 || limitToInt > maxLimit {
+			return req, &paramError{Param: "limit", Msg: "Not a valid limit: " + limit}
 		}
-		results.TotalResults = count
-	case country == "Italy":
-		if searchQuery == "" {
-			count = myDB.CountArticlesByCountry(country, "")
-			results = myDB.GetArticlesByCountry(limitToInt, offset, country, "")
-		} else {
-			count = myDB.CountArticlesByCountry(country, searchQuery)
-			results = myDB.GetArticlesByCountry(limitToInt, offset, country, searchQuery)
+		req.Limit = limitToInt
+	}
+
+	if req.Country != "Global" && !isCountry(countries, req.Country) {
+		return req, &paramError{Param: "country", Msg: "Not a valid country: " + req.Country}
+	}
+
+	if req.Sort != "date" && req.Sort != "relevance" {
+		return req, &paramError{Param: "sort", Msg: "Not a valid sort: " + req.Sort}
+	}
+	req.ByRelevance = req.Sort == "relevance"
+
+	if req.Category != "" && !isCategory(req.Category) {
+		return req, &paramError{Param: "category", Msg: "Not a valid category: " + req.Category}
+	}
+
+	if req.Period != "" && !isPeriod(req.Period) {
+		return req, &paramError{Param: "period", Msg: "Not a valid period: " + req.Period}
+	}
+
+	// source:, domain:, author:, after:, before:, lang:, words and "phrases"
+	query, err := data.ParseQuery(req.Query)
+	if err != nil {
+		return req, &paramError{Param: "q", Msg: "Search not valid: " + err.Error()}
+	}
+
+	publishedFrom, publishedTo, err := dateRange(req.Period, req.From, req.To, now)
+	if err != nil {
+		return req, &paramError{Param: "from", Msg: "Dates not valid: " + err.Error()}
+	}
+
+	req.Filter = data.Filter{
+		Country:  countryFilter(req.Country),
+		Category: req.Category,
+		Query:    query,
+		From:     publishedFrom,
+		To:       publishedTo,
+		Collapse: req.Collapse,
+	}
+
+	return req, nil
+}
+
+// periods of the date shortcuts, see dateRange
+var periods = []string{"today", "24h", "week"}
+
+// isPeriod checks the period is one of the date shortcuts
+func isPeriod(period string) bool {
+	for _, p := range periods {
+		if p == period {
+			return true
 		}
-		results.TotalResults = count
-	default:
-		log.Fatal("Not a valid search parameter")
 	}
+	return false
+}
+
+// dateRange returns the published_at range of the search, zero times for no limit:
+// the period ('today' since midnight, '24h' the last 24 hours, 'week' since Monday) if any,
+// otherwise the from and to days (YYYY-MM-DD, both included).
+// The days are in the timezone of the dates shown, see data.Timezone.
+func dateRange(period, from, to string, now time.Time) (time.Time, time.Time, error) {
 
-	// we convert page into int first
-	nextPage, err := strconv.Atoi(page)
+	var start, end time.Time
+
+	loc, err := time.LoadLocation(data.Timezone)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return start, end, err
 	}
 
-	// calculate total pages
-	var tot int
-	mod := results.TotalResults % limitToInt
-	if mod == 0 {
-		tot = results.TotalResults / limitToInt
-	} else {
-		tot = (results.TotalResults / limitToInt) + 1
+	now = now.In(loc)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	switch period {
+	case "today":
+		return midnight, end, nil
+	case "24h":
+		return now.Add(-24 * time.Hour), end, nil
+	case "week":
+		// Monday is the first day of the week
+		return midnight.AddDate(0, 0, -((int(now.Weekday()) + 6) % 7)), end, nil
 	}
 
-	// We save our results into the Search struct defined above
-	// so that we can use it for Pagination
+	if from != "" {
+		start, err = time.ParseInLocation("2006-01-02", from, loc)
+		if err != nil {
+			return start, end, fmt.Errorf("'%s' is not a date, use YYYY-MM-DD", from)
+		}
+	}
 
-	thisData := &pageData
-	*thisData = Data{
-		Query:           searchQuery,
-		NextPage:        nextPage,
-		TotalPages:      tot,
-		Results:         results,
-		Favourites:      pageData.Favourites,
-		NotFavourites:   pageData.NotFavourites,
-		ArticlesPerFeed: pageData.ArticlesPerFeed,
-		LoggedUser:      pageData.LoggedUser,
-		Message:         thisData.Message,
+	if to != "" {
+		end, err = time.ParseInLocation("2006-01-02", to, loc)
+		if err != nil {
+			return start, end, fmt.Errorf("'%s' is not a date, use YYYY-MM-DD", to)
+		}
+		// the whole day
+		end = end.AddDate(0, 0, 1)
 	}
 
-	// this block is to increment NextPage
-	if !thisData.IsLastPage() {
-		thisData.NextPage++
+	if !start.IsZero() && !end.IsZero() && !start.Before(end) {
+		return start, end, fmt.Errorf("from %s is later than to %s", from, to)
 	}
 
-	// Intermediatie empty byte buffer where the Template is execute first to check errors
-	// a Buffer is a struct and needs no initialization - ref. https://pkg.go.dev/bytes#Buffer
-	buffer := &bytes.Buffer{}
+	return start, end, nil
+}
 
-	// We write the template to to the empty byte.Buffer passing the the 'search' data object
-	// func (t *Template) Execute(wr io.Writer, data interface{}) error
-	// Execute applies a parsed template to the specified data object, writing the output to wr.
-	// If an error occurs executing the template or writing its output, execution stops, but partial results may already have been written to the output writer.
-	err = tmpl.Execute(buffer, thisData)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// isCategory checks the category is one of the top headlines categories
+func isCategory(category string) bool {
+	for _, c := range data.Categories {
+		if c == category {
+			return true
+		}
 	}
+	return false
+}
 
-	// Then the buffer is written to the ResponseWriter
-	// func (r *Reader) WriteTo(w io.Writer) (n int64, err error)
-	buffer.WriteTo(w)
+// countryFilter returns the country to filter the articles on, empty for 'Global'
+func countryFilter(country string) string {
+	if country == "Global" {
+		return ""
+	}
+	return country
+}
 
+// isCountry checks the country name is one of the countries in the DB
+func isCountry(countries []data.Country, name string) bool {
+	for _, c := range countries {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
 func checkTokenMiddleware(next http.Handler) http.Handler {
@@ -477,30 +756,14 @@ func checkTokenMiddleware(next http.Handler) http.Handler {
 		// ** Authentication Check **
 
 		// get the token from the cookie, that comes at every request
-		c, cookieErr := r.Cookie("token")
+		c, cookieErr := r.Cookie(tokenCookie)
 		if cookieErr != nil {
 			if cookieErr == http.ErrNoCookie {
 				// Not Authorized
-				w.WriteHeader(http.StatusUnauthorized)
 				log.Printf("Unauthorized Access => %s", cookieErr)
 
 				// ** Print the login instead of redirect **
-				var err error
-				thisData1 := &pageData
-
-				// Clear up the data struct first (howver it's passing nil)
-				*thisData1 = Data{}
-
-				buffer := &bytes.Buffer{}
-				// NOTE that I'm passing nil instead of pageData
-				err = tmpl.Execute(buffer, nil)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				buffer.WriteTo(w)
-				// ** End Printing Login **
-
+				renderLogin(w, http.StatusUnauthorized, "")
 				return
 			}
 			// For any other err, it's Bad Request
@@ -509,107 +772,28 @@ func checkTokenMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		// get the token from the Cookie
-		tokenStr := c.Value
-
-		// Initialize an instance of Claims
-		claims := &Claims{}
+		// get the token from the Cookie: expired, revoked at the logout or not signed by us
+		user, tknErr := checkSession(w, c.Value, time.Now())
+		if errors.Is(tknErr, errInvalidToken) {
+			message := "Session expired. Authentication required."
+			log.Printf("Unauthorized Access => %s", tknErr)
 
-		// Parse the JWT string and store it in claims
-		// We pass the key as well
-		// this method will return error if token is expired or key doesn't match
-		tkn, tknErr := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtKey, nil
-		})
-
-		if tknErr != nil {
-			if tknErr == jwt.ErrSignatureInvalid {
-				w.WriteHeader(http.StatusUnauthorized)
-				log.Printf("Token Signature Invalid => %s", tknErr)
-
-				message := "Token Signature Invalid. Authentication required."
-				log.Println(message)
-
-				// ** Print the login instead of redirect **
-				var err error
-				thisData1 := &pageData
-
-				// Clear up the data struct first
-				*thisData1 = Data{}
-
-				// set message only
-				*thisData1 = Data{
-					Query:           pageData.Query,
-					NextPage:        pageData.NextPage,
-					TotalPages:      pageData.TotalPages,
-					Results:         pageData.Results,
-					Favourites:      pageData.Favourites,
-					NotFavourites:   pageData.NotFavourites,
-					ArticlesPerFeed: pageData.ArticlesPerFeed,
-					LoggedUser:      pageData.LoggedUser,
-					Message:         message,
-				}
-
-				buffer := &bytes.Buffer{}
-				// NOTE that I'm passing nil instead of pageData
-				err = tmpl.Execute(buffer, nil)
-				if err != nil {
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
-				buffer.WriteTo(w)
-				// ** End Printing Login **
+			// the cookie is of no use anymore
+			setTokenCookie(w, "", time.Unix(0, 0))
 
-				return
-			}
-			w.WriteHeader(http.StatusBadRequest)
-			log.Println("Bad Request: ", cookieErr)
+			// ** Print the login instead of redirect **
+			renderLogin(w, http.StatusUnauthorized, message)
 			return
 		}
-
-		if !tkn.Valid {
-			w.WriteHeader(http.StatusUnauthorized)
-			message := "Token isn't valid. Authentication required."
-			log.Println(message)
-
-			// ** Print the login instead of redirect **
-			var err error
-			thisData1 := &pageData
-
-			// Clear up the data struct first
-			*thisData1 = Data{}
-
-			// set message only
-			*thisData1 = Data{
-				Query:           pageData.Query,
-				NextPage:        pageData.NextPage,
-				TotalPages:      pageData.TotalPages,
-				Results:         pageData.Results,
-				Favourites:      pageData.Favourites,
-				NotFavourites:   pageData.NotFavourites,
-				ArticlesPerFeed: pageData.ArticlesPerFeed,
-				LoggedUser:      pageData.LoggedUser,
-				Message:         message,
-			}
-
-			buffer := &bytes.Buffer{}
-			// NOTE that I'm passing nil instead of pageData
-			err = tmpl.Execute(buffer, nil)
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
-			buffer.WriteTo(w)
-			// ** End Printing Login **
-
+		if tknErr != nil {
+			log.Println("Error checking the token => ", tknErr)
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		// >> At this stage can print a Welcome message to the user <<
-
 		// ** END Authentication Check **
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), loggedUserKey, user)))
 	})
 }
 
@@ -626,29 +810,61 @@ func getEnv() map[string]string {
 		log.Fatal("Password for the DB is not set in ENV.")
 	}
 	jwt_key := os.Getenv("JWT_KEY")
-	if db_password == "" {
+	if jwt_key == "" {
 		log.Fatal("JWT_KEY is not set in ENV.")
 	}
+	// required until the first user is created, see bootstrapUser
 	user_auth := os.Getenv("USER_AUTH")
-	if db_password == "" {
-		log.Fatal("USER_AUTH is not set in ENV.")
+	// optional
+	session_ttl := os.Getenv("SESSION_TTL")
+	cookie_secure := os.Getenv("COOKIE_SECURE")
+	// single sign-on, optional
+	for _, name := range []string{"OIDC_ISSUER", "OIDC_CLIENT_ID", "OIDC_CLIENT_SECRET", "OIDC_REDIRECT_URL", "OIDC_SCOPES", "OIDC_ADMIN_GROUPS", "OIDC_EDITOR_GROUPS"} {
+		envMap[strings.ToLower(name)] = os.Getenv(name)
 	}
 
 	envMap["db_host"] = db_host
 	envMap["db_password"] = db_password
 	envMap["jwt_key"] = jwt_key
 	envMap["user_auth"] = user_auth
+	envMap["session_ttl"] = session_ttl
+	envMap["cookie_secure"] = cookie_secure
 
 	return envMap
 }
 
 func main() {
 
+	environment := getEnv()
+	db_host = environment["db_host"]
+	db_password = environment["db_password"]
+	jwtKey = []byte(environment["jwt_key"])
+	web_password = environment["user_auth"]
+	sessionTTL = parseSessionTTL(environment["session_ttl"])
+	cookieSecure = environment["cookie_secure"] != "false"
+	sso = newSSO(environment)
+	ssoAdminGroups = splitGroups(environment["oidc_admin_groups"])
+	ssoEditorGroups = splitGroups(environment["oidc_editor_groups"])
+
 	/* ** DB Conn ** */
-	myDB = data.NewDBClient(db_host, db_port, db_name, db_user, db_password, dbconn_max_retries)
+	dbClient := data.NewDBClient(db_host, db_port, db_name, db_user, db_password, dbconn_max_retries)
+	myDB = dbClient
 
 	// myDB = *DBClient(db_conn)
-	defer myDB.Database.Close()
+	defer dbClient.Database.Close()
+
+	// admin command instead of the server, e.g. 'visualizer user create alice'
+	if len(os.Args) > 1 {
+		if os.Args[1] != "user" {
+			log.Fatal("Unknown command: ", os.Args[1])
+		}
+		if err := runUserCommand(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	bootstrapUser()
 
 	log.Println("Closing DB resources.")
 
@@ -667,6 +883,11 @@ func main() {
 
 	//mux.HandleFunc("/login", login)
 	mux.HandleFunc("/auth", auth)
+	mux.HandleFunc("/logout", logout)
+	if sso != nil {
+		mux.HandleFunc("/oidc/login", ssoLogin)
+		mux.HandleFunc("/oidc/callback", ssoCallback)
+	}
 
 	// static files Handle
 	// use Handle because the http.FileServer() method returns an http.Handler type instead of an HandlerFunc
@@ -682,11 +903,18 @@ func main() {
 
 	//mux.HandleFunc("/addFeeds", addFeedsHandler)
 	addFeedsHandler := http.HandlerFunc(addFeeds)
-	mux.Handle("/addFeeds", checkTokenMiddleware(addFeedsHandler))
+	mux.Handle("/addFeeds", checkTokenMiddleware(requireRole(data.RoleEditor, addFeedsHandler)))
 
 	//mux.HandleFunc("/saveFeeds", saveFeedsHandler)
 	saveFeedsHandler := http.HandlerFunc(saveFeeds)
-	mux.Handle("/saveFeeds", checkTokenMiddleware(saveFeedsHandler))
+	mux.Handle("/saveFeeds", checkTokenMiddleware(requireRole(data.RoleEditor, saveFeedsHandler)))
+
+	// users and collector jobs, see roles.go
+	mux.Handle("/admin/users", checkTokenMiddleware(requireRole(data.RoleAdmin, http.HandlerFunc(adminUsers))))
+	mux.Handle("/admin/jobs", checkTokenMiddleware(requireRole(data.RoleAdmin, http.HandlerFunc(adminJobs))))
+
+	// JSON API for the scripts, see api.go
+	handleAPI(mux)
 
 	// ListenAndServe starts an HTTP server with a given address and handler.
 	// -- http://localhost:8080
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+)
+
+func TestMain(m *testing.M) {
+
+	jwtKey = []byte("test key")
+	sessionTTL = 30 * time.Minute
+
+	// the handlers log every query
+	log.SetOutput(io.Discard)
+
+	os.Exit(m.Run())
+}
+
+// pageCursor returns the cursor of the page, as memStore hands them out
+func pageCursor(page int) string {
+	if page == 1 {
+		return ""
+	}
+	return strconv.Itoa(page)
+}
+
+// newSearchRequest returns the /search request of the user, with the token cookie
+func newSearchRequest(t *testing.T, user *data.User, params url.Values) *http.Request {
+
+	token, _, err := newToken(user, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/search?"+params.Encode(), nil)
+	r.AddCookie(&http.Cookie{Name: tokenCookie, Value: token})
+
+	return r
+}
+
+var searchedWords = regexp.MustCompile(`q\d{3}x`)
+
+func TestSearchConcurrent(t *testing.T) {
+
+	db := newTestStore(t)
+	handler := checkTokenMiddleware(http.HandlerFunc(search))
+	countries := []string{"Global", "Italy", "Australia"}
+
+	// a favourite domain per user, named after the user
+	users := []*data.User{nil}
+	for i := 1; i <= 60; i++ {
+		users = append(users, db.addUser(data.User{Username: fmt.Sprintf("user%03d", i), Role: data.RoleViewer}))
+		db.domains = append(db.domains, fmt.Sprintf("fav%03d.it", i))
+	}
+	for i := 1; i <= 60; i++ {
+		if err := db.SetFavourites(users[i].ID, []string{fmt.Sprintf("fav%03d.it", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 60; i++ {
+
+		i := i
+		user := users[i]
+		words := fmt.Sprintf("q%03dx", i)
+		country := countries[i%len(countries)]
+		page := i%4 + 1
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			params := url.Values{"q": {words}, "country": {country}, "limit": {"3"}, "cursor": {pageCursor(page)}}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, newSearchRequest(t, user, params))
+
+			body := w.Body.String()
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: status = %d, body = %s", words, w.Code, body)
+				return
+			}
+
+			// only the words of this request, in the form, the links of the pages and the articles found
+			for _, found := range searchedWords.FindAllString(body, -1) {
+				if found != words {
+					t.Errorf("%s: page with the words of %s", words, found)
+					break
+				}
+			}
+
+			title := "news " + words
+			if country != "Global" {
+				title = "news " + country + " " + words
+			}
+			if n := strings.Count(body, `<h3 class="title">`+title+`</h3>`); n != 3 {
+				t.Errorf("%s: %d articles titled %q, want 3", words, n, title)
+			}
+
+			if !strings.Contains(body, fmt.Sprintf("You are on page <strong>%d</strong>", page)) {
+				t.Errorf("%s: not on page %d", words, page)
+			}
+			if !strings.Contains(body, fmt.Sprintf(`<option value="%s" selected>`, country)) && country != "Global" {
+				t.Errorf("%s: country %s not selected", words, country)
+			}
+
+			// the menus of the user of the request
+			if !strings.Contains(body, "Welcome <b>"+user.Username+" </b>") {
+				t.Errorf("%s: page not of %s", words, user.Username)
+			}
+			if strings.Count(body, "<td>fav") != 1 || !strings.Contains(body, fmt.Sprintf("<td>fav%03d.it</td>", i)) {
+				t.Errorf("%s: favourites not of %s", words, user.Username)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPagesInternalError(t *testing.T) {
+
+	db := newTestStore(t)
+	editor := db.addUser(data.User{Username: "editor", Role: data.RoleEditor})
+	logged := &LoggedUser{ID: editor.ID, Username: editor.Username, Role: editor.Role}
+
+	tests := []struct {
+		path    string
+		handler http.HandlerFunc
+	}{
+		{"/", index},
+		{"/search?q=roma&country=Italy", search},
+		{"/addFeeds", addFeeds},
+		{"/saveFeeds?sfeed=ansa.it", saveFeeds},
+	}
+
+	// the DB is down
+	db.err = errors.New("pq: connection refused by 10.0.0.5")
+
+	for _, tt := range tests {
+
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r = r.WithContext(context.WithValue(r.Context(), loggedUserKey, logged))
+		w := httptest.NewRecorder()
+		tt.handler(w, r)
+
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("%s: status = %d, want 500", tt.path, w.Code)
+		}
+		// the users are not told the details of the DB
+		if body := w.Body.String(); strings.Contains(body, "pq:") {
+			t.Errorf("%s: body = %s", tt.path, body)
+		}
+	}
+}
+
+func TestDateRange(t *testing.T) {
+
+	utc := func(s string) time.Time {
+		tm, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	var none time.Time
+
+	tests := []struct {
+		name             string
+		period, from, to string
+		now              time.Time
+		start, end       time.Time
+	}{
+		{"no range", "", "", "", utc("2021-11-20T20:00:00Z"), none, none},
+
+		// the periods end now, their days are in Australia/Sydney: 2021-11-20 20:00 UTC is Sunday 21 at 07:00 there
+		{"today", "today", "", "", utc("2021-11-20T20:00:00Z"), utc("2021-11-20T13:00:00Z"), none},
+		{"24h", "24h", "", "", utc("2021-11-20T20:00:00Z"), utc("2021-11-19T20:00:00Z"), none},
+		{"week", "week", "", "", utc("2021-11-20T20:00:00Z"), utc("2021-11-14T13:00:00Z"), none},
+		{"week on Monday", "week", "", "", utc("2021-11-21T14:00:00Z"), utc("2021-11-21T13:00:00Z"), none},
+		// the period wins over the days
+		{"period and days", "today", "2021-11-01", "2021-11-02", utc("2021-11-20T20:00:00Z"), utc("2021-11-20T13:00:00Z"), none},
+
+		// the days are included, from their midnight to the next one
+		{"days", "", "2021-11-01", "2021-11-02", utc("2021-11-20T20:00:00Z"), utc("2021-10-31T13:00:00Z"), utc("2021-11-02T13:00:00Z")},
+		{"from only", "", "2021-11-01", "", utc("2021-11-20T20:00:00Z"), utc("2021-10-31T13:00:00Z"), none},
+		{"to only", "", "", "2021-11-01", utc("2021-11-20T20:00:00Z"), none, utc("2021-11-01T13:00:00Z")},
+		{"one day", "", "2021-11-01", "2021-11-01", utc("2021-11-20T20:00:00Z"), utc("2021-10-31T13:00:00Z"), utc("2021-11-01T13:00:00Z")},
+
+		// DST starts on 2021-10-03 at 02:00 (+10 to +11): the day is 23 hours long
+		{"DST start day", "", "2021-10-03", "2021-10-03", utc("2021-11-20T20:00:00Z"), utc("2021-10-02T14:00:00Z"), utc("2021-10-03T13:00:00Z")},
+		{"today at DST start", "today", "", "", utc("2021-10-03T05:00:00Z"), utc("2021-10-02T14:00:00Z"), none},
+		{"week of DST start", "week", "", "", utc("2021-10-03T05:00:00Z"), utc("2021-09-26T14:00:00Z"), none},
+		// DST ends on 2022-04-03 at 03:00 (+11 to +10): the day is 25 hours long
+		{"DST end day", "", "2022-04-03", "2022-04-03", utc("2022-05-01T00:00:00Z"), utc("2022-04-02T13:00:00Z"), utc("2022-04-03T14:00:00Z")},
+		{"week of DST end", "week", "", "", utc("2022-04-05T00:00:00Z"), utc("2022-04-03T14:00:00Z"), none},
+		{"24h over DST end", "24h", "", "", utc("2022-04-03T12:00:00Z"), utc("2022-04-02T12:00:00Z"), none},
+	}
+
+	for _, tt := range tests {
+
+		start, end, err := dateRange(tt.period, tt.from, tt.to, tt.now)
+		if err != nil {
+			t.Errorf("%s: error %v", tt.name, err)
+			continue
+		}
+		if !start.Equal(tt.start) || !end.Equal(tt.end) || start.IsZero() != tt.start.IsZero() || end.IsZero() != tt.end.IsZero() {
+			t.Errorf("%s: range %v - %v, want %v - %v", tt.name, start.UTC(), end.UTC(), tt.start, tt.end)
+		}
+	}
+
+	for _, days := range [][2]string{
+		{"2021-13-01", ""},
+		{"", "yesterday"},
+		{"01/11/2021", "2021-11-02"},
+		{"2021-11-02", "2021-11-01"},
+	} {
+		if _, _, err := dateRange("", days[0], days[1], time.Now()); err == nil {
+			t.Errorf("from '%s' to '%s': no error", days[0], days[1])
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Package oidc is the client of an OpenID Connect identity provider, for the single sign-on of the visualizer.
+//
+// It implements the authorization code flow with PKCE: AuthCodeURL sends the user to the provider,
+// which sends them back to the redirect URL with a code, and Login exchanges the code for the ID token
+// and checks it (signature by the keys of the provider, issuer, audience, expiry and nonce).
+// The endpoints are read from the discovery document of the issuer (/.well-known/openid-configuration).
+//
+// See the oidctest package for a mock issuer.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors of the login, matched with errors.Is
+var (
+	// ErrProvider is returned when the provider can't be reached or answers with an error
+	ErrProvider = errors.New("identity provider unavailable")
+	// ErrRefused is returned when the provider refuses the code, e.g. expired or already used
+	ErrRefused = errors.New("login refused by the identity provider")
+	// ErrInvalidToken is returned when the ID token isn't valid: signature, issuer, audience, expiry or nonce
+	ErrInvalidToken = errors.New("ID token not valid")
+)
+
+// Config is the client registered on the provider
+type Config struct {
+	// Issuer is the URL of the provider, as in the 'iss' claim of its tokens
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback of the visualizer the provider sends the user back to, with the code
+	RedirectURL string
+	// Scopes asked, 'openid' is always added
+	Scopes []string
+}
+
+// Provider is the identity provider of the Config, its endpoints and keys are fetched at the first use
+type Provider struct {
+	config Config
+	http   *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+
+	// keysMu is held while the keys are fetched, for the requests with the same unknown id to wait for them
+	keysMu sync.Mutex
+	keys   map[string]interface{}
+	// keysFetched is the time of the last fetch of the keys
+	keysFetched time.Time
+}
+
+// discovery is the subset of the discovery document the login needs
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// IDToken is the identity of the user in the ID token
+type IDToken struct {
+	Subject string
+	Email   string
+	// EmailVerified is true only if the provider says so in the email_verified claim
+	EmailVerified bool
+	Groups        []string
+}
+
+// NewProvider returns the provider of the config, calling it with the http client (http.DefaultClient if nil)
+func NewProvider(config Config, client *http.Client) *Provider {
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+
+	return &Provider{config: config, http: client}
+}
+
+// AuthCodeURL returns the URL of the provider to send the user to for the login.
+// state, nonce and verifier are random strings (see NewSecret) to be kept until the callback:
+// state to check the callback is the answer to our request, nonce to check the ID token and verifier for Login.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.config.ClientID)
+	params.Set("redirect_uri", p.config.RedirectURL)
+	params.Set("scope", strings.Join(p.scopes(), " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+
+	return d.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Login exchanges the code of the callback for the ID token and returns the identity in it,
+// once checked against the nonce and the verifier of AuthCodeURL
+func (p *Provider) Login(ctx context.Context, code, verifier, nonce string) (*IDToken, error) {
+
+	rawIDToken, err := p.exchange(ctx, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.verify(ctx, rawIDToken, nonce, time.Now())
+}
+
+// scopes returns the scopes of the config with 'openid'
+func (p *Provider) scopes() []string {
+
+	scopes := []string{"openid"}
+	for _, s := range p.config.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return scopes
+}
+
+// exchange calls the token endpoint with the code, returning the ID token
+func (p *Provider) exchange(ctx context.Context, code, verifier string) (string, error) {
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// client_secret_basic, the default authentication of the clients
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	var answer struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := p.do(req, &answer); err != nil {
+		if answer.Error != "" {
+			return "", fmt.Errorf("%w: token endpoint: %s %s", ErrRefused, answer.Error, answer.ErrorDescription)
+		}
+		return "", err
+	}
+
+	if answer.IDToken == "" {
+		return "", fmt.Errorf("%w: no id_token from the token endpoint", ErrProvider)
+	}
+
+	return answer.IDToken, nil
+}
+
+// getDiscovery returns the discovery document, fetched at the first call
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &discovery{}
+	if err := p.do(req, d); err != nil {
+		return nil, err
+	}
+
+	// the issuer must be the one configured, as the 'iss' of the tokens (OpenID Connect Discovery 4.3)
+	if strings.TrimSuffix(d.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("%w: discovery issuer '%s' isn't '%s'", ErrProvider, d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document without the endpoints", ErrProvider)
+	}
+
+	p.discovery = d
+
+	return d, nil
+}
+
+// do sends the request and decodes the JSON answer into v, also when the status is an error
+func (p *Provider) do(req *http.Request, v interface{}) error {
+
+	res, err := p.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvider, err)
+	}
+
+	jsonErr := json.Unmarshal(body, v)
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s answered %s", ErrProvider, req.URL.Path, res.Status)
+	}
+	if jsonErr != nil {
+		return fmt.Errorf("%w: %s => %v", ErrProvider, req.URL.Path, jsonErr)
+	}
+
+	return nil
+}
+
+// NewSecret returns a random string for the state, the nonce and the verifier of AuthCodeURL
+func NewSecret() (string, error) {
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
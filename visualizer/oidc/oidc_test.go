@@ -0,0 +1,212 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/visualizer/oidc"
+	"github.com/mesmerai/news-aggregator/visualizer/oidc/oidctest"
+)
+
+const redirectURL = "http://localhost:8080/oidc/callback"
+
+var alice = oidctest.User{Subject: "alice-id", Email: "alice@example.com", Groups: []string{"news-editors"}}
+
+// newProvider returns the provider of a mock issuer approving alice at once
+func newProvider(t *testing.T) (*oidc.Provider, *oidctest.Server) {
+
+	ts, server := oidctest.NewServer("visualizer", "secret", alice)
+	t.Cleanup(ts.Close)
+
+	p := oidc.NewProvider(oidc.Config{
+		Issuer:       server.Issuer,
+		ClientID:     "visualizer",
+		ClientSecret: "secret",
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email", "groups"},
+	}, ts.Client())
+
+	return p, server
+}
+
+// authorize sends the user to the provider as the browser does, returning the params of the callback
+func authorize(t *testing.T, p *oidc.Provider, state, nonce, verifier string) url.Values {
+
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	callback, err := res.Location()
+	if err != nil {
+		t.Fatalf("no redirect to the callback: %s", res.Status)
+	}
+	if got := callback.Scheme + "://" + callback.Host + callback.Path; got != redirectURL {
+		t.Fatalf("callback = %s, want %s", got, redirectURL)
+	}
+
+	return callback.Query()
+}
+
+func TestAuthCodeURL(t *testing.T) {
+
+	p, server := newProvider(t)
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := u.Query()
+	want := map[string]string{
+		"response_type": "code",
+		"client_id":     "visualizer",
+		"redirect_uri":  redirectURL,
+		"scope":         "openid email groups",
+		"state":         "state",
+		"nonce":         "nonce",
+		// BASE64URL(SHA256("verifier")), RFC 7636
+		"code_challenge":        "iMnq5o6zALKXGivsnlom_0F5_WYda32GHkxlV7mq7hQ",
+		"code_challenge_method": "S256",
+	}
+	for name, value := range want {
+		if params.Get(name) != value {
+			t.Errorf("%s = %q, want %q", name, params.Get(name), value)
+		}
+	}
+	if u.Scheme+"://"+u.Host != server.Issuer || u.Path != "/authorize" {
+		t.Errorf("authorization endpoint = %s", authURL)
+	}
+}
+
+func TestLogin(t *testing.T) {
+
+	p, _ := newProvider(t)
+
+	callback := authorize(t, p, "state", "nonce", "verifier")
+	if callback.Get("state") != "state" {
+		t.Errorf("state = %q, want the one sent", callback.Get("state"))
+	}
+
+	id, err := p.Login(context.Background(), callback.Get("code"), "verifier", "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Subject != alice.Subject || id.Email != alice.Email || !id.EmailVerified {
+		t.Errorf("id = %+v", id)
+	}
+	if len(id.Groups) != 1 || id.Groups[0] != "news-editors" {
+		t.Errorf("groups = %v", id.Groups)
+	}
+
+	// a code is used once
+	if _, err := p.Login(context.Background(), callback.Get("code"), "verifier", "nonce"); !errors.Is(err, oidc.ErrRefused) {
+		t.Errorf("second login with the code: err = %v, want ErrRefused", err)
+	}
+}
+
+func TestLoginPKCE(t *testing.T) {
+
+	p, _ := newProvider(t)
+
+	// the code alone, without the verifier of the login, is no use
+	callback := authorize(t, p, "state", "nonce", "verifier")
+	if _, err := p.Login(context.Background(), callback.Get("code"), "another verifier", "nonce"); !errors.Is(err, oidc.ErrRefused) {
+		t.Errorf("wrong verifier: err = %v, want ErrRefused", err)
+	}
+
+	if _, err := p.Login(context.Background(), "not a code", "verifier", "nonce"); !errors.Is(err, oidc.ErrRefused) {
+		t.Errorf("unknown code: err = %v, want ErrRefused", err)
+	}
+}
+
+func TestLoginNonce(t *testing.T) {
+
+	p, _ := newProvider(t)
+
+	// the ID token of another login
+	callback := authorize(t, p, "state", "nonce", "verifier")
+	if _, err := p.Login(context.Background(), callback.Get("code"), "verifier", "another nonce"); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Errorf("err = %v, want ErrInvalidToken", err)
+	}
+}
+
+func TestLoginInvalidToken(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+	}{
+		{"other audience", map[string]interface{}{"aud": "another-client"}},
+		{"audiences without the authorized party", map[string]interface{}{"aud": []string{"visualizer", "another-client"}}},
+		{"other issuer", map[string]interface{}{"iss": "https://idp.example.com"}},
+		{"expired", map[string]interface{}{"exp": time.Now().Add(-5 * time.Minute).Unix()}},
+		{"issued in the future", map[string]interface{}{"iat": time.Now().Add(time.Hour).Unix()}},
+		{"no subject", map[string]interface{}{"sub": nil}},
+	}
+
+	for _, tt := range tests {
+
+		p, server := newProvider(t)
+		server.Claims = tt.claims
+
+		callback := authorize(t, p, "state", "nonce", "verifier")
+		if _, err := p.Login(context.Background(), callback.Get("code"), "verifier", "nonce"); !errors.Is(err, oidc.ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", tt.name, err)
+		}
+	}
+
+	// more audiences are fine with us as the authorized party
+	p, server := newProvider(t)
+	server.Claims = map[string]interface{}{"aud": []string{"visualizer", "another-client"}, "azp": "visualizer"}
+
+	callback := authorize(t, p, "state", "nonce", "verifier")
+	if _, err := p.Login(context.Background(), callback.Get("code"), "verifier", "nonce"); err != nil {
+		t.Errorf("authorized party: err = %v", err)
+	}
+}
+
+func TestLoginEmailVerified(t *testing.T) {
+
+	tests := []struct {
+		verified interface{}
+		want     bool
+	}{
+		{true, true},
+		{"true", true},
+		{false, false},
+		{"false", false},
+		// no claim, the email isn't verified
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+
+		p, server := newProvider(t)
+		server.Claims = map[string]interface{}{"email_verified": tt.verified}
+
+		callback := authorize(t, p, "state", "nonce", "verifier")
+		id, err := p.Login(context.Background(), callback.Get("code"), "verifier", "nonce")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id.EmailVerified != tt.want {
+			t.Errorf("email_verified %#v: EmailVerified = %v, want %v", tt.verified, id.EmailVerified, tt.want)
+		}
+	}
+}
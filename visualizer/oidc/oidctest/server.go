@@ -0,0 +1,353 @@
+// Package oidctest is a mock OpenID Connect issuer, for the tests of the single sign-on (NewServer)
+// and to try it locally (cmd/fakeoidc).
+//
+// It implements the bits of the provider the oidc package uses:
+//   - /.well-known/openid-configuration: the discovery document
+//   - /jwks: the public key of the ID tokens, an RSA key generated at the start
+//   - /authorize: the login, approving at once the User of the server (AutoApprove)
+//     or asking for the email and the groups in a form
+//   - /token: the ID token for the code, checking the client credentials, the redirect URL and the PKCE verifier
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// the id of the key of the ID tokens
+const keyID = "oidctest"
+
+// User is the identity in the ID tokens
+type User struct {
+	Subject string
+	Email   string
+	Groups  []string
+}
+
+// Server is the mock issuer
+type Server struct {
+	// Issuer is the URL of the server, as in the 'iss' of the tokens
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// User is the user logged in by /authorize with AutoApprove, the default of the form otherwise
+	User        User
+	AutoApprove bool
+	// TokenTTL is the validity of the ID tokens
+	TokenTTL time.Duration
+	// Claims replace the claims of the ID tokens, a nil value removes the claim,
+	// e.g. to test an unverified email or another audience
+	Claims map[string]interface{}
+
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// requests of /authorize by code, until /token
+	codes map[string]authorization
+}
+
+// authorization is a login approved by /authorize
+type authorization struct {
+	user        User
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	expires     time.Time
+}
+
+// New returns the issuer at the URL, for the client, approving the user at once
+func New(issuer, clientID, clientSecret string, user User) *Server {
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal("Error generating the key => ", err)
+	}
+
+	return &Server{
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		User:         user,
+		AutoApprove:  true,
+		TokenTTL:     5 * time.Minute,
+		key:          key,
+		codes:        map[string]authorization{},
+	}
+}
+
+// NewServer starts a mock issuer for the client, approving the user at once, to be closed by the caller.
+// The Issuer of the returned Server is the URL of the test server.
+func NewServer(clientID, clientSecret string, user User) (*httptest.Server, *Server) {
+
+	s := New("", clientID, clientSecret, user)
+	ts := httptest.NewServer(s.Handler())
+	s.Issuer = ts.URL
+
+	return ts, s
+}
+
+// Handler returns the handler of the issuer
+func (s *Server) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+
+	return mux
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.Issuer,
+		"authorization_endpoint":                s.Issuer + "/authorize",
+		"token_endpoint":                        s.Issuer + "/token",
+		"jwks_uri":                              s.Issuer + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"scopes_supported":                      []string{"openid", "email", "groups"},
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+// loginForm asks for the user to log in, when not AutoApprove
+var loginForm = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html><body>
+<h3>oidctest login</h3>
+<form method="POST">
+  {{ range $name, $values := .Params }}<input type="hidden" name="{{ $name }}" value="{{ index $values 0 }}">
+  {{ end }}
+  <p>Email <input name="login_email" value="{{ .User.Email }}"></p>
+  <p>Groups (comma separated) <input name="login_groups" value="{{ .Groups }}"></p>
+  <p><input type="submit" value="Login"></p>
+</form>
+</body></html>`))
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// the errors about the client are shown to the user, not sent to a redirect URL we can't trust
+	if r.Form.Get("client_id") != s.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(r.Form.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "not a valid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	fail := func(code, description string) {
+		q := redirectURI.Query()
+		q.Set("error", code)
+		q.Set("error_description", description)
+		q.Set("state", r.Form.Get("state"))
+		redirectURI.RawQuery = q.Encode()
+		http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+	}
+
+	if r.Form.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the code flow is supported")
+		return
+	}
+	if !strings.Contains(" "+r.Form.Get("scope")+" ", " openid ") {
+		fail("invalid_scope", "the openid scope is required")
+		return
+	}
+	if r.Form.Get("code_challenge") == "" || r.Form.Get("code_challenge_method") != "S256" {
+		fail("invalid_request", "a S256 code_challenge is required")
+		return
+	}
+
+	user := s.User
+	if !s.AutoApprove {
+		if r.Method != http.MethodPost {
+			params := url.Values{}
+			for name, values := range r.Form {
+				params[name] = values
+			}
+			loginForm.Execute(w, map[string]interface{}{"Params": params, "User": s.User, "Groups": strings.Join(s.User.Groups, ",")})
+			return
+		}
+
+		user = User{Subject: r.Form.Get("login_email"), Email: r.Form.Get("login_email")}
+		for _, g := range strings.Split(r.Form.Get("login_groups"), ",") {
+			if g = strings.TrimSpace(g); g != "" {
+				user.Groups = append(user.Groups, g)
+			}
+		}
+	}
+
+	code := randomString()
+
+	s.mu.Lock()
+	s.codes[code] = authorization{
+		user:        user,
+		clientID:    s.ClientID,
+		redirectURI: r.Form.Get("redirect_uri"),
+		nonce:       r.Form.Get("nonce"),
+		challenge:   r.Form.Get("code_challenge"),
+		expires:     time.Now().Add(time.Minute),
+	}
+	s.mu.Unlock()
+
+	q := redirectURI.Query()
+	q.Set("code", code)
+	q.Set("state", r.Form.Get("state"))
+	redirectURI.RawQuery = q.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, tokenError("invalid_request", "POST only"))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, tokenError("invalid_request", err.Error()))
+		return
+	}
+
+	// client_secret_basic or client_secret_post
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, tokenError("invalid_client", "wrong client credentials"))
+		return
+	}
+
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, tokenError("unsupported_grant_type", "only authorization_code is supported"))
+		return
+	}
+
+	// a code is used once
+	s.mu.Lock()
+	auth, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	if !ok || time.Now().After(auth.expires) || auth.clientID != clientID {
+		writeJSON(w, http.StatusBadRequest, tokenError("invalid_grant", "unknown or expired code"))
+		return
+	}
+	if r.PostForm.Get("redirect_uri") != auth.redirectURI {
+		writeJSON(w, http.StatusBadRequest, tokenError("invalid_grant", "redirect_uri doesn't match"))
+		return
+	}
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != auth.challenge {
+		writeJSON(w, http.StatusBadRequest, tokenError("invalid_grant", "code_verifier doesn't match"))
+		return
+	}
+
+	idToken, err := s.IDToken(auth.user, auth.nonce, time.Now())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, tokenError("server_error", err.Error()))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   int(s.TokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+// IDToken returns the signed ID token of the user for the client, issued at now
+func (s *Server) IDToken(user User, nonce string, now time.Time) (string, error) {
+
+	claims := jwt.MapClaims{
+		"iss":            s.Issuer,
+		"sub":            user.Subject,
+		"aud":            s.ClientID,
+		"exp":            now.Add(s.TokenTTL).Unix(),
+		"iat":            now.Unix(),
+		"email":          user.Email,
+		"email_verified": true,
+		"groups":         user.Groups,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	for name, value := range s.Claims {
+		if value == nil {
+			delete(claims, name)
+			continue
+		}
+		claims[name] = value
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+
+	return token.SignedString(s.key)
+}
+
+func tokenError(code, description string) map[string]string {
+	return map[string]string{"error": code, "error_description": description}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Error writing the answer => ", err)
+	}
+}
+
+func randomString() string {
+
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		panic(fmt.Sprintf("no random bytes: %v", err))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random)
+}
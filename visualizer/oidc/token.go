@@ -0,0 +1,256 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// leeway is the clock difference allowed with the provider on the expiry and the issue time
+const leeway = time.Minute
+
+// keysRefetch is the least time between two fetches of the keys, a token with an unknown id doesn't fetch them again before
+const keysRefetch = time.Minute
+
+// idTokenClaims are the claims of the ID token the login reads, see
+// https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+type idTokenClaims struct {
+	Issuer          string     `json:"iss"`
+	Subject         string     `json:"sub"`
+	Audience        stringList `json:"aud"`
+	AuthorizedParty string     `json:"azp"`
+	ExpiresAt       float64    `json:"exp"`
+	IssuedAt        float64    `json:"iat"`
+	Nonce           string     `json:"nonce"`
+	Email           string     `json:"email"`
+	// a bool, or a string for some providers
+	EmailVerified json.RawMessage `json:"email_verified"`
+	Groups        stringList      `json:"groups"`
+}
+
+// Valid is checked by verify, against the config and the time
+func (c *idTokenClaims) Valid() error {
+	return nil
+}
+
+// stringList is a claim that is a list of strings or a single string, as 'aud'
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*l = stringList{one}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*l = many
+
+	return nil
+}
+
+func (l stringList) contains(s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the ID token and returns the identity in it
+func (p *Provider) verify(ctx context.Context, rawIDToken, nonce string, now time.Time) (*IDToken, error) {
+
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+
+		// the providers sign with their keys, never with a shared secret or none
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+
+		kid, _ := token.Header["kid"].(string)
+
+		return p.key(ctx, kid, now)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	if strings.TrimSuffix(claims.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("%w: issuer '%s'", ErrInvalidToken, claims.Issuer)
+	}
+	if !claims.Audience.contains(p.config.ClientID) {
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidToken, claims.Audience)
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.config.ClientID {
+		return nil, fmt.Errorf("%w: authorized party '%s'", ErrInvalidToken, claims.AuthorizedParty)
+	}
+	if claims.ExpiresAt == 0 || now.After(time.Unix(int64(claims.ExpiresAt), 0).Add(leeway)) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if now.Add(leeway).Before(time.Unix(int64(claims.IssuedAt), 0)) {
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce doesn't match", ErrInvalidToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+
+	// verified only if the provider says so, a missing claim is not
+	verified := strings.Trim(string(claims.EmailVerified), `"`)
+
+	return &IDToken{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: strings.EqualFold(verified, "true"),
+		Groups:        claims.Groups,
+	}, nil
+}
+
+// key returns the public key of the provider with the id, fetching the keys again
+// when it's not known (the provider rotated them), at most once every keysRefetch
+func (p *Provider) key(ctx context.Context, kid string, now time.Time) (interface{}, error) {
+
+	p.keysMu.Lock()
+	defer p.keysMu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if !p.keysFetched.IsZero() && now.Sub(p.keysFetched) < keysRefetch {
+		return nil, fmt.Errorf("no key '%s' in the keys of the provider, fetched %v ago", kid, now.Sub(p.keysFetched))
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := p.do(req, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]interface{}{}
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		public, err := k.publicKey()
+		if err != nil {
+			// a key of a type we don't use, e.g. OKP
+			continue
+		}
+		keys[k.Kid] = public
+	}
+
+	p.keys, p.keysFetched = keys, now
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("no key '%s' in the keys of the provider", kid)
+}
+
+// lookupKey returns the key with the id, or the only key when the token has no id
+func (p *Provider) lookupKey(kid string) (interface{}, bool) {
+
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+
+	key, ok := p.keys[kid]
+
+	return key, ok
+}
+
+// jsonWebKey is a public key of the provider (RFC 7517), RSA or EC
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curve '%s' not supported", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+
+	return nil, fmt.Errorf("key type '%s' not supported", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("not a valid key number '%s'", s)
+	}
+
+	return new(big.Int).SetBytes(b), nil
+}
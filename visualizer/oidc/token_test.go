@@ -0,0 +1,85 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mesmerai/news-aggregator/visualizer/oidc/oidctest"
+)
+
+func TestKeyRefetch(t *testing.T) {
+
+	// the issuer, counting the fetches of its keys
+	server := oidctest.New("", "visualizer", "secret", oidctest.User{Subject: "alice-id", Email: "alice@example.com"})
+	var fetches int32
+	handler := server.Handler()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/jwks" {
+			atomic.AddInt32(&fetches, 1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	server.Issuer = ts.URL
+
+	p := NewProvider(Config{Issuer: server.Issuer, ClientID: "visualizer", ClientSecret: "secret"}, ts.Client())
+	ctx := context.Background()
+	now := time.Now()
+
+	known, err := server.IDToken(server.User, "nonce", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a key the provider doesn't publish, as one rotated in before its keys are fetched again
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"iss": server.Issuer, "sub": "alice-id", "aud": "visualizer"})
+	token.Header["kid"] = "rotated"
+	unknown, err := token.SignedString(rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.verify(ctx, known, "nonce", now); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Fatalf("fetches of the keys at the first use = %d, want 1", got)
+	}
+
+	// the unknown id fetches the keys once a minute at most, the known ones never again
+	steps := []struct {
+		elapsed time.Duration
+		fetches int32
+	}{
+		{time.Second, 1},
+		{30 * time.Second, 1},
+		{keysRefetch - time.Second, 1},
+		{keysRefetch, 2},
+		{keysRefetch + 30*time.Second, 2},
+		{2 * keysRefetch, 3},
+	}
+
+	for _, s := range steps {
+		if _, err := p.verify(ctx, unknown, "", now.Add(s.elapsed)); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("unknown key after %v: err = %v, want ErrInvalidToken", s.elapsed, err)
+		}
+		if _, err := p.verify(ctx, known, "nonce", now.Add(s.elapsed)); err != nil {
+			t.Errorf("known key after %v: %v", s.elapsed, err)
+		}
+		if got := atomic.LoadInt32(&fetches); got != s.fetches {
+			t.Errorf("fetches of the keys after %v = %d, want %d", s.elapsed, got, s.fetches)
+		}
+	}
+}
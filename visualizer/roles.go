@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+)
+
+// ** Roles **
+// The role of the user is in the claims of the token: the viewers search, the editors also manage the favourite feeds
+// (what the collector spends the API calls on) and the admins also manage the users and the collector jobs.
+// requireRole wraps the handlers after checkTokenMiddleware.
+
+// Can tells if the role of the user allows what the role does
+func (u *LoggedUser) Can(role string) bool {
+	return u != nil && data.IsRole(u.Role) && data.RoleRank(u.Role) >= data.RoleRank(role)
+}
+
+// IsEditor tells if the user can manage the feeds
+func (u *LoggedUser) IsEditor() bool {
+	return u.Can(data.RoleEditor)
+}
+
+// IsAdmin tells if the user can manage the users and the jobs
+func (u *LoggedUser) IsAdmin() bool {
+	return u.Can(data.RoleAdmin)
+}
+
+// requireRole lets through the users with the role, or a more allowed one, answering 403 to the others
+func requireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		user := loggedUser(r)
+		if !user.Can(role) {
+			log.Printf("Forbidden => '%s' is %s, %s is %s only", user.Username, user.Role, r.URL.Path, role)
+
+			renderMessage(w, r, http.StatusForbidden, fmt.Sprintf("Not allowed: only the %ss can do that.", role))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminUsers changes a user on the POST of the admin form: 'action' is role (with 'role'), disable or enable
+func adminUsers(w http.ResponseWriter, r *http.Request) {
+
+	// log the request
+	log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	admin := loggedUser(r)
+	username := r.PostFormValue("username")
+	action := r.PostFormValue("action")
+
+	message := adminMessage(func() error {
+
+		// not to lock themselves out
+		if username == admin.Username {
+			return refusal("admins can't change themselves")
+		}
+
+		user, err := myDB.GetUser(username)
+		if err != nil {
+			return err
+		}
+
+		switch action {
+		case "role":
+			role := r.PostFormValue("role")
+			if !data.IsRole(role) {
+				return refusal(fmt.Sprintf("'%s' isn't a role", role))
+			}
+			// it would be back to the one of the groups at the next login
+			if user.Email != "" {
+				return refusal("the role of the single sign-on users follows their groups")
+			}
+			return myDB.SetUserRole(username, role)
+		case "disable", "enable":
+			return myDB.SetUserDisabled(username, action == "disable")
+		}
+
+		return refusal(fmt.Sprintf("'%s' isn't an action", action))
+	})
+
+	if message != "" {
+		renderMessage(w, r, http.StatusBadRequest, fmt.Sprintf("User '%s' not changed: %s.", username, message))
+		return
+	}
+
+	log.Printf("User '%s': %s by '%s'.", username, action, admin.Username)
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// adminJobs changes a collector job on the POST of the admin form: 'action' is reset, disable, enable or schedule
+// (with 'schedule', empty for the one of the config). The collector picks the changes up within a minute.
+func adminJobs(w http.ResponseWriter, r *http.Request) {
+
+	// log the request
+	log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	job := r.PostFormValue("job")
+	action := r.PostFormValue("action")
+
+	message := adminMessage(func() error {
+
+		switch action {
+		case "reset":
+			return myDB.ResetJob(job)
+		case "disable", "enable":
+			return myDB.SetJobEnabled(job, action == "enable")
+		case "schedule":
+			schedule := strings.Join(strings.Fields(r.PostFormValue("schedule")), " ")
+			if schedule != "" && !isSchedule(schedule) {
+				return refusal(fmt.Sprintf("'%s' isn't a crontab schedule (minute hour day month weekday)", schedule))
+			}
+			return myDB.SetJobSchedule(job, schedule)
+		}
+
+		return refusal(fmt.Sprintf("'%s' isn't an action", action))
+	})
+
+	if message != "" {
+		renderMessage(w, r, http.StatusBadRequest, fmt.Sprintf("Job '%s' not changed: %s.", job, message))
+		return
+	}
+
+	log.Printf("Job '%s': %s by '%s'.", job, action, loggedUser(r).Username)
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// scheduleField is a field of a crontab schedule, e.g. '*', '*/15', '1,4,7' or '1-5'
+var scheduleField = regexp.MustCompile(`^(\*|\d+(-\d+)?)(/\d+)?(,(\*|\d+(-\d+)?)(/\d+)?)*$`)
+
+// isSchedule tells if the schedule has the 5 fields of crontab (minute hour day month weekday).
+// The collector checks the ranges, keeping the schedule of the config if they're wrong.
+func isSchedule(schedule string) bool {
+
+	fields := strings.Fields(schedule)
+	if len(fields) != 5 {
+		return false
+	}
+	for _, field := range fields {
+		if !scheduleField.MatchString(field) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// refusal is a change the admin can't do, its message is shown on the page
+type refusal string
+
+func (r refusal) Error() string {
+	return string(r)
+}
+
+// adminMessage runs the change, returning the message of its error for the page (empty if none)
+func adminMessage(change func() error) string {
+
+	err := change()
+	if err == nil {
+		return ""
+	}
+
+	var refused refusal
+	if errors.As(err, &refused) {
+		return refused.Error()
+	}
+	if errors.Is(err, data.ErrNotFound) {
+		return "not found"
+	}
+
+	log.Println("Error of the admin => ", err)
+
+	return "error saving the change, see the logs"
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+)
+
+// newAdminRequest returns the POST of the admin form of the jobs by the user, with the token cookie
+func newAdminRequest(t *testing.T, user *data.User, form url.Values) *http.Request {
+
+	token, _, err := newToken(user, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/jobs", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: tokenCookie, Value: token})
+
+	return r
+}
+
+func TestAdminJobs(t *testing.T) {
+
+	db := newTestStore(t)
+	handler := checkTokenMiddleware(requireRole(data.RoleAdmin, http.HandlerFunc(adminJobs)))
+	admin := db.addUser(data.User{Username: "admin", Role: data.RoleAdmin})
+
+	// the job of the collector
+	italy := data.Job{Name: "italy", Configured: true, Schedule: "15 1,4,7 * * *", Enabled: true, Feeds: 1}
+
+	tests := []struct {
+		form   url.Values
+		status int
+		// the job after the request
+		want data.Job
+	}{
+		{url.Values{"job": {"italy"}, "action": {"reset"}}, http.StatusFound, withJob(italy, func(j *data.Job) { j.Feeds = 0 })},
+		{url.Values{"job": {"italy"}, "action": {"disable"}}, http.StatusFound, withJob(italy, func(j *data.Job) { j.Enabled = false })},
+		{url.Values{"job": {"italy"}, "action": {"schedule"}, "schedule": {" 0  */6 * * 1-5 "}}, http.StatusFound,
+			withJob(italy, func(j *data.Job) { j.ScheduleOverride = "0 */6 * * 1-5" })},
+		{url.Values{"job": {"italy"}, "action": {"schedule"}, "schedule": {"10 1,4,7 * * *"}}, http.StatusFound,
+			withJob(italy, func(j *data.Job) { j.ScheduleOverride = "10 1,4,7 * * *" })},
+		{url.Values{"job": {"italy"}, "action": {"schedule"}, "schedule": {"every hour"}}, http.StatusBadRequest, italy},
+		{url.Values{"job": {"italy"}, "action": {"schedule"}, "schedule": {"0 6 * *"}}, http.StatusBadRequest, italy},
+		{url.Values{"job": {"italy"}, "action": {"schedule"}, "schedule": {"0 6 * * * *"}}, http.StatusBadRequest, italy},
+		{url.Values{"job": {"rome"}, "action": {"disable"}}, http.StatusBadRequest, italy},
+		{url.Values{"job": {"italy"}, "action": {"delete"}}, http.StatusBadRequest, italy},
+		{url.Values{"job": {"italy"}}, http.StatusBadRequest, italy},
+	}
+
+	for _, tt := range tests {
+
+		job := italy
+		db.jobs = map[string]*data.Job{"italy": &job}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newAdminRequest(t, admin, tt.form))
+
+		if w.Code != tt.status {
+			t.Errorf("%v: status = %d, want %d", tt.form, w.Code, tt.status)
+		}
+		if job != tt.want {
+			t.Errorf("%v: job = %+v, want %+v", tt.form, job, tt.want)
+		}
+	}
+
+	// back to the schedule of the config, and enabled again
+	job := withJob(italy, func(j *data.Job) { j.Enabled = false; j.ScheduleOverride = "0 6 * * *" })
+	db.jobs = map[string]*data.Job{"italy": &job}
+	for _, form := range []url.Values{
+		{"job": {"italy"}, "action": {"schedule"}, "schedule": {""}},
+		{"job": {"italy"}, "action": {"enable"}},
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), newAdminRequest(t, admin, form))
+	}
+	if job != italy {
+		t.Errorf("job = %+v, want %+v", job, italy)
+	}
+
+	// the editors don't manage the jobs
+	editor := db.addUser(data.User{Username: "editor", Role: data.RoleEditor})
+	job = italy
+	db.jobs = map[string]*data.Job{"italy": &job}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, newAdminRequest(t, editor, url.Values{"job": {"italy"}, "action": {"disable"}}))
+	if w.Code != http.StatusForbidden || job != italy {
+		t.Errorf("editor: status = %d, job = %+v, want 403 and no change", w.Code, job)
+	}
+}
+
+// withJob returns the job after the change
+func withJob(job data.Job, change func(j *data.Job)) data.Job {
+	change(&job)
+	return job
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+)
+
+// ** Sessions **
+// The session is the JWT in the 'token' cookie. It lasts sessionTTL from the last request, as the middleware
+// renews the token while the user browses, revoking the old one, and the logout revokes it by its id (jti) in the
+// revoked_tokens table. The token carries the role of the user and the generation of the tokens of the user:
+// changing the role or the password, or disabling the user, starts a new generation, revoking all the sessions of the user.
+
+// name of the cookie of the token
+const tokenCookie = "token"
+
+// errInvalidToken is a token not signed by us, expired, revoked or missing the claims
+var errInvalidToken = errors.New("token isn't valid")
+
+// parseSessionTTL parses SESSION_TTL (e.g. '30m', '8h'), 30 minutes if not set
+func parseSessionTTL(s string) time.Duration {
+
+	if s == "" {
+		return 30 * time.Minute
+	}
+
+	ttl, err := time.ParseDuration(s)
+	if err != nil || ttl < time.Minute {
+		log.Fatal("SESSION_TTL must be a duration of at least 1m, e.g. 30m or 8h: ", s)
+	}
+
+	return ttl
+}
+
+// newToken returns the signed token of the user, valid for sessionTTL from now, and its claims
+func newToken(user *data.User, now time.Time) (string, *Claims, error) {
+
+	jti, err := randomString(16)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Create the JWT claims, which includes the username and expiry time
+	claims := &Claims{
+		Username:   user.Username,
+		UserID:     user.ID,
+		Role:       user.Role,
+		Generation: user.TokenGeneration,
+		StandardClaims: jwt.StandardClaims{
+			// the id to revoke the token at the logout
+			Id:       jti,
+			IssuedAt: now.Unix(),
+			// in JWT expire time is expressed in Unix seconds
+			ExpiresAt: now.Add(sessionTTL).Unix(),
+		},
+	}
+
+	// declare the token with Signign algorithm and claims, then create the Token String
+	tokenString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return tokenString, claims, nil
+}
+
+// startSession sets the cookie with a new token of the user
+func startSession(w http.ResponseWriter, user *data.User, now time.Time) (*Claims, error) {
+
+	tokenString, claims, err := newToken(user, now)
+	if err != nil {
+		return nil, err
+	}
+
+	setTokenCookie(w, tokenString, time.Unix(claims.ExpiresAt, 0))
+
+	return claims, nil
+}
+
+// setTokenCookie sets the cookie of the token, out of reach of the scripts (HttpOnly) and of the other sites (SameSite).
+// It's sent over HTTPS only unless COOKIE_SECURE=false.
+func setTokenCookie(w http.ResponseWriter, value string, expires time.Time) {
+
+	cookie := &http.Cookie{
+		Name:     tokenCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+// parseToken checks the token and returns its claims: errInvalidToken if it isn't valid or it's revoked,
+// other errors if the revoked tokens cannot be read
+func parseToken(tokenStr string) (*Claims, error) {
+
+	// Parse the JWT string and store it in claims
+	// this method will return error if token is expired or key doesn't match
+	claims := &Claims{}
+	tkn, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return jwtKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidToken, err)
+	}
+
+	// the user id, the jti and the role are missing in the tokens issued before the users table, the logout and the roles
+	if !tkn.Valid || claims.UserID == 0 || claims.Id == "" || !data.IsRole(claims.Role) {
+		return nil, errInvalidToken
+	}
+
+	revoked, err := myDB.IsTokenRevoked(claims.Id, claims.UserID, claims.Generation)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("%w: revoked", errInvalidToken)
+	}
+
+	return claims, nil
+}
+
+// checkSession checks the token of the request and renews it when due (see renewSession), returning the user
+// of the request only, for the handlers. errInvalidToken if the token isn't valid or is revoked, other errors if the DB fails.
+func checkSession(w http.ResponseWriter, tokenStr string, now time.Time) (*LoggedUser, error) {
+
+	claims, err := parseToken(tokenStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// sliding session: a new token when the current one is halfway to expiry
+	claims, err = renewSession(w, claims, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoggedUser{
+		ID:         claims.UserID,
+		Username:   claims.Username,
+		Role:       claims.Role,
+		ExpiresAt:  claims.ExpiresAt,
+		LastAccess: now.Unix(),
+	}, nil
+}
+
+// renewSession replaces the token once half of its time is gone, so the session lasts as long as the user browses.
+// The new token has the current role of the user, errInvalidToken if the user is disabled or deleted meanwhile.
+// The old token is revoked, not to be used again after the logout of the new one.
+func renewSession(w http.ResponseWriter, claims *Claims, now time.Time) (*Claims, error) {
+
+	if time.Unix(claims.ExpiresAt, 0).Sub(now) > sessionTTL/2 {
+		return claims, nil
+	}
+
+	user, err := myDB.GetUser(claims.Username)
+	if errors.Is(err, data.ErrNotFound) || (err == nil && (user.ID != claims.UserID || user.Disabled || user.MustChangePassword)) {
+		return nil, fmt.Errorf("%w: user '%s' disabled, deleted or to change the password", errInvalidToken, claims.Username)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	renewed, err := startSession(w, user, now)
+	if err != nil {
+		log.Println("Error renewing the token => ", err)
+		return claims, nil
+	}
+
+	if err := myDB.RevokeToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		log.Println("Error revoking the renewed token => ", err)
+	}
+
+	return renewed, nil
+}
+
+// logout revokes the token of the session and clears the cookie, then goes back to the login
+func logout(w http.ResponseWriter, r *http.Request) {
+
+	// log the request
+	log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+
+	// a POST from our form only (see SameSite), not to be logged out by a link
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if c, err := r.Cookie(tokenCookie); err == nil {
+		claims, err := parseToken(c.Value)
+		if err == nil {
+			if err := myDB.RevokeToken(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+				log.Println("Error revoking the token => ", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			log.Printf("User '%s' logged out.", claims.Username)
+		}
+	}
+
+	setTokenCookie(w, "", time.Unix(0, 0))
+
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// randomString returns n random bytes, base64 encoded for the URLs
+func randomString(n int) (string, error) {
+
+	random := make([]byte, n)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
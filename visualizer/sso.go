@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+	"github.com/mesmerai/news-aggregator/visualizer/oidc"
+)
+
+// ** Single sign-on **
+// Login with an OpenID Connect identity provider, alongside the username and password of auth, when OIDC_ISSUER is set.
+// /oidc/login sends the user to the provider, which sends them back to /oidc/callback (OIDC_REDIRECT_URL).
+// The users are matched by the email of the ID token, created at their first login, and their role follows
+// their groups at every login: admin in one of OIDC_ADMIN_GROUPS, editor in one of OIDC_EDITOR_GROUPS, viewer otherwise.
+
+// name of the cookie keeping the state of the login until the callback
+const ssoCookie = "oidc_login"
+
+// how long the user has to log in on the provider
+const ssoLoginTTL = 10 * time.Minute
+
+// ssoLoginClaims is the state of the login, signed with jwtKey in the ssoCookie
+type ssoLoginClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.StandardClaims
+}
+
+// newSSO returns the provider of the OIDC_* env, nil when OIDC_ISSUER isn't set
+func newSSO(env map[string]string) *oidc.Provider {
+
+	if env["oidc_issuer"] == "" {
+		return nil
+	}
+
+	if env["oidc_client_id"] == "" || env["oidc_redirect_url"] == "" {
+		log.Fatal("OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set with OIDC_ISSUER.")
+	}
+
+	return oidc.NewProvider(oidc.Config{
+		Issuer:       env["oidc_issuer"],
+		ClientID:     env["oidc_client_id"],
+		ClientSecret: env["oidc_client_secret"],
+		RedirectURL:  env["oidc_redirect_url"],
+		Scopes:       strings.Fields(env["oidc_scopes"]),
+	}, &http.Client{Timeout: 10 * time.Second})
+}
+
+// splitGroups returns the groups of a comma separated list
+func splitGroups(list string) []string {
+
+	var groups []string
+	for _, g := range strings.Split(list, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+
+	return groups
+}
+
+// ssoRole returns the role of the user in the groups
+func ssoRole(groups []string) string {
+
+	in := func(roleGroups []string) bool {
+		for _, g := range groups {
+			for _, rg := range roleGroups {
+				if g == rg {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	if in(ssoAdminGroups) {
+		return data.RoleAdmin
+	}
+	if in(ssoEditorGroups) {
+		return data.RoleEditor
+	}
+
+	return data.RoleViewer
+}
+
+// ssoUser returns the user of the identity, created at the first login, with the role of the groups
+func ssoUser(id *oidc.IDToken) (*data.User, error) {
+
+	role := ssoRole(id.Groups)
+
+	user, err := myDB.GetUserByEmail(id.Email)
+	if errors.Is(err, data.ErrNotFound) {
+		log.Printf("Creating the single sign-on user '%s', %s.", id.Email, role)
+		return myDB.CreateSSOUser(id.Email, role)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Role != role {
+		log.Printf("Role of '%s' changed from %s to %s.", user.Username, user.Role, role)
+		if err := myDB.SetUserRole(user.Username, role); err != nil {
+			return nil, err
+		}
+		// with the new generation of tokens
+		return myDB.GetUserByEmail(id.Email)
+	}
+
+	return user, nil
+}
+
+// ssoLogin sends the user to the provider, keeping the state of the login in the ssoCookie
+func ssoLogin(w http.ResponseWriter, r *http.Request) {
+
+	// log the request
+	log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+
+	claims := &ssoLoginClaims{StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(ssoLoginTTL).Unix()}}
+	for _, secret := range []*string{&claims.State, &claims.Nonce, &claims.Verifier} {
+		s, err := oidc.NewSecret()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		*secret = s
+	}
+
+	loginState, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtKey)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := sso.AuthCodeURL(r.Context(), claims.State, claims.Nonce, claims.Verifier)
+	if err != nil {
+		log.Println("Error starting the single sign-on => ", err)
+		renderLogin(w, http.StatusBadGateway, "The identity provider isn't available, try again later.")
+		return
+	}
+
+	// Lax, to come back with the redirect of the provider
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoCookie,
+		Value:    loginState,
+		Path:     "/oidc/",
+		Expires:  time.Unix(claims.ExpiresAt, 0),
+		HttpOnly: true,
+		Secure:   cookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// ssoCallback logs in the user sent back by the provider with the code
+func ssoCallback(w http.ResponseWriter, r *http.Request) {
+
+	// log the request
+	log.Printf("%s %s %s\n", r.RemoteAddr, r.Method, r.URL)
+
+	// the state is of one login only
+	http.SetCookie(w, &http.Cookie{Name: ssoCookie, Path: "/oidc/", MaxAge: -1, HttpOnly: true, Secure: cookieSecure, SameSite: http.SameSiteLaxMode})
+
+	params := r.URL.Query()
+
+	if params.Get("error") != "" {
+		log.Printf("Single sign-on refused => %s %s", params.Get("error"), params.Get("error_description"))
+		renderLogin(w, http.StatusUnauthorized, "Login refused by the identity provider.")
+		return
+	}
+
+	claims := &ssoLoginClaims{}
+	c, err := r.Cookie(ssoCookie)
+	if err == nil {
+		_, err = jwt.ParseWithClaims(c.Value, claims, func(token *jwt.Token) (interface{}, error) {
+			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+			}
+			return jwtKey, nil
+		})
+	}
+	if err != nil || params.Get("state") == "" || params.Get("state") != claims.State {
+		log.Printf("Single sign-on callback without a valid state => %v", err)
+		renderLogin(w, http.StatusBadRequest, "Login expired, try again.")
+		return
+	}
+
+	id, err := sso.Login(r.Context(), params.Get("code"), claims.Verifier, claims.Nonce)
+	if errors.Is(err, oidc.ErrProvider) {
+		log.Println("Error logging in with the identity provider => ", err)
+		renderLogin(w, http.StatusBadGateway, "The identity provider isn't available, try again later.")
+		return
+	}
+	if err != nil {
+		log.Println("Single sign-on refused => ", err)
+		renderLogin(w, http.StatusUnauthorized, "Login refused by the identity provider.")
+		return
+	}
+
+	if id.Email == "" || !id.EmailVerified {
+		log.Printf("Single sign-on of '%s' refused => no verified email", id.Subject)
+		renderLogin(w, http.StatusForbidden, "The identity provider gave no verified email.")
+		return
+	}
+
+	user, err := ssoUser(id)
+	if errors.Is(err, data.ErrUserExists) {
+		log.Printf("Single sign-on of '%s' refused => username taken by a password user", id.Email)
+		renderLogin(w, http.StatusConflict, "A user with this name already exists, log in with the password.")
+		return
+	}
+	if err != nil {
+		log.Println("Error finding the single sign-on user => ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if user.Disabled {
+		log.Printf("Single sign-on of '%s' refused => disabled", user.Username)
+		renderLogin(w, http.StatusForbidden, "This user is disabled.")
+		return
+	}
+
+	if _, err := startSession(w, user, time.Now()); err != nil {
+		log.Println("Error creating the token => ", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("User '%s' logged in with the single sign-on.", user.Username)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
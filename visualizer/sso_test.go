@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/mesmerai/news-aggregator/visualizer/oidc"
+	"github.com/mesmerai/news-aggregator/visualizer/oidc/oidctest"
+)
+
+// newTestSSO sets sso to a mock issuer approving alice at once, and myDB to an empty store, until the end of the test
+func newTestSSO(t *testing.T) *oidctest.Server {
+
+	newTestStore(t)
+
+	ts, server := oidctest.NewServer("visualizer", "secret", oidctest.User{Subject: "alice-id", Email: "alice@example.com"})
+
+	sso = oidc.NewProvider(oidc.Config{
+		Issuer:       server.Issuer,
+		ClientID:     "visualizer",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:8080/oidc/callback",
+	}, ts.Client())
+
+	t.Cleanup(func() {
+		sso = nil
+		ts.Close()
+	})
+
+	return server
+}
+
+// ssoRequest goes through /oidc/login and the provider as the browser does,
+// returning the request of the callback with the cookie of the login
+func ssoRequest(t *testing.T) *http.Request {
+
+	w := httptest.NewRecorder()
+	ssoLogin(w, httptest.NewRequest(http.MethodGet, "/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("/oidc/login status = %d", w.Code)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	callback, err := res.Location()
+	if err != nil {
+		t.Fatalf("no redirect to the callback: %s", res.Status)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/oidc/callback?"+callback.RawQuery, nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	return r
+}
+
+// withParam returns the request with the query param set to the value
+func withParam(r *http.Request, name, value string) *http.Request {
+
+	params := r.URL.Query()
+	params.Set(name, value)
+
+	u := *r.URL
+	u.RawQuery = params.Encode()
+
+	r = r.Clone(r.Context())
+	r.URL = &u
+
+	return r
+}
+
+func TestSSOCallback(t *testing.T) {
+
+	newTestSSO(t)
+
+	w := httptest.NewRecorder()
+	ssoCallback(w, ssoRequest(t))
+
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/" {
+		t.Fatalf("status = %d, location = %s", w.Code, w.Header().Get("Location"))
+	}
+
+	var token string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == tokenCookie {
+			token = c.Value
+		}
+	}
+	claims, err := parseToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Username != "alice@example.com" {
+		t.Errorf("session of %q", claims.Username)
+	}
+}
+
+func TestSSOCallbackState(t *testing.T) {
+
+	newTestSSO(t)
+
+	noCookie := ssoRequest(t)
+	noCookie.Header.Del("Cookie")
+
+	// the state of another login
+	otherLogin := ssoRequest(t)
+	otherLogin.Header.Set("Cookie", ssoRequest(t).Header.Get("Cookie"))
+
+	tests := map[string]*http.Request{
+		"wrong state": withParam(ssoRequest(t), "state", "not the state"),
+		"no state":    withParam(ssoRequest(t), "state", ""),
+		"no cookie":   noCookie,
+		"other login": otherLogin,
+	}
+
+	// a login state of ours, not signed by us
+	forged := &ssoLoginClaims{State: "forged", Nonce: "nonce", Verifier: "verifier", StandardClaims: jwt.StandardClaims{ExpiresAt: time.Now().Add(time.Minute).Unix()}}
+	value, err := jwt.NewWithClaims(jwt.SigningMethodHS256, forged).SignedString([]byte("another key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	forgedCookie := withParam(ssoRequest(t), "state", "forged")
+	forgedCookie.Header.Set("Cookie", (&http.Cookie{Name: ssoCookie, Value: value}).String())
+	tests["forged cookie"] = forgedCookie
+
+	for name, r := range tests {
+		w := httptest.NewRecorder()
+		ssoCallback(w, r)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want %d", name, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestSSOCallbackRefused(t *testing.T) {
+
+	newTestSSO(t)
+
+	w := httptest.NewRecorder()
+	ssoCallback(w, withParam(ssoRequest(t), "error", "access_denied"))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("provider error: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	// the code of another login, the verifier of this one doesn't match
+	r := ssoRequest(t)
+	w = httptest.NewRecorder()
+	ssoCallback(w, withParam(r, "code", ssoRequest(t).URL.Query().Get("code")))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("code of another login: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSSOCallbackUnverifiedEmail(t *testing.T) {
+
+	server := newTestSSO(t)
+
+	for _, verified := range []interface{}{false, "false", nil} {
+
+		server.Claims = map[string]interface{}{"email_verified": verified}
+
+		w := httptest.NewRecorder()
+		ssoCallback(w, ssoRequest(t))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("email_verified %#v: status = %d, want %d", verified, w.Code, http.StatusForbidden)
+		}
+	}
+}
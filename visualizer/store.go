@@ -0,0 +1,56 @@
+package main
+
+import (
+	"time"
+
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+)
+
+// ** Store **
+// The handlers read and change the data through store, implemented by data.DBClient on Postgres
+// (the tests run them on an in-memory one).
+
+type store interface {
+	// ** articles **
+	GetArticles(limit int, pageCursor string, filter data.Filter, byRelevance bool) (*data.Results, error)
+	GetArticle(id int) (*data.Article, error)
+	CountArticles(filter data.Filter, approxAbove int) (count int, approximate bool, err error)
+	GetHistogram(filter data.Filter) (*data.Histogram, error)
+	GetCountries() ([]data.Country, error)
+	GetCategories(country string) ([]data.Category, error)
+	GetQuotas() ([]data.Quota, error)
+
+	// ** favourite feeds of the users **
+	GetFavouriteDomains(userID int) (*data.FavouriteDomains, error)
+	GetNotFavouriteDomains(userID int) (*data.NotFavouriteDomains, error)
+	CountFavouriteDomains(userID int) (int, error)
+	CountNotFavouriteDomains(userID int) (int, error)
+	CountArticlesGroupByFavourites(userID int) ([]data.ArticlePerFeed, error)
+	SetFavourites(userID int, dList []string) error
+	ResetFavourites(userID int) error
+	AdoptFavourites(username string) error
+
+	// ** users **
+	GetUser(username string) (*data.User, error)
+	GetUserByEmail(email string) (*data.User, error)
+	GetUsers() ([]data.User, error)
+	CountUsers() (int, error)
+	CreateUser(username, passwordHash, role string) error
+	CreateSSOUser(email, role string) (*data.User, error)
+	SetUserRole(username, role string) error
+	SetUserDisabled(username string, disabled bool) error
+	SetUserPassword(username, passwordHash string) error
+	RequirePasswordChange(username string) error
+	RecordLoginFailure(id, maxFailures int, lockFor time.Duration) (time.Time, error)
+	RecordLoginSuccess(id int) error
+
+	// ** sessions **
+	RevokeToken(jti string, expiresAt time.Time) error
+	IsTokenRevoked(jti string, userID, generation int) (bool, error)
+
+	// ** collector jobs **
+	GetJobs() ([]data.Job, error)
+	ResetJob(name string) error
+	SetJobEnabled(name string, enabled bool) error
+	SetJobSchedule(name, schedule string) error
+}
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+)
+
+// ** Fake store **
+// The handlers run on memStore, an in-memory store keeping the users, the favourites, the sessions and the jobs
+// as data.DBClient does. The articles it finds are named after the filter (country and words) and the page
+// cursors are the page numbers, so a page tells which search it was built for.
+
+type memStore struct {
+	mu sync.Mutex
+
+	// every call fails with err when set, as a DB down
+	err error
+
+	users      map[string]*data.User
+	lastID     int
+	domains    []string
+	favourites map[int]map[string]bool
+	revoked    map[string]bool
+	jobs       map[string]*data.Job
+	// the favourites reset and the jobs reset, changed or not, by name
+	resets []string
+}
+
+// newTestStore sets myDB to an empty memStore, for the test
+func newTestStore(t *testing.T) *memStore {
+
+	s := &memStore{
+		users:      map[string]*data.User{},
+		favourites: map[int]map[string]bool{},
+		revoked:    map[string]bool{},
+		jobs:       map[string]*data.Job{},
+	}
+
+	myDB = s
+	t.Cleanup(func() { myDB = nil })
+
+	return s
+}
+
+// addUser stores the user, with the next id, and returns a copy
+func (s *memStore) addUser(user data.User) *data.User {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	user.ID = s.lastID
+	s.users[user.Username] = &user
+
+	u := user
+	return &u
+}
+
+// user returns a copy of the user, nil if not found
+func (s *memStore) user(username string) *data.User {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[username]
+	if !ok {
+		return nil
+	}
+	copied := *u
+	return &copied
+}
+
+func (s *memStore) findUser(username string) (*data.User, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	u, ok := s.users[username]
+	if !ok {
+		return nil, data.ErrNotFound
+	}
+	return u, nil
+}
+
+// ** articles **
+
+func (s *memStore) GetArticles(limit int, pageCursor string, filter data.Filter, byRelevance bool) (*data.Results, error) {
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	page := 1
+	if pageCursor != "" {
+		var err error
+		if page, err = strconv.Atoi(pageCursor); err != nil || page < 2 {
+			return nil, data.ErrCursor
+		}
+	}
+
+	searched := []string{}
+	if filter.Country != "" {
+		searched = append(searched, filter.Country)
+	}
+	if filter.Query != nil {
+		for _, t := range filter.Query.Terms {
+			if t.Field == "" {
+				searched = append(searched, t.Value)
+			}
+		}
+	}
+	title := "news " + strings.Join(searched, " ")
+
+	res := &data.Results{Status: "ok", Page: page, NextCursor: strconv.Itoa(page + 1)}
+	if page > 2 {
+		res.PreviousCursor = strconv.Itoa(page - 1)
+	}
+
+	published := time.Date(2021, 11, 15, 12, 0, 0, 0, time.UTC)
+	for i := 1; i <= limit; i++ {
+		n := (page-1)*limit + i
+		res.Articles = append(res.Articles, data.Article{ID: strconv.Itoa(1000 - n), Title: title, Source: "ANSA.it", Domain: "ansa.it",
+			URL: fmt.Sprintf("https://www.ansa.it/%d.html", n), PublishedAt: published.Add(-time.Duration(n) * time.Hour)})
+	}
+
+	return res, nil
+}
+
+func (s *memStore) GetArticle(id int) (*data.Article, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return nil, data.ErrNotFound
+}
+
+func (s *memStore) CountArticles(filter data.Filter, approxAbove int) (int, bool, error) {
+	return 50, false, s.err
+}
+
+func (s *memStore) GetHistogram(filter data.Filter) (*data.Histogram, error) {
+	return nil, s.err
+}
+
+func (s *memStore) GetCountries() ([]data.Country, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return []data.Country{{Code: "au", Name: "Australia", Flag: "🇦🇺"}, {Code: "it", Name: "Italy", Flag: "🇮🇹"}}, nil
+}
+
+func (s *memStore) GetCategories(country string) ([]data.Category, error) {
+	return nil, s.err
+}
+
+func (s *memStore) GetQuotas() ([]data.Quota, error) {
+	return nil, s.err
+}
+
+// ** favourite feeds of the users **
+
+// favouriteDomains returns the domains by name, the favourites of the user or the others
+func (s *memStore) favouriteDomains(userID int, favourite bool) []data.Domain {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var domains []data.Domain
+	for i, name := range s.domains {
+		if s.favourites[userID][name] == favourite {
+			domains = append(domains, data.Domain{ID: i + 1, Name: name, Favourite: favourite})
+		}
+	}
+
+	return domains
+}
+
+func (s *memStore) GetFavouriteDomains(userID int) (*data.FavouriteDomains, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &data.FavouriteDomains{Domains: s.favouriteDomains(userID, true)}, nil
+}
+
+func (s *memStore) GetNotFavouriteDomains(userID int) (*data.NotFavouriteDomains, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &data.NotFavouriteDomains{Domains: s.favouriteDomains(userID, false)}, nil
+}
+
+func (s *memStore) CountFavouriteDomains(userID int) (int, error) {
+	return len(s.favouriteDomains(userID, true)), s.err
+}
+
+func (s *memStore) CountNotFavouriteDomains(userID int) (int, error) {
+	return len(s.favouriteDomains(userID, false)), s.err
+}
+
+func (s *memStore) CountArticlesGroupByFavourites(userID int) ([]data.ArticlePerFeed, error) {
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var perFeed []data.ArticlePerFeed
+	for _, d := range s.favouriteDomains(userID, true) {
+		perFeed = append(perFeed, data.ArticlePerFeed{ArticlesCount: 10, FeedName: d.Name})
+	}
+
+	return perFeed, nil
+}
+
+func (s *memStore) SetFavourites(userID int, dList []string) error {
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.favourites[userID] == nil {
+		s.favourites[userID] = map[string]bool{}
+	}
+	// the domains collected only
+	for _, name := range s.domains {
+		for _, favourite := range dList {
+			if name == favourite {
+				s.favourites[userID][name] = true
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *memStore) ResetFavourites(userID int) error {
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.favourites, userID)
+	s.resets = append(s.resets, fmt.Sprintf("favourites of %d", userID))
+
+	return nil
+}
+
+func (s *memStore) AdoptFavourites(username string) error {
+	return s.err
+}
+
+// ** users **
+
+func (s *memStore) GetUser(username string) (*data.User, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.findUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *u
+	return &copied, nil
+}
+
+func (s *memStore) GetUserByEmail(email string) (*data.User, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	for _, u := range s.users {
+		if u.Email != "" && strings.EqualFold(u.Email, email) {
+			copied := *u
+			return &copied, nil
+		}
+	}
+
+	return nil, data.ErrNotFound
+}
+
+func (s *memStore) GetUsers() ([]data.User, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var users []data.User
+	for _, u := range s.users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+
+	return users, nil
+}
+
+func (s *memStore) CountUsers() (int, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.users), s.err
+}
+
+func (s *memStore) CreateUser(username, passwordHash, role string) error {
+
+	if s.err != nil {
+		return s.err
+	}
+	if s.user(username) != nil {
+		return data.ErrUserExists
+	}
+
+	s.addUser(data.User{Username: username, PasswordHash: passwordHash, Role: role})
+
+	return nil
+}
+
+func (s *memStore) CreateSSOUser(email, role string) (*data.User, error) {
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.user(email) != nil {
+		return nil, data.ErrUserExists
+	}
+
+	return s.addUser(data.User{Username: email, Email: email, Role: role}), nil
+}
+
+// updateUser changes the user, ErrNotFound if there's none
+func (s *memStore) updateUser(username string, change func(u *data.User)) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, err := s.findUser(username)
+	if err != nil {
+		return err
+	}
+	change(u)
+
+	return nil
+}
+
+func (s *memStore) SetUserRole(username, role string) error {
+	return s.updateUser(username, func(u *data.User) {
+		if u.Role != role {
+			u.TokenGeneration++
+		}
+		u.Role = role
+	})
+}
+
+func (s *memStore) SetUserDisabled(username string, disabled bool) error {
+	return s.updateUser(username, func(u *data.User) {
+		if disabled {
+			u.TokenGeneration++
+		}
+		u.Disabled = disabled
+	})
+}
+
+func (s *memStore) SetUserPassword(username, passwordHash string) error {
+	return s.updateUser(username, func(u *data.User) {
+		u.PasswordHash = passwordHash
+		u.FailedLogins = 0
+		u.LockedUntil = time.Time{}
+		u.MustChangePassword = false
+		u.TokenGeneration++
+	})
+}
+
+func (s *memStore) RequirePasswordChange(username string) error {
+	return s.updateUser(username, func(u *data.User) {
+		u.MustChangePassword = true
+		u.TokenGeneration++
+	})
+}
+
+// userByID returns the user with the id, nil if not found
+func (s *memStore) userByID(id int) *data.User {
+	for _, u := range s.users {
+		if u.ID == id {
+			return u
+		}
+	}
+	return nil
+}
+
+func (s *memStore) RecordLoginFailure(id, maxFailures int, lockFor time.Duration) (time.Time, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return time.Time{}, s.err
+	}
+	u := s.userByID(id)
+	if u == nil {
+		return time.Time{}, data.ErrNotFound
+	}
+
+	u.FailedLogins++
+	if u.FailedLogins >= maxFailures {
+		u.LockedUntil = time.Now().Add(lockFor)
+		u.FailedLogins = 0
+	}
+
+	return u.LockedUntil, nil
+}
+
+func (s *memStore) RecordLoginSuccess(id int) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	if u := s.userByID(id); u != nil {
+		u.FailedLogins = 0
+		u.LockedUntil = time.Time{}
+	}
+
+	return nil
+}
+
+// ** sessions **
+
+func (s *memStore) RevokeToken(jti string, expiresAt time.Time) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	s.revoked[jti] = true
+
+	return nil
+}
+
+func (s *memStore) IsTokenRevoked(jti string, userID, generation int) (bool, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return false, s.err
+	}
+
+	u := s.userByID(userID)
+	return s.revoked[jti] || u == nil || u.TokenGeneration != generation || u.Disabled || u.MustChangePassword, nil
+}
+
+// ** collector jobs **
+
+func (s *memStore) GetJobs() ([]data.Job, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	var jobs []data.Job
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Name < jobs[k].Name })
+
+	return jobs, nil
+}
+
+// updateJob changes the job, ErrNotFound if there's none
+func (s *memStore) updateJob(name string, change func(j *data.Job)) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+	j, ok := s.jobs[name]
+	if !ok {
+		return data.ErrNotFound
+	}
+	change(j)
+
+	return nil
+}
+
+func (s *memStore) ResetJob(name string) error {
+	return s.updateJob(name, func(j *data.Job) {
+		j.Feeds = 0
+		s.resets = append(s.resets, "job "+name)
+	})
+}
+
+func (s *memStore) SetJobEnabled(name string, enabled bool) error {
+	return s.updateJob(name, func(j *data.Job) { j.Enabled = enabled })
+}
+
+func (s *memStore) SetJobSchedule(name, schedule string) error {
+	return s.updateJob(name, func(j *data.Job) { j.ScheduleOverride = schedule })
+}
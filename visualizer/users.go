@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ** Users **
+// The accounts are in the users table with the bcrypt hash of the password,
+// managed with the 'user' command (see runUserCommand).
+
+// after maxLoginFailures wrong passwords in a row the account is locked for lockoutDuration
+var maxLoginFailures = 5
+var lockoutDuration = 15 * time.Minute
+
+const minPasswordLength = 8
+
+var errWrongCredentials = errors.New("wrong username or password")
+
+// the hash the passwords of the unknown users are checked against, see authenticate
+var dummyHash []byte
+var dummyHashOnce sync.Once
+
+// authenticate checks the password of the user, counting the failures to lock the account.
+// The unknown, the single sign-on, the disabled and the locked users get errWrongCredentials as a wrong password,
+// after comparing a hash as well (bcrypt compares in constant time), not to tell the usernames apart.
+func authenticate(username, password string, now time.Time) (*data.User, error) {
+
+	user, err := myDB.GetUser(username)
+	// the single sign-on users have no password, as the unknown ones
+	if err == nil && user.PasswordHash == "" {
+		err = data.ErrNotFound
+	}
+	if errors.Is(err, data.ErrNotFound) {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, errWrongCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	passwordErr := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
+
+	// no more attempts until the lock expires, the right password included
+	if user.Locked(now) {
+		log.Printf("Login of '%s' refused => account locked until %v", username, user.LockedUntil)
+		return nil, errWrongCredentials
+	}
+
+	if passwordErr != nil {
+		lockedUntil, err := myDB.RecordLoginFailure(user.ID, maxLoginFailures, lockoutDuration)
+		if err != nil {
+			return nil, err
+		}
+		if lockedUntil.After(now) {
+			log.Printf("Account '%s' locked until %v after %d failed logins.", username, lockedUntil, maxLoginFailures)
+		}
+		return nil, errWrongCredentials
+	}
+
+	if user.Disabled {
+		return nil, errWrongCredentials
+	}
+
+	if err := myDB.RecordLoginSuccess(user.ID); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// hashPassword returns the bcrypt hash of the password
+func hashPassword(password string) (string, error) {
+
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("the password must be at least %d characters", minPasswordLength)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+// bootstrapUser creates the first user from web_user and USER_AUTH when there are no users yet,
+// so the deployments keep their login until the accounts are created with the 'user' command
+func bootstrapUser() {
+
+	count, err := myDB.CountUsers()
+	if err != nil {
+		log.Fatal("Error counting the users => ", err)
+	}
+
+	if count > 0 {
+		return
+	}
+	if web_password == "" {
+		log.Fatal("USER_AUTH is not set in ENV, it's the password of the first user.")
+	}
+
+	// a short USER_AUTH still lets the admin in, to choose a longer password at the first login
+	mustChange := len(web_password) < minPasswordLength
+	if mustChange {
+		log.Printf("Warning: USER_AUTH is shorter than %d characters, '%s' must change the password at the first login.", minPasswordLength, web_user)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(web_password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Fatal("Error creating the user from USER_AUTH => ", err)
+	}
+
+	// the first user is the admin of the others
+	if err := myDB.CreateUser(web_user, string(hash), data.RoleAdmin); err != nil && !errors.Is(err, data.ErrUserExists) {
+		log.Fatal("Error creating the user from USER_AUTH => ", err)
+	}
+	if mustChange {
+		if err := myDB.RequirePasswordChange(web_user); err != nil {
+			log.Fatal("Error creating the user from USER_AUTH => ", err)
+		}
+	}
+
+	// the favourites saved before the users table are the ones of the first user
+	if err := myDB.AdoptFavourites(web_user); err != nil {
+		log.Fatal("Error adopting the favourites => ", err)
+	}
+
+	log.Printf("User '%s' created from USER_AUTH.", web_user)
+}
+
+// runUserCommand runs the admin command on the users:
+//
+//	visualizer user create [-password-stdin] [-role viewer|editor|admin] <username>
+//	visualizer user reset-password [-password-stdin] <username>
+//	visualizer user set-role -role viewer|editor|admin <username>
+//	visualizer user disable <username>
+//	visualizer user enable <username>
+//
+// The password is read from the first line of stdin with -password-stdin, otherwise a random one is printed.
+// The users are created as viewers unless -role is given.
+func runUserCommand(args []string, stdin io.Reader, stdout io.Writer) error {
+
+	usage := "usage: visualizer user create|reset-password|set-role|disable|enable [-password-stdin] [-role viewer|editor|admin] <username>"
+
+	if len(args) < 1 {
+		return errors.New(usage)
+	}
+	action := args[0]
+
+	flags := flag.NewFlagSet("user "+action, flag.ContinueOnError)
+	passwordStdin := flags.Bool("password-stdin", false, "read the password from the first line of stdin")
+	role := flags.String("role", data.RoleViewer, "role of the user: viewer, editor or admin")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New(usage)
+	}
+	username := flags.Arg(0)
+
+	if !data.IsRole(*role) {
+		return fmt.Errorf("'%s' isn't a role: %s", *role, strings.Join(data.Roles, ", "))
+	}
+
+	switch action {
+	case "create", "reset-password":
+		password, generated, err := newPassword(*passwordStdin, stdin)
+		if err != nil {
+			return err
+		}
+		hash, err := hashPassword(password)
+		if err != nil {
+			return err
+		}
+
+		if action == "create" {
+			err = myDB.CreateUser(username, hash, *role)
+		} else {
+			err = myDB.SetUserPassword(username, hash)
+		}
+		if err != nil {
+			return fmt.Errorf("%s '%s' => %w", action, username, err)
+		}
+
+		if generated {
+			fmt.Fprintf(stdout, "Password of '%s': %s\n", username, password)
+		}
+	case "set-role":
+		roleSet := false
+		flags.Visit(func(f *flag.Flag) { roleSet = roleSet || f.Name == "role" })
+		if !roleSet {
+			return errors.New(usage)
+		}
+		if err := myDB.SetUserRole(username, *role); err != nil {
+			return fmt.Errorf("%s '%s' => %w", action, username, err)
+		}
+	case "disable", "enable":
+		if err := myDB.SetUserDisabled(username, action == "disable"); err != nil {
+			return fmt.Errorf("%s '%s' => %w", action, username, err)
+		}
+	default:
+		return errors.New(usage)
+	}
+
+	fmt.Fprintf(stdout, "User '%s': %s done.\n", username, action)
+
+	return nil
+}
+
+// newPassword reads the password from stdin or generates a random one
+func newPassword(fromStdin bool, stdin io.Reader) (password string, generated bool, err error) {
+
+	if fromStdin {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", false, err
+		}
+		return strings.TrimRight(line, "\r\n"), false, nil
+	}
+
+	password, err = randomString(16)
+	if err != nil {
+		return "", false, err
+	}
+
+	return password, true, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mesmerai/news-aggregator/visualizer/data"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuthenticate(t *testing.T) {
+
+	db := newTestStore(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("right password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.addUser(data.User{Username: "alice", PasswordHash: string(hash), Role: data.RoleViewer})
+	db.addUser(data.User{Username: "bob", PasswordHash: string(hash), Role: data.RoleViewer, Disabled: true})
+	db.addUser(data.User{Username: "carol@example.com", Email: "carol@example.com", Role: data.RoleViewer})
+
+	now := time.Now()
+
+	// the unknown, the disabled and the single sign-on users are refused as a wrong password
+	for _, tt := range []struct{ username, password string }{
+		{"alice", "wrong password"},
+		{"nobody", "right password"},
+		{"bob", "right password"},
+		{"carol@example.com", ""},
+	} {
+		if _, err := authenticate(tt.username, tt.password, now); err != errWrongCredentials {
+			t.Errorf("%s: err = %v, want errWrongCredentials", tt.username, err)
+		}
+	}
+
+	user, err := authenticate("alice", "right password", now)
+	if err != nil || user.Username != "alice" {
+		t.Fatalf("alice: user = %+v, err = %v", user, err)
+	}
+	if failures := db.user("alice").FailedLogins; failures != 0 {
+		t.Errorf("failed logins = %d after the login, want 0", failures)
+	}
+
+	// the account is locked after maxLoginFailures wrong passwords in a row
+	for i := 0; i < maxLoginFailures; i++ {
+		authenticate("alice", "wrong password", now)
+	}
+	lockedUntil := db.user("alice").LockedUntil
+	if !lockedUntil.After(now) {
+		t.Fatalf("locked until %v after %d failures", lockedUntil, maxLoginFailures)
+	}
+
+	// the right password is refused with the same error of a wrong one, not to tell the password is right
+	if _, err := authenticate("alice", "right password", now); err != errWrongCredentials {
+		t.Errorf("locked: err = %v, want errWrongCredentials", err)
+	}
+	// and it doesn't extend the lock
+	if until := db.user("alice").LockedUntil; !until.Equal(lockedUntil) {
+		t.Errorf("locked until %v, want %v", until, lockedUntil)
+	}
+
+	// the login works again when the lock expires
+	if _, err := authenticate("alice", "right password", lockedUntil.Add(time.Second)); err != nil {
+		t.Errorf("lock expired: err = %v", err)
+	}
+
+	// the DB errors are not wrong credentials
+	db.err = errors.New("pq: connection refused")
+	if _, err := authenticate("alice", "right password", now); err == nil || err == errWrongCredentials {
+		t.Errorf("DB down: err = %v", err)
+	}
+}